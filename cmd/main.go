@@ -5,6 +5,9 @@ import (
 	"kepler-auth-go/internal/api"
 	"kepler-auth-go/internal/config"
 	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/ratelimit"
+	"kepler-auth-go/internal/tokens"
 	"log"
 )
 
@@ -30,6 +33,9 @@ import (
 
 func main() {
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
 
 	if err := database.Connect(cfg); err != nil {
 		log.Fatal("Failed to connect to database:", err)
@@ -43,6 +49,25 @@ func main() {
 		log.Printf("Warning: Failed to seed default data: %v", err)
 	}
 
+	if err := tokens.LoadKeys(cfg); err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	tokens.GetKeyManager().StartRotation()
+
+	ratelimit.Configure(cfg)
+
+	if err := password.Configure(cfg); err != nil {
+		log.Fatal("Invalid password hashing settings:", err)
+	}
+
+	if cfg.PasswordPolicy.BreachedDataset != "" {
+		source, err := password.LoadBreachedFile(cfg.PasswordPolicy.BreachedDataset)
+		if err != nil {
+			log.Fatal("Failed to load breached password dataset:", err)
+		}
+		password.SetBreachedSource(source)
+	}
+
 	server := api.NewServer(cfg)
 	router := server.SetupRouter()
 
@@ -2,6 +2,7 @@ package api
 
 import (
 	"kepler-auth-go/internal/middleware"
+	"kepler-auth-go/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,7 +16,12 @@ func (s *Server) setupRoutes(r *gin.Engine) {
 		s.setupGroupRoutes(api)
 		s.setupPermissionRoutes(api)
 		s.setupOrganizationRoutes(api)
+		s.setupOAuthClientRoutes(api)
+		s.setupSAMLRoutes(api)
+		s.setupFederationRoutes(api)
 	}
+
+	s.setupOAuthRoutes(r)
 }
 
 func (s *Server) setupAuthRoutes(api *gin.RouterGroup) {
@@ -23,13 +29,48 @@ func (s *Server) setupAuthRoutes(api *gin.RouterGroup) {
 	{
 		auth.POST("/register", s.authHandler.Register)
 		auth.POST("/login", s.authHandler.Login)
+		auth.POST("/discover", s.authHandler.DiscoverOrganization)
+		auth.POST("/login/mfa", s.authHandler.LoginMFA)
+		auth.POST("/refresh", s.authHandler.Refresh)
+		auth.POST("/password-reset", s.authHandler.RequestPasswordReset)
+		auth.POST("/password-reset/confirm", s.authHandler.ConfirmPasswordReset)
+		auth.GET("/verify-email", s.authHandler.VerifyEmail)
+		auth.POST("/verify-email", s.authHandler.VerifyEmail)
+		auth.POST("/verify-email/resend", s.authHandler.ResendVerification)
+		auth.POST("/magic-link", s.authHandler.RequestMagicLink)
+		auth.POST("/magic-link/verify", s.authHandler.VerifyMagicLink)
+		auth.POST("/webauthn/login/begin", s.webAuthnHandler.BeginLogin)
+		auth.POST("/webauthn/login/finish", s.webAuthnHandler.FinishLogin)
+		auth.POST("/invitations/accept", s.invitationHandler.AcceptInvitation)
+		auth.POST("/saml/exchange", s.samlHandler.Exchange)
+		auth.POST("/federation/exchange", s.federationHandler.Exchange)
 
 		authenticated := auth.Group("/")
 		authenticated.Use(middleware.AuthRequired(s.cfg))
 		{
 			authenticated.GET("/me", s.authHandler.GetMe)
 			authenticated.PATCH("/me", s.authHandler.UpdateMe)
+			authenticated.GET("/me/identities", s.federationHandler.GetIdentities)
+			authenticated.POST("/me/identities", s.federationHandler.LinkIdentity)
+			authenticated.DELETE("/me/identities/:id", s.federationHandler.UnlinkIdentity)
 			authenticated.POST("/change-password", s.authHandler.ChangePassword)
+			authenticated.POST("/logout", s.authHandler.Logout)
+			authenticated.POST("/logout-all", s.authHandler.LogoutAll)
+			authenticated.GET("/organizations", s.authHandler.GetMyOrganizations)
+			authenticated.POST("/switch-organization", s.authHandler.SwitchOrganization)
+			authenticated.POST("/saml/link", s.samlHandler.Link)
+			authenticated.POST("/saml/logout", s.samlHandler.Logout)
+
+			authenticated.POST("/mfa/totp/setup", s.mfaHandler.SetupTOTP)
+			authenticated.POST("/mfa/totp/verify", s.mfaHandler.VerifyTOTP)
+			authenticated.POST("/mfa/totp/disable", s.mfaHandler.DisableTOTP)
+			authenticated.POST("/mfa/recovery-codes", s.mfaHandler.RegenerateRecoveryCodes)
+
+			authenticated.POST("/webauthn/register/begin", s.webAuthnHandler.BeginRegistration)
+			authenticated.POST("/webauthn/register/finish", s.webAuthnHandler.FinishRegistration)
+			authenticated.GET("/webauthn/credentials", s.webAuthnHandler.GetCredentials)
+			authenticated.PATCH("/webauthn/credentials/:id", s.webAuthnHandler.UpdateCredential)
+			authenticated.DELETE("/webauthn/credentials/:id", s.webAuthnHandler.DeleteCredential)
 		}
 	}
 }
@@ -37,76 +78,157 @@ func (s *Server) setupAuthRoutes(api *gin.RouterGroup) {
 func (s *Server) setupUserRoutes(api *gin.RouterGroup) {
 	users := api.Group("/users")
 	users.Use(middleware.AuthRequired(s.cfg))
+	users.Use(middleware.MFACompliant(s.cfg))
+	users.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		users.GET("", s.userHandler.GetUsers)
-		users.GET("/:id", s.userHandler.GetUser)
-
-		adminRequired := users.Group("/")
-		adminRequired.Use(middleware.AdminRequired())
-		{
-			adminRequired.PATCH("/:id", s.userHandler.UpdateUser)
-			adminRequired.DELETE("/:id", s.userHandler.DeleteUser)
-		}
+		users.GET("", middleware.RequirePermission("view_user"), s.userHandler.GetUsers)
+		users.GET("/:id", middleware.RequirePermission("view_user"), s.userHandler.GetUser)
+		users.PATCH("/:id", middleware.RequirePermission("change_user"), s.userHandler.UpdateUser)
+		users.DELETE("/:id", middleware.RequirePermission("delete_user"), s.userHandler.DeleteUser)
+		users.POST("/:id/unlock", middleware.RequirePermission("change_user"), s.userHandler.UnlockUser)
+		users.PUT("/:id/groups", middleware.RequirePermission("change_group"), s.userHandler.SetUserGroups)
 	}
 }
 
 func (s *Server) setupEmailRoutes(api *gin.RouterGroup) {
 	email := api.Group("/email")
 	email.Use(middleware.AuthRequired(s.cfg))
+	email.Use(middleware.MFACompliant(s.cfg))
+	email.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		email.POST("/send", s.emailHandler.SendEmail)
+		email.POST("/send", middleware.RequirePermission("send_email"), s.emailHandler.SendEmail)
 	}
 }
 
 func (s *Server) setupGroupRoutes(api *gin.RouterGroup) {
 	groups := api.Group("/groups")
 	groups.Use(middleware.AuthRequired(s.cfg))
+	groups.Use(middleware.MFACompliant(s.cfg))
+	groups.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		groups.GET("", s.groupHandler.GetGroups)
-		groups.GET("/:id", s.groupHandler.GetGroup)
-
-		adminRequired := groups.Group("/")
-		adminRequired.Use(middleware.AdminRequired())
-		{
-			adminRequired.POST("", s.groupHandler.CreateGroup)
-			adminRequired.PATCH("/:id", s.groupHandler.UpdateGroup)
-			adminRequired.DELETE("/:id", s.groupHandler.DeleteGroup)
-		}
+		groups.GET("", middleware.RequirePermission("view_group"), s.groupHandler.GetGroups)
+		groups.GET("/templates", middleware.RequirePermission("view_group"), s.groupHandler.GetGroupTemplates)
+		groups.GET("/:id", middleware.RequirePermission("view_group"), s.groupHandler.GetGroup)
+		groups.POST("", middleware.RequirePermission("add_group"), s.groupHandler.CreateGroup)
+		groups.PATCH("/:id", middleware.RequirePermission("change_group"), s.groupHandler.UpdateGroup)
+		groups.DELETE("/:id", middleware.RequirePermission("delete_group"), s.groupHandler.DeleteGroup)
+		groups.POST("/:id/clone", middleware.RequirePermission("add_group"), s.groupHandler.CloneGroupTemplate)
+		groups.GET("/:id/members", middleware.RequirePermission("view_group"), s.groupHandler.GetGroupMembers)
+		groups.POST("/:id/members", middleware.RequirePermission("change_group"), s.groupHandler.AddGroupMembers)
+		groups.DELETE("/:id/members", middleware.RequirePermission("change_group"), s.groupHandler.RemoveGroupMembers)
 	}
 }
 
 func (s *Server) setupPermissionRoutes(api *gin.RouterGroup) {
 	permissions := api.Group("/permissions")
 	permissions.Use(middleware.AuthRequired(s.cfg))
+	permissions.Use(middleware.MFACompliant(s.cfg))
+	permissions.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		permissions.GET("", s.permissionHandler.GetPermissions)
+		permissions.GET("", middleware.RequirePermission("view_permission"), s.permissionHandler.GetPermissions)
 	}
 
 	authGroups := api.Group("/auth-groups")
 	authGroups.Use(middleware.AuthRequired(s.cfg))
+	authGroups.Use(middleware.MFACompliant(s.cfg))
+	authGroups.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		authGroups.GET("", s.permissionHandler.GetAuthGroups)
-		authGroups.GET("/:id", s.permissionHandler.GetAuthGroup)
-
-		adminRequired := authGroups.Group("/")
-		adminRequired.Use(middleware.AdminRequired())
-		{
-			adminRequired.POST("", s.permissionHandler.CreateAuthGroup)
-			adminRequired.PATCH("/:id", s.permissionHandler.UpdateAuthGroup)
-			adminRequired.DELETE("/:id", s.permissionHandler.DeleteAuthGroup)
-		}
+		authGroups.GET("", middleware.RequirePermission("view_group"), s.permissionHandler.GetAuthGroups)
+		authGroups.GET("/:id", middleware.RequirePermission("view_group"), s.permissionHandler.GetAuthGroup)
+		authGroups.POST("", middleware.RequirePermission("add_group"), s.permissionHandler.CreateAuthGroup)
+		authGroups.PATCH("/:id", middleware.RequirePermission("change_group"), s.permissionHandler.UpdateAuthGroup)
+		authGroups.DELETE("/:id", middleware.RequirePermission("delete_group"), s.permissionHandler.DeleteAuthGroup)
 	}
 }
 
 func (s *Server) setupOrganizationRoutes(api *gin.RouterGroup) {
 	organizations := api.Group("/organizations")
 	organizations.Use(middleware.AuthRequired(s.cfg))
-	organizations.Use(middleware.AdminRequired()) // Only admins can manage organizations
+	organizations.Use(middleware.MFACompliant(s.cfg))
+	organizations.Use(middleware.PasswordNotExpired(s.cfg))
 	{
-		organizations.GET("", s.organizationHandler.GetOrganizations)
-		organizations.GET("/:id", s.organizationHandler.GetOrganization)
-		organizations.POST("", s.organizationHandler.CreateOrganization)
-		organizations.PATCH("/:id", s.organizationHandler.UpdateOrganization)
-		organizations.DELETE("/:id", s.organizationHandler.DeleteOrganization)
+		organizations.GET("", middleware.RequirePermission("view_organization"), s.organizationHandler.GetOrganizations)
+		organizations.GET("/:id", middleware.RequirePermission("view_organization"), s.organizationHandler.GetOrganization)
+		organizations.POST("", middleware.SuperuserRequired(), s.organizationHandler.CreateOrganization)
+		organizations.PATCH("/:id", middleware.RequirePermission("change_organization"), s.organizationHandler.UpdateOrganization)
+		organizations.DELETE("/:id", middleware.SuperuserRequired(), s.organizationHandler.DeleteOrganization)
+		organizations.GET("/:id/members", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.organizationHandler.GetOrganizationMembers)
+		organizations.PATCH("/:id/members/:user_id", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.organizationHandler.UpdateOrganizationMember)
+		organizations.GET("/:id/settings", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.settingsHandler.GetSettings)
+		organizations.PATCH("/:id/settings", middleware.RequireOrgRole(models.OrgRoleOwner), s.settingsHandler.UpdateSettings)
+		organizations.GET("/:id/domain", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.organizationHandler.GetDomainVerification)
+		organizations.POST("/:id/domain/verify", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.organizationHandler.VerifyDomain)
+		organizations.POST("/:id/invitations", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.invitationHandler.CreateInvitation)
+		organizations.GET("/:id/invitations", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.invitationHandler.GetInvitations)
+		organizations.POST("/:id/invitations/:invitation_id/resend", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.invitationHandler.ResendInvitation)
+		organizations.DELETE("/:id/invitations/:invitation_id", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.invitationHandler.RevokeInvitation)
+		organizations.GET("/:id/saml", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.samlHandler.GetConnection)
+		organizations.PUT("/:id/saml", middleware.RequireOrgRole(models.OrgRoleOwner), s.samlHandler.SaveConnection)
+		organizations.DELETE("/:id/saml", middleware.RequireOrgRole(models.OrgRoleOwner), s.samlHandler.DeleteConnection)
+	}
+}
+
+func (s *Server) setupSAMLRoutes(api *gin.RouterGroup) {
+	saml := api.Group("/saml/:org_id")
+	{
+		saml.GET("/metadata", s.samlHandler.Metadata)
+		saml.GET("/login", s.samlHandler.Login)
+		saml.POST("/acs", s.samlHandler.AssertionConsumer)
+		saml.GET("/slo", s.samlHandler.SingleLogout)
+		saml.POST("/slo", s.samlHandler.SingleLogout)
+	}
+}
+
+func (s *Server) setupFederationRoutes(api *gin.RouterGroup) {
+	federation := api.Group("/federation")
+	{
+		federation.GET("/providers", s.federationHandler.GetLoginProviders)
+		federation.GET("/:provider_id/login", s.federationHandler.Login)
+		federation.GET("/:provider_id/callback", s.federationHandler.Callback)
+	}
+
+	providers := api.Group("/identity-providers")
+	providers.Use(middleware.AuthRequired(s.cfg))
+	providers.Use(middleware.MFACompliant(s.cfg))
+	providers.Use(middleware.PasswordNotExpired(s.cfg))
+	{
+		providers.GET("", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.federationHandler.GetProviders)
+		providers.GET("/:id", middleware.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin), s.federationHandler.GetProvider)
+		providers.POST("", middleware.RequireOrgRole(models.OrgRoleOwner), s.federationHandler.CreateProvider)
+		providers.PATCH("/:id", middleware.RequireOrgRole(models.OrgRoleOwner), s.federationHandler.UpdateProvider)
+		providers.DELETE("/:id", middleware.RequireOrgRole(models.OrgRoleOwner), s.federationHandler.DeleteProvider)
+	}
+}
+
+func (s *Server) setupOAuthClientRoutes(api *gin.RouterGroup) {
+	clients := api.Group("/oauth-clients")
+	clients.Use(middleware.AuthRequired(s.cfg))
+	clients.Use(middleware.MFACompliant(s.cfg))
+	clients.Use(middleware.PasswordNotExpired(s.cfg))
+	{
+		clients.GET("", middleware.RequirePermission("view_oauthclient"), s.oauthClientHandler.GetClients)
+		clients.GET("/:id", middleware.RequirePermission("view_oauthclient"), s.oauthClientHandler.GetClient)
+		clients.POST("", middleware.RequirePermission("add_oauthclient"), s.oauthClientHandler.CreateClient)
+		clients.PATCH("/:id", middleware.RequirePermission("change_oauthclient"), s.oauthClientHandler.UpdateClient)
+		clients.POST("/:id/secret", middleware.RequirePermission("change_oauthclient"), s.oauthClientHandler.RotateSecret)
+		clients.DELETE("/:id", middleware.RequirePermission("delete_oauthclient"), s.oauthClientHandler.DeleteClient)
+	}
+}
+
+func (s *Server) setupOAuthRoutes(r *gin.Engine) {
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", s.oidcHandler.Authorize)
+		oauth.POST("/authorize", s.oidcHandler.AuthorizeLogin)
+		oauth.POST("/token", s.oidcHandler.Token)
+		oauth.POST("/introspect", s.oidcHandler.Introspect)
+		oauth.POST("/revoke", s.oidcHandler.Revoke)
+
+		authenticated := oauth.Group("/")
+		authenticated.Use(middleware.AuthRequired(s.cfg))
+		{
+			authenticated.GET("/userinfo", s.oidcHandler.UserInfo)
+			authenticated.POST("/userinfo", s.oidcHandler.UserInfo)
+		}
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"kepler-auth-go/internal/config"
 	"kepler-auth-go/internal/handlers"
 	"kepler-auth-go/internal/middleware"
+	"log"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -18,6 +19,15 @@ type Server struct {
 	groupHandler        *handlers.GroupHandler
 	permissionHandler   *handlers.PermissionHandler
 	organizationHandler *handlers.OrganizationHandler
+	jwksHandler         *handlers.JWKSHandler
+	oidcHandler         *handlers.OIDCHandler
+	oauthClientHandler  *handlers.OAuthClientHandler
+	mfaHandler          *handlers.MFAHandler
+	webAuthnHandler     *handlers.WebAuthnHandler
+	invitationHandler   *handlers.InvitationHandler
+	settingsHandler     *handlers.OrganizationSettingsHandler
+	samlHandler         *handlers.SAMLHandler
+	federationHandler   *handlers.FederationHandler
 }
 
 func NewServer(cfg *config.Config) *Server {
@@ -29,6 +39,15 @@ func NewServer(cfg *config.Config) *Server {
 		groupHandler:        handlers.NewGroupHandler(),
 		permissionHandler:   handlers.NewPermissionHandler(),
 		organizationHandler: handlers.NewOrganizationHandler(),
+		jwksHandler:         handlers.NewJWKSHandler(),
+		oidcHandler:         handlers.NewOIDCHandler(cfg),
+		oauthClientHandler:  handlers.NewOAuthClientHandler(),
+		mfaHandler:          handlers.NewMFAHandler(cfg),
+		webAuthnHandler:     handlers.NewWebAuthnHandler(cfg),
+		invitationHandler:   handlers.NewInvitationHandler(cfg),
+		settingsHandler:     handlers.NewOrganizationSettingsHandler(cfg),
+		samlHandler:         handlers.NewSAMLHandler(cfg),
+		federationHandler:   handlers.NewFederationHandler(cfg),
 	}
 }
 
@@ -36,6 +55,12 @@ func (s *Server) SetupRouter() *gin.Engine {
 	gin.SetMode(s.cfg.Server.Mode)
 	r := gin.Default()
 
+	if len(s.cfg.Server.TrustedProxies) > 0 {
+		if err := r.SetTrustedProxies(s.cfg.Server.TrustedProxies); err != nil {
+			log.Printf("Warning: invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
 	r.Use(middleware.CORS())
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -44,6 +69,9 @@ func (s *Server) SetupRouter() *gin.Engine {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	r.GET("/.well-known/jwks.json", s.jwksHandler.GetJWKS)
+	r.GET("/.well-known/openid-configuration", s.oidcHandler.Discovery)
+
 	s.setupRoutes(r)
 
 	return r
@@ -1,21 +1,33 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Email    EmailConfig
+	Server         ServerConfig
+	Database       DatabaseConfig
+	JWT            JWTConfig
+	Email          EmailConfig
+	OIDC           OIDCConfig
+	Security       SecurityConfig
+	MFA            MFAConfig
+	WebAuthn       WebAuthnConfig
+	Frontend       FrontendConfig
+	LoginThrottle  LoginThrottleConfig
+	PasswordPolicy PasswordPolicyConfig
+	PasswordHash   PasswordHashConfig
 }
 
 type ServerConfig struct {
 	Port string
 	Host string
 	Mode string
+	// TrustedProxies limits which peers may set X-Forwarded-For, used for per-IP login throttling
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -28,8 +40,17 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	Expiration int
+	Secret            string
+	Algorithm         string
+	Expiration        int
+	MaxExpiration     int // longest access token lifetime an organization may set
+	RefreshExpiration int
+	KeyRotation       int
+}
+
+// MaxAccessTokenLifetime is the longest an access token may live under any organization's settings
+func (c JWTConfig) MaxAccessTokenLifetime() int {
+	return max(c.Expiration, c.MaxExpiration)
 }
 
 type EmailConfig struct {
@@ -40,12 +61,93 @@ type EmailConfig struct {
 	FromEmail    string
 }
 
+type OIDCConfig struct {
+	Issuer string
+}
+
+type SecurityConfig struct {
+	EncryptionKey           string
+	PasswordResetExpiration int
+	// RequireEmailVerification blocks login until the email is verified, organizations may override it
+	RequireEmailVerification        bool
+	EmailVerificationExpiration     int
+	EmailVerificationResendInterval int
+	MagicLinkExpiration             int
+	InvitationExpiration            int
+	// The settings below are defaults that organizations may override through their settings
+	LoginMethods          []string // password, magic_link, webauthn, saml, oidc
+	SessionIdleTimeout    int      // seconds a session may go without refreshing, 0 disables
+	IPAllowlist           []string // addresses or CIDR ranges allowed to use tokens, empty allows all
+	AllowSelfRegistration bool     // users may register into an organization without an invitation
+}
+
+// defaultEncryptionKey is the published placeholder for ENCRYPTION_KEY
+const defaultEncryptionKey = "your-encryption-key"
+
+type MFAConfig struct {
+	Issuer string
+	// Required makes every user enroll in MFA, organizations may override it
+	Required bool
+}
+
+type LoginThrottleConfig struct {
+	Store              string // memory or postgres
+	MaxAccountFailures int
+	MaxIPFailures      int
+	LockoutDuration    int
+	Window             int
+}
+
+// PasswordPolicyConfig is the global password policy, organizations may override any field
+type PasswordPolicyConfig struct {
+	MinLength       int
+	MaxLength       int
+	RequireUpper    bool
+	RequireLower    bool
+	RequireDigit    bool
+	RequireSymbol   bool
+	CheckDictionary bool
+	CheckContext    bool
+	HistorySize     int
+	MaxAgeDays      int
+	// BreachedDataset is a file of SHA-1 hashes of breached passwords sorted by hash, the check is off when empty
+	BreachedDataset string
+}
+
+type PasswordHashConfig struct {
+	Algorithm         string // argon2id or bcrypt, used for new hashes
+	BcryptCost        int
+	Argon2Memory      int // KiB
+	Argon2Iterations  int
+	Argon2Parallelism int
+	// Pepper is a server side secret mixed into every new hash, never stored in the database
+	Pepper string
+	// PreviousPeppers still verify the hashes made with them, which are rehashed on login
+	PreviousPeppers []string
+}
+
+type FrontendConfig struct {
+	URL string
+	// Origins that caller supplied redirect URLs in emailed links may point to
+	AllowedRedirectOrigins []string
+}
+
+type WebAuthnConfig struct {
+	RPID          string
+	RPDisplayName string
+	RPOrigins     []string
+}
+
 func Load() *Config {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+
 	return &Config{
 		Server: ServerConfig{
 			Port: getEnv("PORT", "8000"),
 			Host: getEnv("HOST", "0.0.0.0"),
 			Mode: getEnv("GIN_MODE", "debug"),
+
+			TrustedProxies: getEnvAsList("TRUSTED_PROXIES", nil),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -56,8 +158,12 @@ func Load() *Config {
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
 		},
 		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET", "your-secret-key"),
-			Expiration: getEnvAsInt("JWT_EXPIRATION", 24*60*60),
+			Secret:            getEnv("JWT_SECRET", "your-secret-key"),
+			Algorithm:         getEnv("JWT_ALGORITHM", "RS256"),
+			Expiration:        getEnvAsInt("JWT_EXPIRATION", 15*60),
+			MaxExpiration:     getEnvAsInt("JWT_MAX_EXPIRATION", 24*60*60),
+			RefreshExpiration: getEnvAsInt("JWT_REFRESH_EXPIRATION", 30*24*60*60),
+			KeyRotation:       getEnvAsInt("JWT_KEY_ROTATION", 30*24*60*60),
 		},
 		Email: EmailConfig{
 			SMTPHost:     getEnv("SMTP_HOST", "smtp.gmail.com"),
@@ -66,9 +172,76 @@ func Load() *Config {
 			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
 			FromEmail:    getEnv("FROM_EMAIL", "noreply@skylarklabs.ai"),
 		},
+		OIDC: OIDCConfig{
+			Issuer: getEnv("OIDC_ISSUER", "http://localhost:8000"),
+		},
+		Security: SecurityConfig{
+			EncryptionKey:                   getEnv("ENCRYPTION_KEY", defaultEncryptionKey),
+			PasswordResetExpiration:         getEnvAsInt("PASSWORD_RESET_EXPIRATION", 60*60),
+			RequireEmailVerification:        getEnvAsBool("REQUIRE_EMAIL_VERIFICATION", false),
+			EmailVerificationExpiration:     getEnvAsInt("EMAIL_VERIFICATION_EXPIRATION", 24*60*60),
+			EmailVerificationResendInterval: getEnvAsInt("EMAIL_VERIFICATION_RESEND_INTERVAL", 60),
+			MagicLinkExpiration:             getEnvAsInt("MAGIC_LINK_EXPIRATION", 10*60),
+			InvitationExpiration:            getEnvAsInt("INVITATION_EXPIRATION", 7*24*60*60),
+			LoginMethods:                    getEnvAsList("LOGIN_METHODS", []string{"password", "magic_link", "webauthn", "saml", "oidc"}),
+			SessionIdleTimeout:              getEnvAsInt("SESSION_IDLE_TIMEOUT", 0),
+			IPAllowlist:                     getEnvAsList("IP_ALLOWLIST", nil),
+			AllowSelfRegistration:           getEnvAsBool("ALLOW_SELF_REGISTRATION", false),
+		},
+		MFA: MFAConfig{
+			Issuer:   getEnv("MFA_ISSUER", "Kepler"),
+			Required: getEnvAsBool("MFA_REQUIRED", false),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:          getEnv("WEBAUTHN_RP_ID", "localhost"),
+			RPDisplayName: getEnv("WEBAUTHN_RP_NAME", "Kepler"),
+			RPOrigins:     getEnvAsList("WEBAUTHN_RP_ORIGINS", []string{"http://localhost:8000"}),
+		},
+		Frontend: FrontendConfig{
+			URL:                    frontendURL,
+			AllowedRedirectOrigins: getEnvAsList("ALLOWED_REDIRECT_ORIGINS", []string{frontendURL}),
+		},
+		LoginThrottle: LoginThrottleConfig{
+			Store:              getEnv("LOGIN_THROTTLE_STORE", "memory"),
+			MaxAccountFailures: getEnvAsInt("LOGIN_MAX_ACCOUNT_FAILURES", 10),
+			MaxIPFailures:      getEnvAsInt("LOGIN_MAX_IP_FAILURES", 100),
+			LockoutDuration:    getEnvAsInt("LOGIN_LOCKOUT_DURATION", 15*60),
+			Window:             getEnvAsInt("LOGIN_FAILURE_WINDOW", 15*60),
+		},
+		PasswordPolicy: PasswordPolicyConfig{
+			MinLength:       getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+			MaxLength:       getEnvAsInt("PASSWORD_MAX_LENGTH", 128),
+			RequireUpper:    getEnvAsBool("PASSWORD_REQUIRE_UPPER", false),
+			RequireLower:    getEnvAsBool("PASSWORD_REQUIRE_LOWER", false),
+			RequireDigit:    getEnvAsBool("PASSWORD_REQUIRE_DIGIT", false),
+			RequireSymbol:   getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+			CheckDictionary: getEnvAsBool("PASSWORD_CHECK_DICTIONARY", true),
+			CheckContext:    getEnvAsBool("PASSWORD_CHECK_CONTEXT", true),
+			HistorySize:     getEnvAsInt("PASSWORD_HISTORY_SIZE", 5),
+			MaxAgeDays:      getEnvAsInt("PASSWORD_MAX_AGE_DAYS", 0),
+			BreachedDataset: getEnv("PASSWORD_BREACHED_DATASET", ""),
+		},
+		PasswordHash: PasswordHashConfig{
+			Algorithm:         getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			BcryptCost:        getEnvAsInt("PASSWORD_BCRYPT_COST", 12),
+			Argon2Memory:      getEnvAsInt("PASSWORD_ARGON2_MEMORY", 64*1024),
+			Argon2Iterations:  getEnvAsInt("PASSWORD_ARGON2_ITERATIONS", 3),
+			Argon2Parallelism: getEnvAsInt("PASSWORD_ARGON2_PARALLELISM", 2),
+			Pepper:            getEnv("PASSWORD_PEPPER", ""),
+			PreviousPeppers:   getEnvAsList("PASSWORD_PREVIOUS_PEPPERS", nil),
+		},
 	}
 }
 
+// Validate refuses settings that are only safe for development. The encryption key seals secrets
+// at rest and signs emailed links, so a release build must not run with the placeholder.
+func (c *Config) Validate() error {
+	if c.Server.Mode == "release" && (c.Security.EncryptionKey == "" || c.Security.EncryptionKey == defaultEncryptionKey) {
+		return errors.New("ENCRYPTION_KEY must be set to a private value in release mode")
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -84,3 +257,27 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -64,7 +64,29 @@ func Migrate() error {
 		&models.User{},
 		&models.Group{},
 		&models.Permission{},
-		&models.AuthGroup{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.SigningKey{},
+		&models.OAuthClient{},
+		&models.AuthorizationCode{},
+		&models.MFARecoveryCode{},
+		&models.MFAChallenge{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.PasswordResetToken{},
+		&models.EmailLoginToken{},
+		&models.LoginAttempt{},
+		&models.PasswordHistory{},
+		&models.AuditLog{},
+		&models.OrganizationMember{},
+		&models.OrganizationInvitation{},
+		&models.OrganizationSettings{},
+		&models.SAMLConnection{},
+		&models.SAMLSession{},
+		&models.SAMLIdentity{},
+		&models.IdentityProvider{},
+		&models.UserIdentity{},
+		&models.FederationLogin{},
 	)
 }
 
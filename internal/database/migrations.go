@@ -129,6 +129,521 @@ func GetMigrations() []Migration {
 				return nil
 			},
 		},
+		{
+			ID: "003_create_refresh_tokens",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.RefreshToken{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.RefreshToken{})
+			},
+		},
+		{
+			ID: "004_add_token_revocation",
+			Up: func(db *gorm.DB) error {
+				// 001 migrates the current User struct, so fresh databases already have the column
+				if !db.Migrator().HasColumn(&models.User{}, "TokensValidAfter") {
+					if err := db.Migrator().AddColumn(&models.User{}, "TokensValidAfter"); err != nil {
+						return err
+					}
+				}
+				return db.AutoMigrate(&models.RevokedToken{})
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.RevokedToken{}); err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.User{}, "TokensValidAfter")
+			},
+		},
+		{
+			ID: "005_create_signing_keys",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.SigningKey{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.SigningKey{})
+			},
+		},
+		{
+			ID: "006_create_oauth_tables",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.OAuthClient{}, &models.AuthorizationCode{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.AuthorizationCode{}, &models.OAuthClient{})
+			},
+		},
+		{
+			ID: "007_add_oauth_client_credentials",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.OAuthClient{})
+			},
+			Down: func(db *gorm.DB) error {
+				for _, column := range []string{"ClientSecretHash", "IsConfidential", "GrantTypes", "AllowedScopes", "OrganizationID"} {
+					if err := db.Migrator().DropColumn(&models.OAuthClient{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "008_add_mfa",
+			Up: func(db *gorm.DB) error {
+				for _, column := range []string{"MFAEnabled", "TOTPSecret", "TOTPLastStep"} {
+					if !db.Migrator().HasColumn(&models.User{}, column) {
+						if err := db.Migrator().AddColumn(&models.User{}, column); err != nil {
+							return err
+						}
+					}
+				}
+				if err := db.Exec("ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_mfa boolean DEFAULT false").Error; err != nil {
+					return err
+				}
+				return db.AutoMigrate(&models.MFARecoveryCode{}, &models.MFAChallenge{})
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.MFAChallenge{}, &models.MFARecoveryCode{}); err != nil {
+					return err
+				}
+				if err := db.Exec("ALTER TABLE organizations DROP COLUMN IF EXISTS require_mfa").Error; err != nil {
+					return err
+				}
+				for _, column := range []string{"MFAEnabled", "TOTPSecret", "TOTPLastStep"} {
+					if err := db.Migrator().DropColumn(&models.User{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "009_add_webauthn",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.WebAuthnCredential{}, &models.WebAuthnSession{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.WebAuthnSession{}, &models.WebAuthnCredential{})
+			},
+		},
+		{
+			ID: "010_add_password_reset_tokens",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.PasswordResetToken{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.PasswordResetToken{})
+			},
+		},
+		{
+			ID: "011_add_email_verification",
+			Up: func(db *gorm.DB) error {
+				if !db.Migrator().HasColumn(&models.User{}, "VerificationSentAt") {
+					if err := db.Migrator().AddColumn(&models.User{}, "VerificationSentAt"); err != nil {
+						return err
+					}
+				}
+				return db.Exec("ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_email_verification boolean DEFAULT false").Error
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Exec("ALTER TABLE organizations DROP COLUMN IF EXISTS require_email_verification").Error; err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.User{}, "VerificationSentAt")
+			},
+		},
+		{
+			ID: "012_add_email_login_tokens",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.EmailLoginToken{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.EmailLoginToken{})
+			},
+		},
+		{
+			ID: "013_add_login_attempts",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.LoginAttempt{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.LoginAttempt{})
+			},
+		},
+		{
+			ID: "014_add_password_policy",
+			Up: func(db *gorm.DB) error {
+				if !db.Migrator().HasColumn(&models.User{}, "PasswordChangedAt") {
+					if err := db.Migrator().AddColumn(&models.User{}, "PasswordChangedAt"); err != nil {
+						return err
+					}
+					// Start the maximum age clock now rather than expiring every existing password at once
+					if err := db.Exec("UPDATE users SET password_changed_at = NOW() WHERE password_changed_at IS NULL").Error; err != nil {
+						return err
+					}
+				}
+				if err := db.Exec("ALTER TABLE organizations ADD COLUMN IF NOT EXISTS password_policy text").Error; err != nil {
+					return err
+				}
+				return db.AutoMigrate(&models.PasswordHistory{})
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.PasswordHistory{}); err != nil {
+					return err
+				}
+				if err := db.Exec("ALTER TABLE organizations DROP COLUMN IF EXISTS password_policy").Error; err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.User{}, "PasswordChangedAt")
+			},
+		},
+		{
+			ID: "015_unify_group_permissions",
+			Up: func(db *gorm.DB) error {
+				// Creates the group_permissions join table
+				if err := db.AutoMigrate(&models.Group{}); err != nil {
+					return err
+				}
+
+				if db.Migrator().HasColumn(&models.Group{}, "permissions") {
+					// Unknown IDs in the old arrays had no permission behind them and are dropped
+					if err := db.Exec(`INSERT INTO group_permissions (group_id, permission_id)
+						SELECT g.id, p.id FROM groups g
+						CROSS JOIN LATERAL unnest(g.permissions) AS old(permission_id)
+						JOIN permissions p ON p.id = old.permission_id
+						ON CONFLICT DO NOTHING`).Error; err != nil {
+						return err
+					}
+					if err := db.Migrator().DropColumn(&models.Group{}, "permissions"); err != nil {
+						return err
+					}
+				}
+
+				if db.Migrator().HasTable("auth_groups") {
+					// Auth groups become global groups of their own. Their permissions never granted
+					// anything, so an auth group named like an existing group is imported under a new
+					// name rather than merged into it.
+					var authGroups []struct {
+						ID   uint
+						Name string
+					}
+					if err := db.Raw("SELECT id, name FROM auth_groups ORDER BY id").Scan(&authGroups).Error; err != nil {
+						return err
+					}
+
+					for _, authGroup := range authGroups {
+						name := authGroup.Name
+						for _, candidate := range []string{name, name + " (auth group)", fmt.Sprintf("%s (auth group %d)", name, authGroup.ID)} {
+							name = candidate
+							var taken int64
+							if err := db.Raw("SELECT COUNT(*) FROM groups WHERE name = ? AND organization_id IS NULL", name).Scan(&taken).Error; err != nil {
+								return err
+							}
+							if taken == 0 {
+								break
+							}
+						}
+
+						var groupID uint
+						if err := db.Raw(`INSERT INTO groups (name, is_active, is_default, created_at, updated_at)
+							SELECT ?, true, false, created_at, updated_at FROM auth_groups WHERE id = ?
+							RETURNING id`, name, authGroup.ID).Scan(&groupID).Error; err != nil {
+							return err
+						}
+						if err := db.Exec(`INSERT INTO group_permissions (group_id, permission_id)
+							SELECT ?, permission_id FROM auth_group_permissions WHERE auth_group_id = ?
+							ON CONFLICT DO NOTHING`, groupID, authGroup.ID).Error; err != nil {
+							return err
+						}
+					}
+					if err := db.Migrator().DropTable("auth_group_permissions", "auth_groups"); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Down: func(db *gorm.DB) error {
+				// Auth groups are not split back out, they stay ordinary groups
+				if err := db.Exec("ALTER TABLE groups ADD COLUMN IF NOT EXISTS permissions integer[]").Error; err != nil {
+					return err
+				}
+				if err := db.Exec(`UPDATE groups g SET permissions = COALESCE(
+					(SELECT array_agg(gp.permission_id ORDER BY gp.permission_id) FROM group_permissions gp WHERE gp.group_id = g.id),
+					'{}')`).Error; err != nil {
+					return err
+				}
+				if err := db.Migrator().DropTable("group_permissions"); err != nil {
+					return err
+				}
+				return db.AutoMigrate(&models.AuthGroup{})
+			},
+		},
+		{
+			ID: "016_add_audit_logs",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.AuditLog{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.AuditLog{})
+			},
+		},
+		{
+			ID: "017_add_organization_roles",
+			Up: func(db *gorm.DB) error {
+				if !db.Migrator().HasColumn(&models.User{}, "IsSuperuser") {
+					if err := db.Migrator().AddColumn(&models.User{}, "IsSuperuser"); err != nil {
+						return err
+					}
+					// Only admins outside any organization keep platform wide access
+					if err := db.Exec("UPDATE users SET is_superuser = true WHERE is_admin = true AND organization_id IS NULL").Error; err != nil {
+						return err
+					}
+				}
+				if err := db.AutoMigrate(&models.OrganizationMember{}); err != nil {
+					return err
+				}
+				// Admins inside an organization become admins of that organization only
+				return db.Exec(`INSERT INTO organization_members (organization_id, user_id, role, created_at, updated_at)
+					SELECT organization_id, id, CASE WHEN is_admin THEN 'admin' ELSE 'member' END, NOW(), NOW()
+					FROM users WHERE organization_id IS NOT NULL
+					ON CONFLICT DO NOTHING`).Error
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.OrganizationMember{}); err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.User{}, "IsSuperuser")
+			},
+		},
+		{
+			ID: "018_merge_users_by_email",
+			Up: func(db *gorm.DB) error {
+				if !db.Migrator().HasColumn(&models.RefreshToken{}, "OrganizationID") {
+					if err := db.Migrator().AddColumn(&models.RefreshToken{}, "OrganizationID"); err != nil {
+						return err
+					}
+					if err := db.Exec(`UPDATE refresh_tokens rt SET organization_id = u.organization_id
+						FROM users u WHERE u.id = rt.user_id`).Error; err != nil {
+						return err
+					}
+				}
+
+				// Passkeys keep the user handle they were registered under, which still names the
+				// original row after it is merged away
+				if !db.Migrator().HasColumn(&models.WebAuthnCredential{}, "UserHandle") {
+					if err := db.Migrator().AddColumn(&models.WebAuthnCredential{}, "UserHandle"); err != nil {
+						return err
+					}
+				}
+
+				// Per organization rows with the same email collapse into one account. The survivor is the
+				// live, active, verified, most recently updated row; it keeps its password, MFA and default
+				// organization. Only a verified row is known to belong to the owner of the address, so the
+				// passkeys of unverified rows are dropped rather than moved, and a survivor that is not
+				// verified itself loses its password, MFA and passkeys: whoever controls the mailbox gets in
+				// through a password reset or magic link.
+				statements := []string{
+					`UPDATE web_authn_credentials SET user_handle = int8send(user_id::bigint) WHERE user_handle IS NULL`,
+					`CREATE TEMP TABLE user_merges ON COMMIT DROP AS
+						SELECT id AS old_id, is_verified AS old_verified, FIRST_VALUE(id) OVER (
+							PARTITION BY LOWER(email) ORDER BY is_deleted, is_active DESC, is_verified DESC, updated_at DESC, id
+						) AS new_id FROM users`,
+					`DELETE FROM user_merges WHERE old_id = new_id`,
+					`INSERT INTO organization_members (organization_id, user_id, role, created_at, updated_at)
+						SELECT om.organization_id, m.new_id, om.role, om.created_at, NOW()
+						FROM organization_members om JOIN user_merges m ON m.old_id = om.user_id
+						ON CONFLICT DO NOTHING`,
+					`INSERT INTO user_groups (user_id, group_id)
+						SELECT m.new_id, ug.group_id FROM user_groups ug JOIN user_merges m ON m.old_id = ug.user_id
+						ON CONFLICT DO NOTHING`,
+					`DELETE FROM user_groups WHERE user_id IN (SELECT old_id FROM user_merges)`,
+					`UPDATE web_authn_credentials wc SET user_id = m.new_id FROM user_merges m
+						WHERE wc.user_id = m.old_id AND m.old_verified`,
+					`UPDATE audit_logs al SET actor_id = m.new_id FROM user_merges m WHERE al.actor_id = m.old_id`,
+					`DELETE FROM password_histories WHERE user_id IN (SELECT old_id FROM user_merges)`,
+					`DELETE FROM web_authn_sessions WHERE user_id IN (SELECT old_id FROM user_merges)`,
+					`DELETE FROM web_authn_credentials WHERE user_id IN (SELECT new_id FROM user_merges m
+						JOIN users u ON u.id = m.new_id WHERE NOT u.is_verified)`,
+					`DELETE FROM mfa_recovery_codes WHERE user_id IN (SELECT new_id FROM user_merges m
+						JOIN users u ON u.id = m.new_id WHERE NOT u.is_verified)`,
+					`DELETE FROM refresh_tokens WHERE user_id IN (SELECT new_id FROM user_merges m
+						JOIN users u ON u.id = m.new_id WHERE NOT u.is_verified)`,
+					`UPDATE users SET password = '!', mfa_enabled = false, totp_secret = '', tokens_valid_after = NOW()
+						WHERE NOT is_verified AND id IN (SELECT new_id FROM user_merges)`,
+					// Remaining sessions, codes and tokens of merged rows go with them through ON DELETE CASCADE
+					`DELETE FROM users WHERE id IN (SELECT old_id FROM user_merges)`,
+					`DROP INDEX IF EXISTS idx_email_org`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email)`,
+				}
+				for _, statement := range statements {
+					if err := db.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Down: func(db *gorm.DB) error {
+				// Merged accounts are not split back out
+				if err := db.Exec("DROP INDEX IF EXISTS idx_users_email").Error; err != nil {
+					return err
+				}
+				if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_email_org ON users (email, organization_id)").Error; err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.RefreshToken{}, "OrganizationID")
+			},
+		},
+		{
+			ID: "019_add_organization_invitations",
+			Up: func(db *gorm.DB) error {
+				// Organizations become invite-only; open registration has to be re-enabled per organization
+				if err := db.Exec("ALTER TABLE organizations ADD COLUMN IF NOT EXISTS allow_open_registration boolean DEFAULT false").Error; err != nil {
+					return err
+				}
+				return db.AutoMigrate(&models.OrganizationInvitation{})
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.OrganizationInvitation{}); err != nil {
+					return err
+				}
+				return db.Exec("ALTER TABLE organizations DROP COLUMN IF EXISTS allow_open_registration").Error
+			},
+		},
+		{
+			ID: "020_add_domain_verification",
+			Up: func(db *gorm.DB) error {
+				if !db.Migrator().HasColumn(&models.Organization{}, "DomainVerificationToken") {
+					if err := db.Migrator().AddColumn(&models.Organization{}, "DomainVerificationToken"); err != nil {
+						return err
+					}
+				}
+				if !db.Migrator().HasColumn(&models.Organization{}, "DomainVerifiedAt") {
+					if err := db.Migrator().AddColumn(&models.Organization{}, "DomainVerifiedAt"); err != nil {
+						return err
+					}
+				}
+				// Only one organization can own a verified domain
+				return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_verified_domain
+					ON organizations (LOWER(domain)) WHERE domain_verified_at IS NOT NULL`).Error
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Exec("DROP INDEX IF EXISTS idx_organizations_verified_domain").Error; err != nil {
+					return err
+				}
+				if err := db.Migrator().DropColumn(&models.Organization{}, "DomainVerifiedAt"); err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.Organization{}, "DomainVerificationToken")
+			},
+		},
+		{
+			ID: "021_add_organization_settings",
+			// The organization columns moved here no longer exist on the model, so 008, 011, 014 and
+			// 019 add and drop them with raw SQL
+			Up: func(db *gorm.DB) error {
+				if err := db.AutoMigrate(&models.OrganizationSettings{}); err != nil {
+					return err
+				}
+				// Settings that were off keep following the global default, which was off as well.
+				// Email verification is copied as is since organizations used to ignore the global value.
+				statements := []string{
+					`INSERT INTO organization_settings (organization_id, require_mfa, require_email_verification,
+						password_policy, allow_self_registration, created_at, updated_at)
+						SELECT id, NULLIF(require_mfa, false), require_email_verification, password_policy,
+							NULLIF(allow_open_registration, false), NOW(), NOW()
+						FROM organizations
+						ON CONFLICT (organization_id) DO NOTHING`,
+					`ALTER TABLE organizations DROP COLUMN IF EXISTS require_mfa`,
+					`ALTER TABLE organizations DROP COLUMN IF EXISTS require_email_verification`,
+					`ALTER TABLE organizations DROP COLUMN IF EXISTS password_policy`,
+					`ALTER TABLE organizations DROP COLUMN IF EXISTS allow_open_registration`,
+				}
+				for _, statement := range statements {
+					if err := db.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Down: func(db *gorm.DB) error {
+				statements := []string{
+					`ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_mfa boolean DEFAULT false`,
+					`ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_email_verification boolean DEFAULT false`,
+					`ALTER TABLE organizations ADD COLUMN IF NOT EXISTS password_policy text`,
+					`ALTER TABLE organizations ADD COLUMN IF NOT EXISTS allow_open_registration boolean DEFAULT false`,
+					`UPDATE organizations o SET require_mfa = COALESCE(s.require_mfa, false),
+						require_email_verification = COALESCE(s.require_email_verification, false),
+						password_policy = s.password_policy,
+						allow_open_registration = COALESCE(s.allow_self_registration, false)
+						FROM organization_settings s WHERE s.organization_id = o.id`,
+				}
+				for _, statement := range statements {
+					if err := db.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return db.Migrator().DropTable(&models.OrganizationSettings{})
+			},
+		},
+		{
+			ID: "022_add_saml_connections",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.SAMLConnection{}, &models.SAMLSession{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.SAMLSession{}, &models.SAMLConnection{})
+			},
+		},
+		{
+			ID: "023_add_identity_providers",
+			Up: func(db *gorm.DB) error {
+				return db.AutoMigrate(&models.IdentityProvider{}, &models.UserIdentity{}, &models.FederationLogin{})
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&models.FederationLogin{}, &models.UserIdentity{}, &models.IdentityProvider{})
+			},
+		},
+		{
+			ID: "024_add_session_login_methods",
+			Up: func(db *gorm.DB) error {
+				// Existing sessions keep an empty method and must sign in again to switch organization
+				if !db.Migrator().HasColumn(&models.RefreshToken{}, "LoginMethod") {
+					if err := db.Migrator().AddColumn(&models.RefreshToken{}, "LoginMethod"); err != nil {
+						return err
+					}
+				}
+				if !db.Migrator().HasColumn(&models.MFAChallenge{}, "LoginMethod") {
+					return db.Migrator().AddColumn(&models.MFAChallenge{}, "LoginMethod")
+				}
+				return nil
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropColumn(&models.MFAChallenge{}, "LoginMethod"); err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.RefreshToken{}, "LoginMethod")
+			},
+		},
+		{
+			ID: "025_add_saml_identities",
+			Up: func(db *gorm.DB) error {
+				// Accounts outside the verified domain that signed in by email match have to link the IdP
+				if !db.Migrator().HasColumn(&models.SAMLSession{}, "Purpose") {
+					if err := db.Migrator().AddColumn(&models.SAMLSession{}, "Purpose"); err != nil {
+						return err
+					}
+				}
+				return db.AutoMigrate(&models.SAMLIdentity{})
+			},
+			Down: func(db *gorm.DB) error {
+				if err := db.Migrator().DropTable(&models.SAMLIdentity{}); err != nil {
+					return err
+				}
+				return db.Migrator().DropColumn(&models.SAMLSession{}, "Purpose")
+			},
+		},
 	}
 }
 
@@ -3,6 +3,7 @@ package database
 import (
 	"kepler-auth-go/internal/models"
 	"log"
+	"strings"
 )
 
 func SeedDefaultData() error {
@@ -20,6 +21,15 @@ func SeedDefaultData() error {
 		{Name: "Can change permission", Codename: "change_permission", ContentType: "auth.permission"},
 		{Name: "Can delete permission", Codename: "delete_permission", ContentType: "auth.permission"},
 		{Name: "Can view permission", Codename: "view_permission", ContentType: "auth.permission"},
+		{Name: "Can add organization", Codename: "add_organization", ContentType: "auth.organization"},
+		{Name: "Can change organization", Codename: "change_organization", ContentType: "auth.organization"},
+		{Name: "Can delete organization", Codename: "delete_organization", ContentType: "auth.organization"},
+		{Name: "Can view organization", Codename: "view_organization", ContentType: "auth.organization"},
+		{Name: "Can add oauth client", Codename: "add_oauthclient", ContentType: "oauth.client"},
+		{Name: "Can change oauth client", Codename: "change_oauthclient", ContentType: "oauth.client"},
+		{Name: "Can delete oauth client", Codename: "delete_oauthclient", ContentType: "oauth.client"},
+		{Name: "Can view oauth client", Codename: "view_oauthclient", ContentType: "oauth.client"},
+		{Name: "Can send email", Codename: "send_email", ContentType: "email.message"},
 	}
 
 	for _, permission := range permissions {
@@ -33,34 +43,26 @@ func SeedDefaultData() error {
 		}
 	}
 
-	// Seed default groups
-	defaultGroups := []models.AuthGroup{
-		{Name: "Administrators"},
-		{Name: "Staff"},
-		{Name: "Users"},
+	// Seed default groups, granting permissions only when a group is first created
+	var allPermissions, viewPermissions []models.Permission
+	if err := DB.Find(&allPermissions).Error; err != nil {
+		return err
 	}
-
-	for _, group := range defaultGroups {
-		var existing models.AuthGroup
-		if err := DB.Where("name = ?", group.Name).First(&existing).Error; err != nil {
-			if err := DB.Create(&group).Error; err != nil {
-				log.Printf("Failed to create auth group %s: %v", group.Name, err)
-			} else {
-				log.Printf("Created auth group: %s", group.Name)
-			}
+	for _, permission := range allPermissions {
+		if strings.HasPrefix(permission.Codename, "view_") {
+			viewPermissions = append(viewPermissions, permission)
 		}
 	}
 
-	// Seed default custom groups
 	customGroups := []models.Group{
-		{Name: "Admin", Description: stringPtr("Administrator group with full access"), IsActive: true, IsDefault: false},
-		{Name: "Staff", Description: stringPtr("Staff group with limited access"), IsActive: true, IsDefault: false},
+		{Name: "Admin", Description: stringPtr("Administrator group with full access"), IsActive: true, IsDefault: false, Permissions: allPermissions},
+		{Name: "Staff", Description: stringPtr("Staff group with limited access"), IsActive: true, IsDefault: false, Permissions: viewPermissions},
 		{Name: "User", Description: stringPtr("Default user group"), IsActive: true, IsDefault: true},
 	}
 
 	for _, group := range customGroups {
 		var existing models.Group
-		if err := DB.Where("name = ?", group.Name).First(&existing).Error; err != nil {
+		if err := DB.Where("name = ? AND organization_id IS NULL", group.Name).First(&existing).Error; err != nil {
 			if err := DB.Create(&group).Error; err != nil {
 				log.Printf("Failed to create group %s: %v", group.Name, err)
 			} else {
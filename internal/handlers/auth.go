@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/middleware"
 	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/ratelimit"
 	"kepler-auth-go/internal/services"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -55,9 +60,10 @@ func (h *AuthHandler) Register(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param request body models.LoginRequest true "Login credentials"
-// @Success 200 {object} models.LoginResponse
+// @Success 200 {object} models.LoginResponse "Tokens, or models.MFAChallengeResponse when a second factor is required"
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Router /api/auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
@@ -65,8 +71,47 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	req.ClientIP = c.ClientIP()
 
 	response, err := h.authService.Login(&req)
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			c.JSON(http.StatusOK, mfaErr.Challenge)
+			return
+		}
+		var blockedErr *ratelimit.BlockedError
+		if errors.As(err, &blockedErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blockedErr.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// LoginMFA godoc
+// @Summary Complete MFA login
+// @Description Exchange the mfa_token returned by login plus a TOTP or recovery code for tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "MFA token and code"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/login/mfa [post]
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req models.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.authService.CompleteMFALogin(&req)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -75,6 +120,174 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// Refresh godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token. The refresh token is rotated on every use.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.authService.Refresh(&req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout godoc
+// @Summary Log out current session
+// @Description Revoke the current access token and, if provided, the refresh token issued with it
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.LogoutRequest false "Refresh token to revoke"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.authService.Logout(claims.(*middleware.Claims), &req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll godoc
+// @Summary Log out all sessions
+// @Description Revoke every access and refresh token issued to the current user
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := h.authService.LogoutAll(claims.(*middleware.Claims)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions logged out successfully"})
+}
+
+// GetMyOrganizations godoc
+// @Summary List the current user's organizations
+// @Description List every organization the current user is a member of, with their role in each
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.UserOrganizationResponse
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/organizations [get]
+func (h *AuthHandler) GetMyOrganizations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	organizations, err := h.authService.GetUserOrganizations(userID.(uint), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, organizations)
+}
+
+// SwitchOrganization godoc
+// @Summary Switch organization
+// @Description Issue tokens scoped to another organization the current user is a member of, which also becomes their default. The target organization must allow the login method and IP address of the current session.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SwitchOrganizationRequest true "Target organization"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /api/auth/switch-organization [post]
+func (h *AuthHandler) SwitchOrganization(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.SwitchOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if claims, ok := c.Get("claims"); ok {
+		req.LoginMethod = claims.(*middleware.Claims).LoginMethod
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.authService.SwitchOrganization(userID.(uint), &req)
+	if err != nil {
+		if err.Error() == "not a member of this organization" || err.Error() == "email address is not verified" ||
+			err.Error() == "login method is not allowed by your organization" || err.Error() == "access from this ip address is not allowed" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "user not found" || err.Error() == "account is deactivated" || err.Error() == "sign in again to switch organization" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // ChangePassword godoc
 // @Summary Change user password
 // @Description Change the current user's password
@@ -108,6 +321,230 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
 
+// RequestPasswordReset godoc
+// @Summary Request a password reset email
+// @Description Email a single-use reset link to the account. The response is the same whether or not the email is registered.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.ResetPasswordEmailRequest true "Account email and optional redirect URL"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/password-reset [post]
+func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
+	var req models.ResetPasswordEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(&req); err != nil {
+		if err.Error() == "redirect_url is not allowed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password reset request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
+}
+
+// ConfirmPasswordReset godoc
+// @Summary Set a new password with a reset token
+// @Description Consume the uid and token from the reset link and set a new password. All sessions are revoked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.SetNewPasswordRequest true "Reset token, uid and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/password-reset/confirm [post]
+func (h *AuthHandler) ConfirmPasswordReset(c *gin.Context) {
+	var req models.SetNewPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.ConfirmPasswordReset(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset successfully"})
+}
+
+// VerifyEmail godoc
+// @Summary Verify email address
+// @Description Confirm the user's email with the token from the verification link. Accepts the token as a query parameter (GET) or JSON body (POST).
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Verification token (GET)"
+// @Param request body models.VerifyEmailRequest false "Verification token (POST)"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/verify-email [get]
+// @Router /api/auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	var err error
+	if c.Request.Method == http.MethodGet {
+		err = c.ShouldBindQuery(&req)
+	} else {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.VerifyEmail(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification link. Throttled per account; the response is the same whether or not an email was sent.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.ResendVerificationRequest true "Account email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authService.ResendVerification(&req); err != nil {
+		if err.Error() == "redirect_url is not allowed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process verification request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists and is not verified, a verification email has been sent"})
+}
+
+// DiscoverOrganization godoc
+// @Summary Find the organization for an email address
+// @Description Look up the organization that verified the email's domain, so a login form only needs the email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.DomainDiscoveryRequest true "Email address"
+// @Success 200 {object} models.DomainDiscoveryResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/discover [post]
+func (h *AuthHandler) DiscoverOrganization(c *gin.Context) {
+	var req models.DomainDiscoveryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.authService.DiscoverOrganization(&req)
+	if err != nil {
+		if err.Error() == "no organization found for this email domain" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RequestMagicLink godoc
+// @Summary Request a passwordless login email
+// @Description Email a single-use sign-in link (mode=link, default) or a 6-digit code (mode=code). The response is the same whether or not the account exists.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkRequest true "Account email and delivery mode"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /api/auth/magic-link [post]
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	if err := h.authService.RequestMagicLink(&req); err != nil {
+		if err.Error() == "redirect_url is not allowed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var blockedErr *ratelimit.BlockedError
+		if errors.As(err, &blockedErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blockedErr.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process sign-in request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a sign-in email has been sent"})
+}
+
+// VerifyMagicLink godoc
+// @Summary Complete a passwordless login
+// @Description Exchange a magic link token, or an email and 6-digit code, for tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkVerifyRequest true "Token, or email and code"
+// @Success 200 {object} models.LoginResponse "Tokens, or models.MFAChallengeResponse when a second factor is required"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /api/auth/magic-link/verify [post]
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	var req models.MagicLinkVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.authService.VerifyMagicLink(&req)
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			c.JSON(http.StatusOK, mfaErr.Challenge)
+			return
+		}
+		var blockedErr *ratelimit.BlockedError
+		if errors.As(err, &blockedErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blockedErr.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // GetMe godoc
 // @Summary Get current user profile
 // @Description Get the authenticated user's profile information
@@ -142,6 +579,7 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 // @Success 200 {object} models.UserResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Router /api/auth/me [patch]
 func (h *AuthHandler) UpdateMe(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -165,6 +603,14 @@ func (h *AuthHandler) UpdateMe(c *gin.Context) {
 		}
 	}
 
+	// Users cannot place themselves in an organization, membership is granted by its admins
+	if req.OrganizationID != nil {
+		if user, ok := c.Get("user"); !ok || !user.(*models.User).IsSuperuser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only superusers can change their organization"})
+			return
+		}
+	}
+
 	userService := services.NewUserService()
 	response, err := userService.UpdateUser(userID.(uint), &req, orgID)
 	if err != nil {
@@ -21,7 +21,7 @@ func NewEmailHandler(cfg *config.Config) *EmailHandler {
 
 // SendEmail godoc
 // @Summary Send email
-// @Description Send email to specified recipient
+// @Description Send email to specified recipient. Requires the send_email permission.
 // @Tags email
 // @Accept json
 // @Produce json
@@ -30,6 +30,7 @@ func NewEmailHandler(cfg *config.Config) *EmailHandler {
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Router /api/email/send [post]
 func (h *EmailHandler) SendEmail(c *gin.Context) {
 	var req models.EmailRequest
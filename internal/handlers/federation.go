@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"errors"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FederationHandler struct {
+	federationService *services.FederationService
+}
+
+func NewFederationHandler(cfg *config.Config) *FederationHandler {
+	return &FederationHandler{
+		federationService: services.NewFederationService(cfg),
+	}
+}
+
+// GetProviders godoc
+// @Summary List identity providers
+// @Description List the upstream OpenID Connect providers of the caller's organization, or every provider for superusers
+// @Tags identity-providers
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.IdentityProviderResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /api/identity-providers [get]
+func (h *FederationHandler) GetProviders(c *gin.Context) {
+	providers, err := h.federationService.GetProviders(organizationScope(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// GetProvider godoc
+// @Summary Get identity provider
+// @Description Get an upstream OpenID Connect provider and the redirect URI to register with it
+// @Tags identity-providers
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Identity provider ID"
+// @Success 200 {object} models.IdentityProviderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/identity-providers/{id} [get]
+func (h *FederationHandler) GetProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity provider ID"})
+		return
+	}
+
+	provider, err := h.federationService.GetProvider(uint(id), organizationScope(c))
+	if err != nil {
+		if err.Error() == "identity provider not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// CreateProvider godoc
+// @Summary Register an identity provider
+// @Description Register an upstream OpenID Connect provider such as Google Workspace, Azure AD or Keycloak. The issuer's discovery document must be reachable. Organization owners register providers for their organization, superusers may register global ones.
+// @Tags identity-providers
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.IdentityProviderRequest true "Identity provider"
+// @Success 201 {object} models.IdentityProviderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /api/identity-providers [post]
+func (h *FederationHandler) CreateProvider(c *gin.Context) {
+	var req models.IdentityProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can configure identity providers"})
+		return
+	}
+
+	provider, err := h.federationService.CreateProvider(&req, actor, organizationScope(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, provider)
+}
+
+// UpdateProvider godoc
+// @Summary Update identity provider
+// @Description Change an upstream OpenID Connect provider. Fields left out are unchanged; an empty client_secret makes the client public.
+// @Tags identity-providers
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Identity provider ID"
+// @Param request body models.IdentityProviderUpdateRequest true "Identity provider changes"
+// @Success 200 {object} models.IdentityProviderResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/identity-providers/{id} [patch]
+func (h *FederationHandler) UpdateProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity provider ID"})
+		return
+	}
+
+	var req models.IdentityProviderUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can configure identity providers"})
+		return
+	}
+
+	provider, err := h.federationService.UpdateProvider(uint(id), &req, actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "identity provider not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// DeleteProvider godoc
+// @Summary Delete identity provider
+// @Description Delete an upstream OpenID Connect provider and unlink every identity linked through it. Provisioned users keep their accounts.
+// @Tags identity-providers
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Identity provider ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/identity-providers/{id} [delete]
+func (h *FederationHandler) DeleteProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity provider ID"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can configure identity providers"})
+		return
+	}
+
+	if err := h.federationService.DeleteProvider(uint(id), actor, organizationScope(c)); err != nil {
+		if err.Error() == "identity provider not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity provider deleted successfully"})
+}
+
+// GetLoginProviders godoc
+// @Summary List sign-in providers
+// @Description List the active identity providers to offer on the login page: the global ones and those of the given organization
+// @Tags federation
+// @Produce json
+// @Param organization_id query int false "Organization ID"
+// @Success 200 {array} models.FederationProviderResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/federation/providers [get]
+func (h *FederationHandler) GetLoginProviders(c *gin.Context) {
+	var organizationID *uint
+	if value := c.Query("organization_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		oid := uint(id)
+		organizationID = &oid
+	}
+
+	providers, err := h.federationService.GetLoginProviders(organizationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// Login godoc
+// @Summary Start an identity provider login
+// @Description Redirect the browser to the identity provider. After the provider signs the user in, the browser returns to redirect_url with a code to exchange at /api/auth/federation/exchange.
+// @Tags federation
+// @Param provider_id path int true "Identity provider ID"
+// @Param redirect_url query string false "Frontend URL to return to, defaults to FRONTEND_URL/federation/callback"
+// @Param organization_id query int false "Organization to sign into through a global provider"
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/federation/{provider_id}/login [get]
+func (h *FederationHandler) Login(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("provider_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity provider ID"})
+		return
+	}
+
+	var organizationID *uint
+	if value := c.Query("organization_id"); value != "" {
+		oid, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		requested := uint(oid)
+		organizationID = &requested
+	}
+
+	location, err := h.federationService.BeginLogin(uint(id), c.Query("redirect_url"), organizationID)
+	if err != nil {
+		if err.Error() == "identity provider not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// Callback godoc
+// @Summary Identity provider redirect URI
+// @Description Receives the authorization code from the identity provider, validates the ID token and redirects to the frontend with a single-use login code, or back to the profile after linking
+// @Tags federation
+// @Param provider_id path int true "Identity provider ID"
+// @Param code query string false "Authorization code"
+// @Param state query string true "State from the authorization request"
+// @Param error query string false "Error reported by the identity provider"
+// @Success 302 {string} string "Redirect to the frontend"
+// @Failure 401 {object} map[string]string
+// @Router /api/federation/{provider_id}/callback [get]
+func (h *FederationHandler) Callback(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("provider_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity provider ID"})
+		return
+	}
+
+	location, err := h.federationService.Callback(uint(id), c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// Exchange godoc
+// @Summary Complete an identity provider login
+// @Description Exchange the code handed to the frontend after an identity provider login for tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.FederationExchangeRequest true "Login code"
+// @Success 200 {object} models.LoginResponse "Tokens, or models.MFAChallengeResponse when a second factor is required"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/federation/exchange [post]
+func (h *FederationHandler) Exchange(c *gin.Context) {
+	var req models.FederationExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.federationService.ExchangeCode(&req)
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			c.JSON(http.StatusOK, mfaErr.Challenge)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetIdentities godoc
+// @Summary List linked identities
+// @Description List the identity provider accounts linked to the current user
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.UserIdentity
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/me/identities [get]
+func (h *FederationHandler) GetIdentities(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	identities, err := h.federationService.GetIdentities(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// LinkIdentity godoc
+// @Summary Link an identity provider account
+// @Description Start linking an identity provider account to the current user. Send the browser to the returned URL; it comes back to redirect_url once the account is linked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.IdentityLinkRequest true "Identity provider to link"
+// @Success 200 {object} models.IdentityLinkResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/me/identities [post]
+func (h *FederationHandler) LinkIdentity(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.IdentityLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.federationService.BeginLink(userID.(uint), &req)
+	if err != nil {
+		if err.Error() == "identity provider not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UnlinkIdentity godoc
+// @Summary Unlink an identity provider account
+// @Description Remove one of the current user's linked identities. The last sign-in method of an account without a password cannot be removed.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Identity ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/me/identities/{id} [delete]
+func (h *FederationHandler) UnlinkIdentity(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	if err := h.federationService.UnlinkIdentity(userID.(uint), uint(id)); err != nil {
+		if err.Error() == "identity not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
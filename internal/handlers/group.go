@@ -59,7 +59,16 @@ func (h *GroupHandler) GetGroups(c *gin.Context) {
 		}
 	}
 
-	response, err := h.groupService.GetGroups(query)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.groupService.GetGroups(query, orgID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -87,7 +96,16 @@ func (h *GroupHandler) GetGroup(c *gin.Context) {
 		return
 	}
 
-	group, err := h.groupService.GetGroupByID(uint(id))
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	group, err := h.groupService.GetGroupByID(uint(id), orgID)
 	if err != nil {
 		if err.Error() == "group not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -102,7 +120,7 @@ func (h *GroupHandler) GetGroup(c *gin.Context) {
 
 // CreateGroup godoc
 // @Summary Create a new group
-// @Description Create a new group (admin only)
+// @Description Create a group in the caller's organization. Callers outside any organization create global template groups.
 // @Tags groups
 // @Accept json
 // @Produce json
@@ -119,7 +137,16 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 		return
 	}
 
-	group, err := h.groupService.CreateGroup(&req)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	group, err := h.groupService.CreateGroup(&req, orgID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -130,7 +157,7 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 
 // UpdateGroup godoc
 // @Summary Update group by ID
-// @Description Update a specific group by their ID (admin only)
+// @Description Update a group in the caller's organization
 // @Tags groups
 // @Accept json
 // @Produce json
@@ -155,7 +182,16 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 		return
 	}
 
-	group, err := h.groupService.UpdateGroup(uint(id), &req)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	group, err := h.groupService.UpdateGroup(uint(id), &req, orgID)
 	if err != nil {
 		if err.Error() == "group not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -170,7 +206,7 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 
 // DeleteGroup godoc
 // @Summary Delete group by ID
-// @Description Delete a specific group by their ID (admin only)
+// @Description Delete a group in the caller's organization
 // @Tags groups
 // @Produce json
 // @Security BearerAuth
@@ -187,7 +223,16 @@ func (h *GroupHandler) DeleteGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.groupService.DeleteGroup(uint(id)); err != nil {
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	if err := h.groupService.DeleteGroup(uint(id), orgID); err != nil {
 		if err.Error() == "group not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
@@ -198,3 +243,240 @@ func (h *GroupHandler) DeleteGroup(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
 }
+
+// GetGroupMembers godoc
+// @Summary List group members
+// @Description Get a paginated list of the users in a group
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Group ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(10)
+// @Param search query string false "Search term"
+// @Success 200 {object} models.PaginatedUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/groups/{id}/members [get]
+func (h *GroupHandler) GetGroupMembers(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	query := &models.PaginationQuery{
+		Page:     1,
+		PageSize: 10,
+	}
+
+	if page := c.Query("page"); page != "" {
+		if p, err := strconv.Atoi(page); err == nil && p > 0 {
+			query.Page = p
+		}
+	}
+
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if ps, err := strconv.Atoi(pageSize); err == nil && ps > 0 && ps <= 100 {
+			query.PageSize = ps
+		}
+	}
+
+	query.Search = c.Query("search")
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.groupService.GetMembers(uint(id), query, orgID)
+	if err != nil {
+		if err.Error() == "group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AddGroupMembers godoc
+// @Summary Add group members
+// @Description Add users to a group. Users must belong to the group's organization.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Group ID"
+// @Param request body models.GroupMembersRequest true "Users to add"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/groups/{id}/members [post]
+func (h *GroupHandler) AddGroupMembers(c *gin.Context) {
+	h.changeGroupMembers(c, true)
+}
+
+// RemoveGroupMembers godoc
+// @Summary Remove group members
+// @Description Remove users from a group
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Group ID"
+// @Param request body models.GroupMembersRequest true "Users to remove"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/groups/{id}/members [delete]
+func (h *GroupHandler) RemoveGroupMembers(c *gin.Context) {
+	h.changeGroupMembers(c, false)
+}
+
+func (h *GroupHandler) changeGroupMembers(c *gin.Context, add bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req models.GroupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	message := "Members added successfully"
+	if add {
+		err = h.groupService.AddMembers(uint(id), &req, userID.(uint), orgID)
+	} else {
+		err = h.groupService.RemoveMembers(uint(id), &req, userID.(uint), orgID)
+		message = "Members removed successfully"
+	}
+	if err != nil {
+		if err.Error() == "group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// GetGroupTemplates godoc
+// @Summary List template groups
+// @Description Get the global template groups that organizations can clone
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(10)
+// @Param search query string false "Search term"
+// @Success 200 {object} models.PaginatedGroupResponse
+// @Failure 401 {object} map[string]string
+// @Router /api/groups/templates [get]
+func (h *GroupHandler) GetGroupTemplates(c *gin.Context) {
+	query := &models.PaginationQuery{
+		Page:     1,
+		PageSize: 10,
+	}
+
+	if page := c.Query("page"); page != "" {
+		if p, err := strconv.Atoi(page); err == nil && p > 0 {
+			query.Page = p
+		}
+	}
+
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if ps, err := strconv.Atoi(pageSize); err == nil && ps > 0 && ps <= 100 {
+			query.PageSize = ps
+		}
+	}
+
+	query.Search = c.Query("search")
+
+	response, err := h.groupService.GetTemplates(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CloneGroupTemplate godoc
+// @Summary Clone a template group
+// @Description Copy a global template group and its permissions into the caller's organization
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Template group ID"
+// @Param request body models.GroupCloneRequest false "Optional name for the copy"
+// @Success 201 {object} models.Group
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/groups/{id}/clone [post]
+func (h *GroupHandler) CloneGroupTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req models.GroupCloneRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	group, err := h.groupService.CloneTemplate(uint(id), &req, orgID)
+	if err != nil {
+		if err.Error() == "group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvitationHandler struct {
+	invitationService *services.InvitationService
+}
+
+func NewInvitationHandler(cfg *config.Config) *InvitationHandler {
+	return &InvitationHandler{
+		invitationService: services.NewInvitationService(cfg),
+	}
+}
+
+// CreateInvitation godoc
+// @Summary Invite someone to an organization
+// @Description Email a single-use invitation to join the organization with a role and initial groups. A pending invitation to the same email is replaced.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param request body models.InvitationRequest true "Invitation data"
+// @Success 201 {object} models.InvitationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/organizations/{id}/invitations [post]
+func (h *InvitationHandler) CreateInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	var req models.InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can send invitations"})
+		return
+	}
+
+	response, err := h.invitationService.CreateInvitation(uint(id), &req, actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "only owners can grant or revoke the owner role" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "user is already a member of this organization" {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetInvitations godoc
+// @Summary List organization invitations
+// @Description Get a paginated list of an organization's invitations, newest first
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(10)
+// @Param search query string false "Search term"
+// @Param status query string false "Invitation status" Enums(pending, accepted, revoked, expired)
+// @Success 200 {object} models.PaginatedInvitationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/invitations [get]
+func (h *InvitationHandler) GetInvitations(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	query := &models.PaginationQuery{
+		Page:     1,
+		PageSize: 10,
+	}
+
+	if page := c.Query("page"); page != "" {
+		if p, err := strconv.Atoi(page); err == nil && p > 0 {
+			query.Page = p
+		}
+	}
+
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if ps, err := strconv.Atoi(pageSize); err == nil && ps > 0 && ps <= 100 {
+			query.PageSize = ps
+		}
+	}
+
+	query.Search = c.Query("search")
+	query.Status = c.Query("status")
+
+	response, err := h.invitationService.GetInvitations(uint(id), query, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResendInvitation godoc
+// @Summary Resend an invitation
+// @Description Email a fresh link for a pending or expired invitation. The previous link and any other pending invitation to the same email stop working.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param invitation_id path int true "Invitation ID"
+// @Success 200 {object} models.InvitationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/invitations/{invitation_id}/resend [post]
+func (h *InvitationHandler) ResendInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	invitationID, err := strconv.ParseUint(c.Param("invitation_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can send invitations"})
+		return
+	}
+
+	response, err := h.invitationService.ResendInvitation(uint(id), uint(invitationID), actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" || err.Error() == "invitation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeInvitation godoc
+// @Summary Revoke an invitation
+// @Description Cancel a pending invitation so its link can no longer be used
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param invitation_id path int true "Invitation ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/invitations/{invitation_id} [delete]
+func (h *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	invitationID, err := strconv.ParseUint(c.Param("invitation_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can revoke invitations"})
+		return
+	}
+
+	if err := h.invitationService.RevokeInvitation(uint(id), uint(invitationID), actor, organizationScope(c)); err != nil {
+		if err.Error() == "organization not found" || err.Error() == "invitation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitation godoc
+// @Summary Accept an organization invitation
+// @Description Join the organization from an invitation link. Creates the account when none exists for the invited email, in which case name and password are required.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.AcceptInvitationRequest true "Invitation token and new account details"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/invitations/accept [post]
+func (h *InvitationHandler) AcceptInvitation(c *gin.Context) {
+	var req models.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.invitationService.AcceptInvitation(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userService := services.NewUserService()
+	response, _ := userService.GetUserByID(user.ID, user.OrganizationID)
+
+	c.JSON(http.StatusOK, response)
+}
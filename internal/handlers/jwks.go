@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/tokens"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct{}
+
+func NewJWKSHandler() *JWKSHandler {
+	return &JWKSHandler{}
+}
+
+// GetJWKS godoc
+// @Summary Get JSON Web Key Set
+// @Description Public keys for verifying access tokens, selected by the token's kid header
+// @Tags auth
+// @Produce json
+// @Success 200 {object} tokens.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, tokens.GetKeyManager().JWKS())
+}
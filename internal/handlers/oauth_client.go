@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthClientHandler struct {
+	clientService *services.OAuthClientService
+}
+
+func NewOAuthClientHandler() *OAuthClientHandler {
+	return &OAuthClientHandler{
+		clientService: services.NewOAuthClientService(),
+	}
+}
+
+// GetClients godoc
+// @Summary Get all OAuth clients with pagination and filtering
+// @Description Get a paginated list of OAuth clients (admin only)
+// @Tags oauth-clients
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(10)
+// @Param search query string false "Search term"
+// @Param is_active query bool false "Active status filter"
+// @Success 200 {object} models.PaginatedOAuthClientResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /api/oauth-clients [get]
+func (h *OAuthClientHandler) GetClients(c *gin.Context) {
+	query := &models.PaginationQuery{
+		Page:     1,
+		PageSize: 10,
+	}
+
+	if page := c.Query("page"); page != "" {
+		if p, err := strconv.Atoi(page); err == nil && p > 0 {
+			query.Page = p
+		}
+	}
+
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if ps, err := strconv.Atoi(pageSize); err == nil && ps > 0 && ps <= 100 {
+			query.PageSize = ps
+		}
+	}
+
+	query.Search = c.Query("search")
+
+	if isActive := c.Query("is_active"); isActive != "" {
+		if active, err := strconv.ParseBool(isActive); err == nil {
+			query.IsActive = &active
+		}
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.clientService.GetClients(query, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetClient godoc
+// @Summary Get OAuth client by ID
+// @Description Get a specific OAuth client by its ID (admin only)
+// @Tags oauth-clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "OAuth client ID"
+// @Success 200 {object} models.OAuthClient
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/oauth-clients/{id} [get]
+func (h *OAuthClientHandler) GetClient(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	client, err := h.clientService.GetClientByID(uint(id), orgID)
+	if err != nil {
+		if err.Error() == "oauth client not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
+
+// CreateClient godoc
+// @Summary Register a new OAuth client
+// @Description Register an OAuth client (admin only). For confidential clients the secret is returned once.
+// @Tags oauth-clients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.OAuthClientRequest true "OAuth client details"
+// @Success 201 {object} models.OAuthClientSecretResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/oauth-clients [post]
+func (h *OAuthClientHandler) CreateClient(c *gin.Context) {
+	var req models.OAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.clientService.CreateClient(&req, orgID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// UpdateClient godoc
+// @Summary Update OAuth client by ID
+// @Description Update a specific OAuth client (admin only)
+// @Tags oauth-clients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "OAuth client ID"
+// @Param request body models.OAuthClientUpdateRequest true "OAuth client update details"
+// @Success 200 {object} models.OAuthClient
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/oauth-clients/{id} [patch]
+func (h *OAuthClientHandler) UpdateClient(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	var req models.OAuthClientUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	client, err := h.clientService.UpdateClient(uint(id), &req, orgID)
+	if err != nil {
+		if err.Error() == "oauth client not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
+
+// RotateSecret godoc
+// @Summary Rotate OAuth client secret
+// @Description Generate a new secret for a confidential client (admin only). The old secret stops working immediately.
+// @Tags oauth-clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "OAuth client ID"
+// @Success 200 {object} models.OAuthClientSecretResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/oauth-clients/{id}/secret [post]
+func (h *OAuthClientHandler) RotateSecret(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.clientService.RotateSecret(uint(id), orgID)
+	if err != nil {
+		if err.Error() == "oauth client not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteClient godoc
+// @Summary Delete OAuth client by ID
+// @Description Delete a specific OAuth client (admin only)
+// @Tags oauth-clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "OAuth client ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/oauth-clients/{id} [delete]
+func (h *OAuthClientHandler) DeleteClient(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	if err := h.clientService.DeleteClient(uint(id), orgID); err != nil {
+		if err.Error() == "oauth client not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OAuth client deleted successfully"})
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/ratelimit"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/authorize.html
+var templateFS embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templateFS, "templates/authorize.html"))
+
+// authorizePage is the data rendered into the login page
+type authorizePage struct {
+	Request        models.AuthorizeRequest
+	ClientName     string
+	Email          string
+	OrganizationID string
+	MFARequired    bool
+	MFAToken       string
+	Error          string
+	Fatal          bool
+}
+
+type OIDCHandler struct {
+	oidcService *services.OIDCService
+	authService *services.AuthService
+}
+
+func NewOIDCHandler(cfg *config.Config) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: services.NewOIDCService(cfg),
+		authService: services.NewAuthService(cfg),
+	}
+}
+
+// Discovery godoc
+// @Summary OpenID Connect discovery document
+// @Description OpenID provider metadata
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} models.OpenIDConfiguration
+// @Router /.well-known/openid-configuration [get]
+func (h *OIDCHandler) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.oidcService.Discovery())
+}
+
+// Authorize godoc
+// @Summary OAuth2 authorization endpoint
+// @Description Validate an authorization code request (PKCE S256 required) and render the login page
+// @Tags oauth
+// @Produce html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string true "Space separated scopes, must include openid"
+// @Param state query string false "Opaque client state"
+// @Param nonce query string false "ID token nonce"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {string} string "Login page"
+// @Failure 302 {string} string "Redirect with error"
+// @Failure 400 {string} string "Error page"
+// @Router /oauth/authorize [get]
+func (h *OIDCHandler) Authorize(c *gin.Context) {
+	var req models.AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.renderAuthorize(c, http.StatusBadRequest, authorizePage{Error: err.Error(), Fatal: true})
+		return
+	}
+
+	client, ok := h.validateAuthorize(c, &req)
+	if !ok {
+		return
+	}
+
+	h.renderAuthorize(c, http.StatusOK, authorizePage{Request: req, ClientName: client.Name})
+}
+
+// AuthorizeLogin godoc
+// @Summary Submit the authorization login form
+// @Description Check the user's credentials, then the second factor for accounts with MFA, and redirect back to the client with an authorization code
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Success 302 {string} string "Redirect with code and state"
+// @Failure 401 {string} string "Login page with error"
+// @Router /oauth/authorize [post]
+func (h *OIDCHandler) AuthorizeLogin(c *gin.Context) {
+	var req models.AuthorizeLoginRequest
+	bindErr := c.ShouldBind(&req)
+
+	client, ok := h.validateAuthorize(c, &req.AuthorizeRequest)
+	if !ok {
+		return
+	}
+
+	page := authorizePage{Request: req.AuthorizeRequest, ClientName: client.Name, Email: req.Email}
+	if req.OrganizationID != nil {
+		page.OrganizationID = strconv.FormatUint(uint64(*req.OrganizationID), 10)
+	}
+
+	if req.MFAToken != "" {
+		h.authorizeSecondFactor(c, client, &req, page)
+		return
+	}
+
+	if bindErr != nil || req.Email == "" || req.Password == "" {
+		page.Error = "Email and password are required"
+		h.renderAuthorize(c, http.StatusBadRequest, page)
+		return
+	}
+
+	user, err := h.authService.Authenticate(&models.LoginRequest{
+		Email:          req.Email,
+		Password:       req.Password,
+		OrganizationID: req.OrganizationID,
+		ClientIP:       c.ClientIP(),
+	})
+	if err != nil {
+		status := http.StatusUnauthorized
+		var blockedErr *ratelimit.BlockedError
+		if errors.As(err, &blockedErr) {
+			status = http.StatusTooManyRequests
+		}
+		page.Error = err.Error()
+		h.renderAuthorize(c, status, page)
+		return
+	}
+
+	// The second factor is checked against a challenge, like CompleteMFALogin, so its failed
+	// attempts are counted even though the correct password reset the login throttle
+	if user.MFAEnabled {
+		challenge, err := h.authService.CreateMFAChallenge(user, models.LoginMethodPassword)
+		if err != nil {
+			redirectWithError(c, &req.AuthorizeRequest, &models.OAuthError{Code: "server_error"})
+			return
+		}
+		page.MFARequired = true
+		page.MFAToken = challenge.MFAToken
+		page.Error = "Enter the code from your authenticator app"
+		h.renderAuthorize(c, http.StatusUnauthorized, page)
+		return
+	}
+
+	h.issueAuthorizationCode(c, client, &req.AuthorizeRequest, user)
+}
+
+// authorizeSecondFactor answers the MFA challenge of the password step. Once the challenge is
+// used up or expired the user starts over from the password.
+func (h *OIDCHandler) authorizeSecondFactor(c *gin.Context, client *models.OAuthClient, req *models.AuthorizeLoginRequest, page authorizePage) {
+	user, err := h.authService.VerifyMFAChallenge(req.MFAToken, req.OTP)
+	if err != nil {
+		if err.Error() != "invalid or expired mfa token" && err.Error() != "too many failed attempts, please log in again" {
+			page.MFARequired = true
+			page.MFAToken = req.MFAToken
+		}
+		page.Error = err.Error()
+		h.renderAuthorize(c, http.StatusUnauthorized, page)
+		return
+	}
+
+	h.issueAuthorizationCode(c, client, &req.AuthorizeRequest, user)
+}
+
+// issueAuthorizationCode redirects back to the client with a code for the signed in user
+func (h *OIDCHandler) issueAuthorizationCode(c *gin.Context, client *models.OAuthClient, req *models.AuthorizeRequest, user *models.User) {
+	code, err := h.oidcService.CreateAuthorizationCode(client, user, req)
+	if err != nil {
+		redirectWithError(c, req, &models.OAuthError{Code: "server_error"})
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	c.Redirect(http.StatusFound, appendQuery(req.RedirectURI, params))
+}
+
+// Token godoc
+// @Summary OAuth2 token endpoint
+// @Description Exchange an authorization code (with PKCE verifier), a refresh token or client credentials for tokens.
+// @Description Confidential clients authenticate with HTTP Basic or client_id/client_secret form fields.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI used in the authorization request"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Param scope formData string false "Requested scopes for client_credentials"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.OAuthError
+// @Router /oauth/token [post]
+func (h *OIDCHandler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var req models.TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, &models.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	clientCredentials(c, &req.ClientID, &req.ClientSecret)
+
+	var response *models.TokenResponse
+	var err error
+	switch req.GrantType {
+	case models.GrantAuthorizationCode:
+		response, err = h.oidcService.ExchangeCode(&req)
+	case models.GrantRefreshToken:
+		response, err = h.oidcService.RefreshToken(&req)
+	case models.GrantClientCredentials:
+		response, err = h.oidcService.ClientCredentials(&req)
+	default:
+		err = &models.OAuthError{Code: "unsupported_grant_type"}
+	}
+
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Introspect godoc
+// @Summary OAuth2 token introspection (RFC 7662)
+// @Description Report whether an access or refresh token is active. The caller must authenticate as a confidential client.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} models.TokenIntrospectionResponse
+// @Failure 400 {object} models.OAuthError
+// @Failure 401 {object} models.OAuthError
+// @Router /oauth/introspect [post]
+func (h *OIDCHandler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	var req models.TokenIntrospectionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, &models.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	clientCredentials(c, &req.ClientID, &req.ClientSecret)
+
+	response, err := h.oidcService.Introspect(&req)
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke godoc
+// @Summary OAuth2 token revocation (RFC 7009)
+// @Description Revoke an access or refresh token. The caller must authenticate as a confidential client.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.OAuthError
+// @Failure 401 {object} models.OAuthError
+// @Router /oauth/revoke [post]
+func (h *OIDCHandler) Revoke(c *gin.Context) {
+	var req models.TokenIntrospectionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, &models.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	clientCredentials(c, &req.ClientID, &req.ClientSecret)
+
+	if err := h.oidcService.Revoke(&req); err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// UserInfo godoc
+// @Summary OpenID Connect userinfo endpoint
+// @Description Return the standard claims for the user the access token was issued to
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Router /oauth/userinfo [get]
+func (h *OIDCHandler) UserInfo(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.oidcService.UserInfo(user.(*models.User)))
+}
+
+// validateAuthorize renders or redirects any error and reports whether the request may proceed
+func (h *OIDCHandler) validateAuthorize(c *gin.Context, req *models.AuthorizeRequest) (*models.OAuthClient, bool) {
+	client, err := h.oidcService.GetClientForRedirect(req.ClientID, req.RedirectURI)
+	if err != nil {
+		h.renderAuthorize(c, http.StatusBadRequest, authorizePage{Error: err.Error(), Fatal: true})
+		return nil, false
+	}
+
+	if err := h.oidcService.ValidateAuthorizeRequest(req); err != nil {
+		var oauthErr *models.OAuthError
+		if !errors.As(err, &oauthErr) {
+			oauthErr = &models.OAuthError{Code: "server_error"}
+		}
+		redirectWithError(c, req, oauthErr)
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (h *OIDCHandler) renderAuthorize(c *gin.Context, status int, page authorizePage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Status(status)
+	if err := authorizeTemplate.Execute(c.Writer, page); err != nil {
+		c.Error(err)
+	}
+}
+
+// clientCredentials reads client_secret_basic credentials, which take precedence over client_secret_post
+func clientCredentials(c *gin.Context, clientID, clientSecret *string) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		*clientID, _ = url.QueryUnescape(id)
+		*clientSecret, _ = url.QueryUnescape(secret)
+	}
+}
+
+func redirectWithError(c *gin.Context, req *models.AuthorizeRequest, oauthErr *models.OAuthError) {
+	params := url.Values{"error": {oauthErr.Code}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	c.Redirect(http.StatusFound, appendQuery(req.RedirectURI, params))
+}
+
+// writeOAuthError writes an RFC 6749 error body, hiding unexpected internal errors
+func writeOAuthError(c *gin.Context, err error) {
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, &models.OAuthError{Code: "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	}
+	c.JSON(status, oauthErr)
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
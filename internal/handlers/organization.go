@@ -21,7 +21,7 @@ func NewOrganizationHandler() *OrganizationHandler {
 
 // GetOrganizations godoc
 // @Summary Get all organizations with pagination and filtering
-// @Description Get a paginated list of organizations with optional filtering (admin only)
+// @Description Get a paginated list of organizations with optional filtering, limited to the caller's own organization unless they are a superuser
 // @Tags organizations
 // @Produce json
 // @Security BearerAuth
@@ -53,7 +53,7 @@ func (h *OrganizationHandler) GetOrganizations(c *gin.Context) {
 
 	query.Search = c.Query("search")
 
-	response, err := h.organizationService.GetOrganizations(query)
+	response, err := h.organizationService.GetOrganizations(query, organizationScope(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -64,7 +64,7 @@ func (h *OrganizationHandler) GetOrganizations(c *gin.Context) {
 
 // GetOrganization godoc
 // @Summary Get organization by ID
-// @Description Get a specific organization by their ID (admin only)
+// @Description Get a specific organization by their ID, organization admins only see their own
 // @Tags organizations
 // @Produce json
 // @Security BearerAuth
@@ -82,7 +82,7 @@ func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
 		return
 	}
 
-	response, err := h.organizationService.GetOrganizationByID(uint(id))
+	response, err := h.organizationService.GetOrganizationByID(uint(id), organizationScope(c))
 	if err != nil {
 		if err.Error() == "organization not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -97,7 +97,7 @@ func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
 
 // CreateOrganization godoc
 // @Summary Create a new organization
-// @Description Create a new organization (admin only)
+// @Description Create a new organization (superuser only)
 // @Tags organizations
 // @Accept json
 // @Produce json
@@ -126,7 +126,7 @@ func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
 
 // UpdateOrganization godoc
 // @Summary Update organization by ID
-// @Description Update a specific organization by their ID (admin only)
+// @Description Update a specific organization by their ID, organization admins can only update their own
 // @Tags organizations
 // @Accept json
 // @Produce json
@@ -152,7 +152,7 @@ func (h *OrganizationHandler) UpdateOrganization(c *gin.Context) {
 		return
 	}
 
-	response, err := h.organizationService.UpdateOrganization(uint(id), &req)
+	response, err := h.organizationService.UpdateOrganization(uint(id), &req, organizationScope(c))
 	if err != nil {
 		if err.Error() == "organization not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -167,7 +167,7 @@ func (h *OrganizationHandler) UpdateOrganization(c *gin.Context) {
 
 // DeleteOrganization godoc
 // @Summary Delete organization by ID
-// @Description Delete a specific organization by their ID (admin only)
+// @Description Delete a specific organization by their ID (superuser only)
 // @Tags organizations
 // @Produce json
 // @Security BearerAuth
@@ -196,3 +196,220 @@ func (h *OrganizationHandler) DeleteOrganization(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted successfully"})
 }
+
+// GetOrganizationMembers godoc
+// @Summary List organization members
+// @Description Get a paginated list of an organization's members and their roles
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(10)
+// @Param search query string false "Search term"
+// @Success 200 {object} models.PaginatedOrganizationMemberResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/members [get]
+func (h *OrganizationHandler) GetOrganizationMembers(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	query := &models.PaginationQuery{
+		Page:     1,
+		PageSize: 10,
+	}
+
+	if page := c.Query("page"); page != "" {
+		if p, err := strconv.Atoi(page); err == nil && p > 0 {
+			query.Page = p
+		}
+	}
+
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if ps, err := strconv.Atoi(pageSize); err == nil && ps > 0 && ps <= 100 {
+			query.PageSize = ps
+		}
+	}
+
+	query.Search = c.Query("search")
+
+	response, err := h.organizationService.GetMembers(uint(id), query, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateOrganizationMember godoc
+// @Summary Change a member's role
+// @Description Set a member's role to owner, admin or member. Only owners and superusers can grant or revoke owner.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param user_id path int true "User ID"
+// @Param request body models.OrganizationMemberRoleRequest true "New role"
+// @Success 200 {object} models.OrganizationMemberResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/members/{user_id} [patch]
+func (h *OrganizationHandler) UpdateOrganizationMember(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.OrganizationMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can change roles"})
+		return
+	}
+
+	response, err := h.organizationService.SetMemberRole(uint(id), uint(userID), req.Role, actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" || err.Error() == "member not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "only owners can grant or revoke the owner role" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDomainVerification godoc
+// @Summary Get the domain verification record
+// @Description Get the DNS TXT record that proves ownership of the organization's domain. Once verified, users registering with an email at the domain join the organization.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.DomainVerificationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/domain [get]
+func (h *OrganizationHandler) GetDomainVerification(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	response, err := h.organizationService.GetDomainVerification(uint(id), organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "organization has no domain" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// VerifyDomain godoc
+// @Summary Verify the organization's domain
+// @Description Check the DNS TXT record from GET /api/organizations/{id}/domain and mark the domain as verified
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.DomainVerificationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /api/organizations/{id}/domain/verify [post]
+func (h *OrganizationHandler) VerifyDomain(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can verify domains"})
+		return
+	}
+
+	response, err := h.organizationService.VerifyDomain(uint(id), actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "domain is already verified by another organization" {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "domain verification lookup failed" {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// organizationScope limits organization routes to the caller's own organization.
+// Only platform superusers get nil, which the service treats as every organization.
+func organizationScope(c *gin.Context) *uint {
+	if user, ok := c.Get("user"); ok {
+		if userObj, ok := user.(*models.User); ok && userObj.IsSuperuser {
+			return nil
+		}
+	}
+
+	organizationID, _ := c.Get("organization_id")
+	if oid, ok := organizationID.(*uint); ok && oid != nil {
+		return oid
+	}
+
+	// Callers outside any organization can only see organizations as a superuser
+	none := uint(0)
+	return &none
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationSettingsHandler struct {
+	settingsService *services.OrganizationSettingsService
+}
+
+func NewOrganizationSettingsHandler(cfg *config.Config) *OrganizationSettingsHandler {
+	return &OrganizationSettingsHandler{
+		settingsService: services.NewOrganizationSettingsService(cfg),
+	}
+}
+
+// GetSettings godoc
+// @Summary Get organization settings
+// @Description Get the organization's overrides of the global configuration and the settings in effect for its users
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.OrganizationSettingsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/settings [get]
+func (h *OrganizationSettingsHandler) GetSettings(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	response, err := h.settingsService.GetSettings(uint(id), organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateSettings godoc
+// @Summary Update organization settings
+// @Description Override global settings for the organization. Fields left out are unchanged; fields named in reset go back to the global value.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param request body models.OrganizationSettingsRequest true "Settings to change"
+// @Success 200 {object} models.OrganizationSettingsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/settings [patch]
+func (h *OrganizationSettingsHandler) UpdateSettings(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	var req models.OrganizationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can change settings"})
+		return
+	}
+
+	response, err := h.settingsService.UpdateSettings(uint(id), &req, actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
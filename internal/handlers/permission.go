@@ -94,7 +94,16 @@ func (h *PermissionHandler) GetAuthGroups(c *gin.Context) {
 
 	query.Search = c.Query("search")
 
-	response, err := h.permissionService.GetAuthGroups(query)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	response, err := h.permissionService.GetAuthGroups(query, orgID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -122,7 +131,16 @@ func (h *PermissionHandler) CreateAuthGroup(c *gin.Context) {
 		return
 	}
 
-	authGroup, err := h.permissionService.CreateAuthGroup(&req)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	authGroup, err := h.permissionService.CreateAuthGroup(&req, orgID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -144,16 +162,30 @@ func (h *PermissionHandler) CreateAuthGroup(c *gin.Context) {
 // @Router /api/auth-groups/{id} [get]
 func (h *PermissionHandler) GetAuthGroup(c *gin.Context) {
 	idStr := c.Param("id")
-	_, err := strconv.ParseUint(idStr, 10, 32)
+	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auth group ID"})
 		return
 	}
 
-	// For now, return a simple implementation - in a real app this would get by ID
-	var authGroup models.AuthGroup
-	authGroup.ID = 1
-	authGroup.Name = "Sample Group"
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	authGroup, err := h.permissionService.GetAuthGroup(uint(id), orgID)
+	if err != nil {
+		if err.Error() == "auth group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, authGroup)
 }
@@ -185,8 +217,21 @@ func (h *PermissionHandler) UpdateAuthGroup(c *gin.Context) {
 		return
 	}
 
-	authGroup, err := h.permissionService.UpdateAuthGroup(uint(id), &req)
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	authGroup, err := h.permissionService.UpdateAuthGroup(uint(id), &req, orgID)
 	if err != nil {
+		if err.Error() == "auth group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -213,7 +258,20 @@ func (h *PermissionHandler) DeleteAuthGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.permissionService.DeleteAuthGroup(uint(id)); err != nil {
+	// Get organization context from JWT claims
+	organizationID, _ := c.Get("organization_id")
+	var orgID *uint
+	if organizationID != nil {
+		if oid, ok := organizationID.(*uint); ok {
+			orgID = oid
+		}
+	}
+
+	if err := h.permissionService.DeleteAuthGroup(uint(id), orgID); err != nil {
+		if err.Error() == "auth group not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
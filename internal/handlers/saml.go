@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/middleware"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SAMLHandler struct {
+	samlService *services.SAMLService
+}
+
+func NewSAMLHandler(cfg *config.Config) *SAMLHandler {
+	return &SAMLHandler{
+		samlService: services.NewSAMLService(cfg),
+	}
+}
+
+// GetConnection godoc
+// @Summary Get the organization's SAML connection
+// @Description Get the IdP configuration, the certificates found in its metadata and the SP endpoints to register with the IdP
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} models.SAMLConnectionResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/saml [get]
+func (h *SAMLHandler) GetConnection(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	response, err := h.samlService.GetConnection(uint(id), organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" || err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SaveConnection godoc
+// @Summary Create or replace the organization's SAML connection
+// @Description Upload the IdP metadata, pin its signing certificates and map assertion attributes and groups. The metadata may be left out when updating.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param request body models.SAMLConnectionRequest true "SAML connection"
+// @Success 200 {object} models.SAMLConnectionResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/saml [put]
+func (h *SAMLHandler) SaveConnection(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	var req models.SAMLConnectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can configure SAML"})
+		return
+	}
+
+	response, err := h.samlService.SaveConnection(uint(id), &req, actor, organizationScope(c))
+	if err != nil {
+		if err.Error() == "organization not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteConnection godoc
+// @Summary Delete the organization's SAML connection
+// @Description Stop signing in through the IdP. Provisioned users keep their accounts.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/organizations/{id}/saml [delete]
+func (h *SAMLHandler) DeleteConnection(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	actor, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can configure SAML"})
+		return
+	}
+
+	if err := h.samlService.DeleteConnection(uint(id), actor, organizationScope(c)); err != nil {
+		if err.Error() == "organization not found" || err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SAML connection deleted successfully"})
+}
+
+// Metadata godoc
+// @Summary SAML service provider metadata
+// @Description The SP metadata to register with the organization's IdP
+// @Tags saml
+// @Produce xml
+// @Param org_id path int true "Organization ID"
+// @Success 200 {string} string "EntityDescriptor XML"
+// @Failure 404 {object} map[string]string
+// @Router /api/saml/{org_id}/metadata [get]
+func (h *SAMLHandler) Metadata(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("org_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	metadata, err := h.samlService.Metadata(uint(id))
+	if err != nil {
+		if err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Login godoc
+// @Summary Start a SAML login
+// @Description Redirect the browser to the organization's IdP. After the IdP signs the user in, the browser returns to redirect_url with a code to exchange at /api/auth/saml/exchange.
+// @Tags saml
+// @Param org_id path int true "Organization ID"
+// @Param redirect_url query string false "Frontend URL to return to, defaults to FRONTEND_URL/sso/callback"
+// @Success 302 {string} string "Redirect to the IdP"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/saml/{org_id}/login [get]
+func (h *SAMLHandler) Login(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("org_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	location, err := h.samlService.BeginLogin(uint(id), c.Query("redirect_url"))
+	if err != nil {
+		if err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// AssertionConsumer godoc
+// @Summary SAML assertion consumer service
+// @Description Receives the IdP's HTTP-POST response, provisions the user into the organization and redirects to the frontend with a single-use login code. Responses to a link started at /api/auth/saml/link redirect to the link's redirect_url.
+// @Tags saml
+// @Accept x-www-form-urlencoded
+// @Param org_id path int true "Organization ID"
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string true "Relay state from the login request"
+// @Success 302 {string} string "Redirect to the frontend with a code"
+// @Failure 401 {object} map[string]string
+// @Router /api/saml/{org_id}/acs [post]
+func (h *SAMLHandler) AssertionConsumer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("org_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	location, err := h.samlService.ConsumeAssertion(uint(id), c.PostForm("SAMLResponse"), c.PostForm("RelayState"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// SingleLogout godoc
+// @Summary SAML single logout service
+// @Description Receives IdP logout requests, which revoke the user's SAML sessions, and responses to logouts started at /api/auth/saml/logout
+// @Tags saml
+// @Param org_id path int true "Organization ID"
+// @Param SAMLRequest query string false "Logout request from the IdP"
+// @Param SAMLResponse query string false "Logout response from the IdP"
+// @Success 302 {string} string "Redirect back to the IdP or the frontend"
+// @Failure 400 {object} map[string]string
+// @Router /api/saml/{org_id}/slo [get]
+// @Router /api/saml/{org_id}/slo [post]
+func (h *SAMLHandler) SingleLogout(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("org_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	location, err := h.samlService.HandleLogout(uint(id), c.Request)
+	if err != nil {
+		if err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// Exchange godoc
+// @Summary Complete a SAML login
+// @Description Exchange the code handed to the frontend after a SAML login for tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.SAMLExchangeRequest true "Login code"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/saml/exchange [post]
+func (h *SAMLHandler) Exchange(c *gin.Context) {
+	var req models.SAMLExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.samlService.ExchangeCode(&req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Link godoc
+// @Summary Link a SAML account
+// @Description Start linking the current user's account at an organization's IdP, so it can sign in with SAML without an address at the organization's verified domain. Send the browser to the returned URL; it comes back to redirect_url once the account is linked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SAMLLinkRequest true "Organization whose IdP to link"
+// @Success 200 {object} models.IdentityLinkResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/saml/link [post]
+func (h *SAMLHandler) Link(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.SAMLLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.samlService.BeginLink(userID.(uint), &req)
+	if err != nil {
+		if err.Error() == "saml connection not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout godoc
+// @Summary Log out of a SAML session
+// @Description Revoke the current tokens and, when the session came from a SAML login, return the IdP single logout URL to send the browser to
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SAMLLogoutRequest true "Refresh token of the session"
+// @Success 200 {object} models.SAMLLogoutResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/saml/logout [post]
+func (h *SAMLHandler) Logout(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.SAMLLogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.samlService.Logout(claims.(*middleware.Claims), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
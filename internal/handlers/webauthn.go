@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	webAuthnService *services.WebAuthnService
+}
+
+func NewWebAuthnHandler(cfg *config.Config) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		webAuthnService: services.NewWebAuthnService(cfg),
+	}
+}
+
+// BeginRegistration godoc
+// @Summary Start passkey registration
+// @Description Return PublicKeyCredentialCreationOptions for navigator.credentials.create()
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.WebAuthnBeginResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/auth/webauthn/register/begin [post]
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	response, err := h.webAuthnService.BeginRegistration(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// FinishRegistration godoc
+// @Summary Finish passkey registration
+// @Description Verify the authenticator's attestation response and store the credential
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.WebAuthnRegisterFinishRequest true "Session token and credential"
+// @Success 201 {object} models.WebAuthnCredential
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/webauthn/register/finish [post]
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req models.WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.webAuthnService.FinishRegistration(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+// BeginLogin godoc
+// @Summary Start passkey login
+// @Description Return PublicKeyCredentialRequestOptions for navigator.credentials.get(). The email is optional; without it any discoverable passkey is accepted.
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Param request body models.WebAuthnLoginBeginRequest false "Optional account hint"
+// @Success 200 {object} models.WebAuthnBeginResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/auth/webauthn/login/begin [post]
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	var req models.WebAuthnLoginBeginRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	response, err := h.webAuthnService.BeginLogin(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// FinishLogin godoc
+// @Summary Finish passkey login
+// @Description Verify the authenticator's assertion and return the same tokens as password login
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Param request body models.WebAuthnLoginFinishRequest true "Session token and credential"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/webauthn/login/finish [post]
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var req models.WebAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := h.webAuthnService.FinishLogin(&req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetCredentials godoc
+// @Summary List passkeys
+// @Description List the current user's registered authenticators
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.WebAuthnCredential
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/webauthn/credentials [get]
+func (h *WebAuthnHandler) GetCredentials(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	credentials, err := h.webAuthnService.GetCredentials(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// UpdateCredential godoc
+// @Summary Rename passkey
+// @Description Change the display name of one of the current user's authenticators
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Credential ID"
+// @Param request body models.WebAuthnCredentialUpdateRequest true "New name"
+// @Success 200 {object} models.WebAuthnCredential
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/webauthn/credentials/{id} [patch]
+func (h *WebAuthnHandler) UpdateCredential(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	var req models.WebAuthnCredentialUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.webAuthnService.UpdateCredential(userID.(uint), uint(id), &req)
+	if err != nil {
+		if err.Error() == "credential not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+// DeleteCredential godoc
+// @Summary Remove passkey
+// @Description Remove one of the current user's authenticators
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Credential ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/auth/webauthn/credentials/{id} [delete]
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	if err := h.webAuthnService.DeleteCredential(userID.(uint), uint(id)); err != nil {
+		if err.Error() == "credential not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Credential removed successfully"})
+}
@@ -1,9 +1,10 @@
 package middleware
 
 import (
+	"errors"
 	"kepler-auth-go/internal/config"
-	"kepler-auth-go/internal/database"
 	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/settings"
 	"net/http"
 	"strings"
 
@@ -18,9 +19,17 @@ type Claims struct {
 	Permissions    []int  `json:"permissions,omitempty"`
 	IsAdmin        bool   `json:"is_admin"`
 	IsStaff        bool   `json:"is_staff"`
+	ClientID       string `json:"client_id,omitempty"`
+	Scope          string `json:"scope,omitempty"`
+	LoginMethod    string `json:"login_method,omitempty"` // how the user signed in, empty for client tokens
 	jwt.RegisteredClaims
 }
 
+// IsClient reports whether the token was issued to an OAuth client rather than a user
+func (c *Claims) IsClient() bool {
+	return c.UserID == 0 && c.ClientID != ""
+}
+
 func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -37,50 +46,99 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(bearerToken[1], &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWT.Secret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		principal, err := Authenticate(bearerToken[1])
+		if err != nil {
+			status, message := authError(err)
+			c.JSON(status, gin.H{"error": message})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(*Claims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+		claims := principal.Claims
+
+		if principal.Client != nil {
+			c.Set("client", principal.Client)
+			c.Set("organization_id", claims.OrganizationID)
+			c.Set("scopes", strings.Fields(claims.Scope))
+			c.Set("claims", claims)
+			c.Next()
 			return
 		}
 
-		var user models.User
-		if err := database.GetDB().Preload("Groups").Preload("Organization").First(&user, claims.UserID).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		// Superusers are exempt so an allowlist can never lock out the platform operators
+		if !principal.User.IsSuperuser {
+			effective := settings.For(cfg, principal.User.Organization)
+			if !effective.AllowsIP(c.ClientIP()) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access from this IP address is not allowed"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("user", principal.User)
+		c.Set("user_id", claims.UserID)
+		c.Set("organization_id", claims.OrganizationID)
+		c.Set("permissions", claims.Permissions)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// authError maps an Authenticate error to the response status and message
+func authError(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized, "Invalid token"
+	case errors.Is(err, ErrTokenRevoked):
+		return http.StatusUnauthorized, "Token has been revoked"
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusUnauthorized, "User not found"
+	case errors.Is(err, ErrUserDeactivated):
+		return http.StatusUnauthorized, "User account deactivated"
+	case errors.Is(err, ErrClientNotAllowed):
+		return http.StatusUnauthorized, "Client not found or inactive"
+	case errors.Is(err, ErrNotMember):
+		return http.StatusUnauthorized, "Organization membership revoked"
+	default:
+		return http.StatusInternalServerError, "Failed to validate token"
+	}
+}
+
+// MFACompliant blocks users who are required to use MFA, globally or by their organization,
+// until they have enrolled. It is left off the auth routes so those users can still reach enrollment.
+func MFACompliant(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			// OAuth client tokens have no second factor to enforce
+			c.Next()
+			return
+		}
+
+		userObj, ok := user.(*models.User)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user context"})
 			c.Abort()
 			return
 		}
 
-		if user.IsDeleted || !user.IsActive {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account deactivated"})
+		if !userObj.MFAEnabled && settings.For(cfg, userObj.Organization).RequireMFA {
+			c.JSON(http.StatusForbidden, gin.H{"error": "MFA enrollment required by your organization"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user", &user)
-		c.Set("user_id", claims.UserID)
-		c.Set("organization_id", claims.OrganizationID)
-		c.Set("permissions", claims.Permissions)
 		c.Next()
 	}
 }
 
-func AdminRequired() gin.HandlerFunc {
+// PasswordNotExpired blocks users whose password is past the policy's maximum age.
+// Apply after AuthRequired; changing the password stays reachable under /api/auth.
+func PasswordNotExpired(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
-			c.Abort()
+			c.Next()
 			return
 		}
 
@@ -91,8 +149,9 @@ func AdminRequired() gin.HandlerFunc {
 			return
 		}
 
-		if !userObj.IsAdmin && !userObj.IsStaff {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		policy := settings.For(cfg, userObj.Organization).PasswordPolicy
+		if policy.Expired(userObj.PasswordChangedAt) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password has expired and must be changed"})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"kepler-auth-go/internal/models"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission allows the request only if the caller holds the permission codename,
+// e.g. RequirePermission("change_user"). Apply after AuthRequired.
+func RequirePermission(codename string) gin.HandlerFunc {
+	return requirePermissions([]string{codename}, true)
+}
+
+// RequireAnyPermission allows the request if the caller holds at least one of the codenames
+func RequireAnyPermission(codenames ...string) gin.HandlerFunc {
+	return requirePermissions(codenames, false)
+}
+
+func requirePermissions(codenames []string, all bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// OAuth clients carry no groups, they are granted permissions as scopes
+		if _, isClient := c.Get("client"); isClient {
+			scopes := c.GetStringSlice("scopes")
+			if !hasPermissions(codenames, all, func(codename string) bool { return slices.Contains(scopes, codename) }) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+			c.Abort()
+			return
+		}
+
+		userObj, ok := user.(*models.User)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user context"})
+			c.Abort()
+			return
+		}
+
+		// Platform superusers bypass permission checks
+		if userObj.IsSuperuser {
+			c.Next()
+			return
+		}
+
+		granted := UserPermissionCodenames(userObj)
+		if !hasPermissions(codenames, all, func(codename string) bool { return granted[codename] }) {
+			// Owners and admins manage users and groups of their own organization without group grants
+			organizationID, _ := c.Get("organization_id")
+			orgID, _ := organizationID.(*uint)
+			if orgID == nil || !hasPermissions(codenames, all, func(codename string) bool { return orgAdminPermissions[codename] }) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				c.Abort()
+				return
+			}
+
+			if !IsOrgAdminRole(OrgRole(userObj, *orgID)) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func hasPermissions(codenames []string, all bool, has func(string) bool) bool {
+	for _, codename := range codenames {
+		ok := has(codename)
+		if all && !ok {
+			return false
+		}
+		if !all && ok {
+			return true
+		}
+	}
+	return all
+}
+
+// UserPermissionCodenames collects the permission codenames of the user's active groups.
+// Groups and their permissions must be preloaded, as AuthRequired does.
+func UserPermissionCodenames(user *models.User) map[string]bool {
+	granted := make(map[string]bool)
+	for _, group := range user.Groups {
+		if !group.IsActive {
+			continue
+		}
+		for _, permission := range group.Permissions {
+			granted[permission.Codename] = true
+		}
+	}
+	return granted
+}
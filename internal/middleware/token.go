@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrTokenRevoked     = errors.New("token has been revoked")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserDeactivated  = errors.New("user account deactivated")
+	ErrClientNotAllowed = errors.New("client not found or inactive")
+	ErrNotMember        = errors.New("not a member of the organization")
+)
+
+// Principal is the authenticated subject of an access token: either a user or an OAuth client
+type Principal struct {
+	Claims *Claims
+	User   *models.User
+	Client *models.OAuthClient
+}
+
+// ParseToken verifies an access token's signature, expiry and jti revocation status
+func ParseToken(raw string) (*Claims, error) {
+	keys := tokens.GetKeyManager()
+	token, err := jwt.ParseWithClaims(raw, &Claims{}, keys.Keyfunc, jwt.WithValidMethods(keys.ValidMethods()))
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := tokens.Revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// Authenticate parses an access token and loads its principal, applying every check AuthRequired does
+func Authenticate(raw string) (*Principal, error) {
+	claims, err := ParseToken(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Machine tokens carry a client principal in place of a user
+	if claims.IsClient() {
+		var client models.OAuthClient
+		if err := database.GetDB().Where("client_id = ? AND is_active = ?", claims.ClientID, true).First(&client).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrClientNotAllowed
+			}
+			return nil, err
+		}
+		return &Principal{Claims: claims, Client: &client}, nil
+	}
+
+	var user models.User
+	if err := database.GetDB().Preload("Groups.Permissions").Preload("Organization.Settings").Preload("Memberships").
+		First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if user.IsDeleted || !user.IsActive {
+		return nil, ErrUserDeactivated
+	}
+
+	if user.TokensValidAfter != nil && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(*user.TokensValidAfter)) {
+		return nil, ErrTokenRevoked
+	}
+
+	if err := scopeToTokenOrganization(&user, claims.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Claims: claims, User: &user}, nil
+}
+
+// scopeToTokenOrganization makes the loaded user act within the organization the token was issued
+// for, which may differ from their default one. Removing the membership invalidates the token.
+func scopeToTokenOrganization(user *models.User, organizationID *uint) error {
+	if organizationID == nil {
+		if user.OrganizationID != nil {
+			user.SetActiveOrganization(nil)
+		}
+		return nil
+	}
+
+	if user.Membership(*organizationID) == nil && !user.IsSuperuser {
+		return ErrNotMember
+	}
+
+	if user.Organization != nil && user.Organization.ID == *organizationID {
+		user.SetActiveOrganization(user.Organization)
+		return nil
+	}
+
+	var org models.Organization
+	if err := database.GetDB().Preload("Settings").First(&org, *organizationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotMember
+		}
+		return err
+	}
+	user.SetActiveOrganization(&org)
+	return nil
+}
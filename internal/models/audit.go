@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// AuditLog records an administrative change and who made it
+type AuditLog struct {
+	ID             uint                   `json:"id" gorm:"primaryKey"`
+	ActorID        uint                   `json:"actor_id" gorm:"not null;index"`
+	OrganizationID *uint                  `json:"organization_id,omitempty" gorm:"index"`
+	Action         string                 `json:"action" gorm:"not null;index"` // e.g. group.members.add
+	TargetType     string                 `json:"target_type" gorm:"not null"`
+	TargetID       uint                   `json:"target_id" gorm:"not null"`
+	Details        map[string]interface{} `json:"details,omitempty" gorm:"serializer:json"`
+	CreatedAt      time.Time              `json:"created_at" gorm:"index"`
+}
+
+// Audit actions
+const (
+	AuditGroupMembersAdd        = "group.members.add"
+	AuditGroupMembersRemove     = "group.members.remove"
+	AuditUserGroupsReplace      = "user.groups.replace"
+	AuditOrgMemberRole          = "organization.member.role"
+	AuditInvitationCreate       = "organization.invitation.create"
+	AuditInvitationResend       = "organization.invitation.resend"
+	AuditInvitationRevoke       = "organization.invitation.revoke"
+	AuditInvitationAccept       = "organization.invitation.accept"
+	AuditOrgDomainVerify        = "organization.domain.verify"
+	AuditOrgDomainJoin          = "organization.domain.join"
+	AuditOrgSettingsUpdate      = "organization.settings.update"
+	AuditSAMLUpdate             = "organization.saml.update"
+	AuditSAMLDelete             = "organization.saml.delete"
+	AuditSAMLProvision          = "organization.saml.provision"
+	AuditSAMLLink               = "organization.saml.link"
+	AuditIdentityProviderCreate = "identity_provider.create"
+	AuditIdentityProviderUpdate = "identity_provider.update"
+	AuditIdentityProviderDelete = "identity_provider.delete"
+	AuditIdentityLink           = "user.identity.link"
+	AuditIdentityUnlink         = "user.identity.unlink"
+	AuditIdentityProvision      = "user.identity.provision"
+)
@@ -0,0 +1,125 @@
+package models
+
+import "time"
+
+// IdentityProvider is an upstream OpenID Connect provider users can sign in through, such as
+// Google Workspace, Azure AD or Keycloak. Providers without an organization are global.
+type IdentityProvider struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	Name           string        `json:"name" gorm:"not null"`
+	OrganizationID *uint         `json:"organization_id,omitempty" gorm:"index"`
+	Organization   *Organization `json:"-" gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE"`
+	Issuer         string        `json:"issuer" gorm:"not null"` // discovery is read from {issuer}/.well-known/openid-configuration
+	ClientID       string        `json:"client_id" gorm:"not null"`
+	ClientSecret   string        `json:"-"` // AES-GCM encrypted, empty for public clients
+	Scopes         []string      `json:"scopes" gorm:"serializer:json"`
+	// AllowedDomains limits the email domains the provider may sign in, empty allows every domain
+	AllowedDomains []string `json:"allowed_domains" gorm:"serializer:json"`
+	// AutoProvision creates accounts for identities that are not linked yet (JIT provisioning)
+	AutoProvision bool      `json:"auto_provision" gorm:"default:false"`
+	IsActive      bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UserIdentity links an account at an identity provider, its subject, to a user
+type UserIdentity struct {
+	ID          uint              `json:"id" gorm:"primaryKey"`
+	UserID      uint              `json:"user_id" gorm:"not null;index;uniqueIndex:idx_user_identity_user"`
+	User        *User             `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	ProviderID  uint              `json:"provider_id" gorm:"not null;uniqueIndex:idx_user_identity_subject;uniqueIndex:idx_user_identity_user"`
+	Provider    *IdentityProvider `json:"provider,omitempty" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+	Subject     string            `json:"-" gorm:"not null;uniqueIndex:idx_user_identity_subject"`
+	Email       string            `json:"email"` // as last reported by the provider
+	LastLoginAt *time.Time        `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// FederationLogin follows a login or account link from the redirect to the provider until the
+// resulting login code is exchanged
+type FederationLogin struct {
+	ID             uint              `json:"id" gorm:"primaryKey"`
+	ProviderID     uint              `json:"provider_id" gorm:"not null;index"`
+	Provider       *IdentityProvider `json:"-" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+	UserID         *uint             `json:"user_id,omitempty" gorm:"index"` // the linking user, or the user signed in once the provider answered
+	Purpose        string            `json:"purpose" gorm:"not null"`
+	Stage          string            `json:"stage" gorm:"not null"`
+	TokenHash      string            `json:"-" gorm:"not null;uniqueIndex"` // state while at the provider, then the login code
+	Nonce          string            `json:"-"`
+	CodeVerifier   string            `json:"-"`                         // PKCE
+	OrganizationID *uint             `json:"organization_id,omitempty"` // organization to sign into through a global provider
+	RedirectURL    string            `json:"-"`
+	ExpiresAt      time.Time         `json:"expires_at" gorm:"not null"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// Federation login purposes
+const (
+	FederationPurposeLogin = "login"
+	FederationPurposeLink  = "link"
+)
+
+// Federation login stages
+const (
+	FederationStageRequest = "request" // waiting for the provider's callback
+	FederationStageCode    = "code"    // identity verified, waiting for the login code to be exchanged
+)
+
+// Federation DTOs and Requests
+
+// IdentityProviderRequest registers an OpenID Connect provider
+type IdentityProviderRequest struct {
+	Name           string   `json:"name" binding:"required,max=100"`
+	Issuer         string   `json:"issuer" binding:"required,url"`
+	ClientID       string   `json:"client_id" binding:"required"`
+	ClientSecret   string   `json:"client_secret,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"` // defaults to openid email profile
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	AutoProvision  bool     `json:"auto_provision"`
+	OrganizationID *uint    `json:"organization_id,omitempty"` // superusers only, empty for a global provider
+}
+
+// IdentityProviderUpdateRequest changes a provider. Fields left out are unchanged.
+type IdentityProviderUpdateRequest struct {
+	Name           *string   `json:"name,omitempty" binding:"omitempty,max=100"`
+	Issuer         *string   `json:"issuer,omitempty" binding:"omitempty,url"`
+	ClientID       *string   `json:"client_id,omitempty"`
+	ClientSecret   *string   `json:"client_secret,omitempty"` // an empty string makes the client public
+	Scopes         []string  `json:"scopes,omitempty"`
+	AllowedDomains *[]string `json:"allowed_domains,omitempty"`
+	AutoProvision  *bool     `json:"auto_provision,omitempty"`
+	IsActive       *bool     `json:"is_active,omitempty"`
+}
+
+// IdentityProviderResponse shows a provider and the redirect URI to register with it
+type IdentityProviderResponse struct {
+	IdentityProvider
+	HasClientSecret bool   `json:"has_client_secret"`
+	RedirectURI     string `json:"redirect_uri"`
+	LoginURL        string `json:"login_url"`
+}
+
+// FederationProviderResponse is the public view of a provider offered on the login page
+type FederationProviderResponse struct {
+	ID             uint   `json:"id"`
+	Name           string `json:"name"`
+	OrganizationID *uint  `json:"organization_id,omitempty"`
+	LoginURL       string `json:"login_url"`
+}
+
+// FederationExchangeRequest trades the code handed to the frontend after a provider login for tokens
+type FederationExchangeRequest struct {
+	Code     string `json:"code" binding:"required"`
+	ClientIP string `json:"-"`
+}
+
+// IdentityLinkRequest starts linking a provider account to the current user
+type IdentityLinkRequest struct {
+	ProviderID  uint   `json:"provider_id" binding:"required"`
+	RedirectURL string `json:"redirect_url,omitempty"` // frontend URL to return to, defaults to FRONTEND_URL/account/identities
+}
+
+// IdentityLinkResponse carries the provider URL to send the browser to
+type IdentityLinkResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// Invitation statuses, derived from the timestamps
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// OrganizationInvitation is an emailed, single-use invite to join an organization with a role and groups
+type OrganizationInvitation struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	OrganizationID uint          `json:"organization_id" gorm:"not null;index"`
+	Organization   *Organization `json:"-" gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE"`
+	Email          string        `json:"email" gorm:"not null;index"`
+	Role           string        `json:"role" gorm:"not null;default:member"`
+	GroupIDs       []uint        `json:"group_ids,omitempty" gorm:"serializer:json"` // added on acceptance
+	TokenHash      string        `json:"-" gorm:"not null;uniqueIndex"`
+	RedirectURL    string        `json:"-"` // reused when the invitation is resent
+	InvitedByID    uint          `json:"invited_by_id" gorm:"not null"`
+	ExpiresAt      time.Time     `json:"expires_at" gorm:"not null"`
+	AcceptedAt     *time.Time    `json:"accepted_at,omitempty"`
+	AcceptedByID   *uint         `json:"accepted_by_id,omitempty"`
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// Status reports whether the invitation can still be accepted
+func (i *OrganizationInvitation) Status() string {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case time.Now().After(i.ExpiresAt):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
+
+// Invitation DTOs and Requests
+
+// InvitationRequest for inviting someone to an organization
+type InvitationRequest struct {
+	Email       string `json:"email" binding:"required,email"`
+	Role        string `json:"role,omitempty" binding:"omitempty,oneof=owner admin member"` // defaults to member
+	GroupIDs    []uint `json:"group_ids,omitempty"`                                         // groups of the organization to join
+	RedirectURL string `json:"redirect_url,omitempty"`                                      // target of the invitation link, must be an allowed origin
+}
+
+// AcceptInvitationRequest accepts an invitation. Name and password are only needed when no
+// account exists for the invited email yet.
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"` // checked against the organization's password policy
+}
+
+// InvitationResponse for API responses
+type InvitationResponse struct {
+	ID             uint   `json:"id"`
+	OrganizationID uint   `json:"organization_id"`
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+	GroupIDs       []uint `json:"group_ids,omitempty"`
+	Status         string `json:"status"`
+	InvitedByID    uint   `json:"invited_by_id"`
+	ExpiresAt      string `json:"expires_at"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// PaginatedInvitationResponse for Swagger documentation
+type PaginatedInvitationResponse struct {
+	Data       []InvitationResponse `json:"data"`
+	Total      int                  `json:"total"`
+	Page       int                  `json:"page"`
+	PageSize   int                  `json:"page_size"`
+	TotalPages int                  `json:"total_pages"`
+}
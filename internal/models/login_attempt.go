@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks consecutive failed logins for an account or client IP.
+// It backs the Postgres limiter store shared by all instances.
+type LoginAttempt struct {
+	Key           string     `json:"key" gorm:"primaryKey"`
+	Failures      int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time  `json:"last_failure_at" gorm:"not null"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+}
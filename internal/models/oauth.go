@@ -0,0 +1,211 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OAuth2 grant types understood by the token endpoint
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is an application allowed to request tokens through the OAuth2/OIDC endpoints.
+// Confidential clients authenticate with a secret; only its bcrypt hash is stored.
+type OAuthClient struct {
+	ID               uint          `json:"id" gorm:"primaryKey"`
+	ClientID         string        `json:"client_id" gorm:"not null;uniqueIndex"`
+	ClientSecretHash string        `json:"-"`
+	Name             string        `json:"name" gorm:"not null"`
+	IsConfidential   bool          `json:"is_confidential" gorm:"default:false"`
+	RedirectURIs     []string      `json:"redirect_uris" gorm:"serializer:json"`
+	GrantTypes       []string      `json:"grant_types" gorm:"serializer:json"`
+	AllowedScopes    []string      `json:"allowed_scopes" gorm:"serializer:json"`
+	IsActive         bool          `json:"is_active" gorm:"default:true"`
+	OrganizationID   *uint         `json:"organization_id,omitempty" gorm:"index"`
+	Organization     *Organization `json:"organization,omitempty" gorm:"foreignKey:OrganizationID"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+func (c *OAuthClient) BeforeCreate(tx *gorm.DB) error {
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (c *OAuthClient) BeforeUpdate(tx *gorm.DB) error {
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+// HasRedirectURI reports whether uri exactly matches one of the registered redirect URIs
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client may use the grant type.
+// Clients registered without explicit grant types are interactive (authorization code) clients.
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grantType == GrantAuthorizationCode || grantType == GrantRefreshToken
+	}
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// AuthorizationCode is a short-lived, single-use grant issued by /oauth/authorize
+type AuthorizationCode struct {
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	CodeHash            string     `json:"-" gorm:"not null;uniqueIndex"`
+	ClientID            string     `json:"client_id" gorm:"not null;index"`
+	UserID              uint       `json:"user_id" gorm:"not null"`
+	User                *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	RedirectURI         string     `json:"redirect_uri" gorm:"not null"`
+	Scope               string     `json:"scope"`
+	Nonce               string     `json:"nonce"`
+	CodeChallenge       string     `json:"-" gorm:"not null"`
+	CodeChallengeMethod string     `json:"-" gorm:"not null"`
+	AuthTime            time.Time  `json:"auth_time"`
+	ExpiresAt           time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt              *time.Time `json:"used_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// OAuth DTOs and Requests
+
+// AuthorizeRequest carries the /oauth/authorize parameters, from the query string or the login form
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// AuthorizeLoginRequest is the login form posted back to /oauth/authorize
+type AuthorizeLoginRequest struct {
+	AuthorizeRequest
+	Email          string `form:"email" binding:"omitempty,email"`
+	Password       string `form:"password"`
+	OrganizationID *uint  `form:"organization_id"`
+	MFAToken       string `form:"mfa_token"` // challenge from the password step, only for accounts with MFA
+	OTP            string `form:"otp"`       // TOTP or recovery code answering the challenge
+}
+
+// TokenRequest is the form body accepted by /oauth/token
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+}
+
+// TokenResponse is the RFC 6749 token endpoint response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthError is an RFC 6749 error response
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// OpenIDConfiguration is the discovery document served from /.well-known/openid-configuration
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// OAuthClientRequest for registering OAuth clients
+type OAuthClientRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	IsConfidential bool     `json:"is_confidential"`
+	RedirectURIs   []string `json:"redirect_uris"`
+	GrantTypes     []string `json:"grant_types"`
+	AllowedScopes  []string `json:"allowed_scopes"`
+	OrganizationID *uint    `json:"organization_id,omitempty"`
+}
+
+// OAuthClientUpdateRequest for updating OAuth clients
+type OAuthClientUpdateRequest struct {
+	Name          *string  `json:"name,omitempty"`
+	RedirectURIs  []string `json:"redirect_uris,omitempty"`
+	GrantTypes    []string `json:"grant_types,omitempty"`
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
+	IsActive      *bool    `json:"is_active,omitempty"`
+}
+
+// OAuthClientSecretResponse is returned when a client secret is created; the secret is never shown again
+type OAuthClientSecretResponse struct {
+	Client       *OAuthClient `json:"client"`
+	ClientSecret string       `json:"client_secret,omitempty"`
+}
+
+// PaginatedOAuthClientResponse for Swagger documentation
+type PaginatedOAuthClientResponse struct {
+	Data       []OAuthClient `json:"data"`
+	Total      int           `json:"total"`
+	Page       int           `json:"page"`
+	PageSize   int           `json:"page_size"`
+	TotalPages int           `json:"total_pages"`
+}
+
+// TokenIntrospectionRequest is the RFC 7662 / RFC 7009 form body
+type TokenIntrospectionRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// TokenIntrospectionResponse is the RFC 7662 introspection response
+type TokenIntrospectionResponse struct {
+	Active         bool   `json:"active"`
+	TokenType      string `json:"token_type,omitempty"`
+	Subject        string `json:"sub,omitempty"`
+	ClientID       string `json:"client_id,omitempty"`
+	Username       string `json:"username,omitempty"`
+	OrganizationID *uint  `json:"organization_id,omitempty"`
+	Permissions    []int  `json:"permissions,omitempty"`
+	Scope          string `json:"scope,omitempty"`
+	ExpiresAt      int64  `json:"exp,omitempty"`
+	IssuedAt       int64  `json:"iat,omitempty"`
+	JTI            string `json:"jti,omitempty"`
+}
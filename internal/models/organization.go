@@ -8,12 +8,15 @@ import (
 
 // Organization model
 type Organization struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"uniqueIndex;not null"`
-	Domain    *string   `json:"domain,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Users     []User    `json:"users,omitempty" gorm:"foreignKey:OrganizationID"`
+	ID                      uint                  `json:"id" gorm:"primaryKey"`
+	Name                    string                `json:"name" gorm:"uniqueIndex;not null"`
+	Domain                  *string               `json:"domain,omitempty"`
+	DomainVerificationToken string                `json:"-"`                            // expected in the domain's TXT challenge record
+	DomainVerifiedAt        *time.Time            `json:"domain_verified_at,omitempty"` // set once the TXT record is found, cleared when the domain changes
+	CreatedAt               time.Time             `json:"created_at"`
+	UpdatedAt               time.Time             `json:"updated_at"`
+	Settings                *OrganizationSettings `json:"-" gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE"`
+	Users                   []User                `json:"users,omitempty" gorm:"foreignKey:OrganizationID"`
 }
 
 // Organization methods
@@ -28,8 +31,37 @@ func (o *Organization) BeforeUpdate(tx *gorm.DB) error {
 	return nil
 }
 
+// HasVerifiedDomain reports whether users with an email at Domain belong to this organization
+func (o *Organization) HasVerifiedDomain() bool {
+	return o.Domain != nil && o.DomainVerifiedAt != nil
+}
+
+// Organization roles, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrganizationMember is a user's membership of an organization and their role in it
+type OrganizationMember struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	OrganizationID uint          `json:"organization_id" gorm:"not null;uniqueIndex:idx_org_member"`
+	UserID         uint          `json:"user_id" gorm:"not null;uniqueIndex:idx_org_member;index"`
+	Role           string        `json:"role" gorm:"not null;default:member"`
+	Organization   *Organization `json:"organization,omitempty" gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE"`
+	User           *User         `json:"user,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
 // Organization DTOs and Requests
 
+// OrganizationMemberRoleRequest for changing a member's role
+type OrganizationMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
 // OrganizationCreateRequest for creating organizations
 type OrganizationCreateRequest struct {
 	Name   string  `json:"name" binding:"required"`
@@ -39,16 +71,38 @@ type OrganizationCreateRequest struct {
 // OrganizationUpdateRequest for updating organizations
 type OrganizationUpdateRequest struct {
 	Name   *string `json:"name,omitempty"`
-	Domain *string `json:"domain,omitempty"`
+	Domain *string `json:"domain,omitempty"` // resets domain verification when changed
 }
 
 // OrganizationResponse for API responses
 type OrganizationResponse struct {
-	ID        uint    `json:"id"`
-	Name      string  `json:"name"`
-	Domain    *string `json:"domain,omitempty"`
-	CreatedAt string  `json:"created_at"`
-	UpdatedAt string  `json:"updated_at"`
+	ID             uint    `json:"id"`
+	Name           string  `json:"name"`
+	Domain         *string `json:"domain,omitempty"`
+	DomainVerified bool    `json:"domain_verified"`
+	CreatedAt      string  `json:"created_at"`
+	UpdatedAt      string  `json:"updated_at"`
+}
+
+// DomainVerificationResponse tells an organization admin which DNS TXT record proves ownership of the domain
+type DomainVerificationResponse struct {
+	Domain      string  `json:"domain"`
+	RecordName  string  `json:"record_name"`
+	RecordValue string  `json:"record_value"`
+	Verified    bool    `json:"verified"`
+	VerifiedAt  *string `json:"verified_at,omitempty"`
+}
+
+// DomainDiscoveryRequest for finding the organization behind an email address
+type DomainDiscoveryRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// DomainDiscoveryResponse names the organization that owns the email's domain
+type DomainDiscoveryResponse struct {
+	OrganizationID   uint    `json:"organization_id"`
+	OrganizationName string  `json:"organization_name"`
+	SSOURL           *string `json:"sso_url,omitempty"` // set when the organization signs in through SAML
 }
 
 // PaginatedOrganizationResponse for Swagger documentation
@@ -59,3 +113,21 @@ type PaginatedOrganizationResponse struct {
 	PageSize   int                    `json:"page_size"`
 	TotalPages int                    `json:"total_pages"`
 }
+
+// OrganizationMemberResponse for API responses
+type OrganizationMemberResponse struct {
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+// PaginatedOrganizationMemberResponse for Swagger documentation
+type PaginatedOrganizationMemberResponse struct {
+	Data       []OrganizationMemberResponse `json:"data"`
+	Total      int                          `json:"total"`
+	Page       int                          `json:"page"`
+	PageSize   int                          `json:"page_size"`
+	TotalPages int                          `json:"total_pages"`
+}
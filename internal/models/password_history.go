@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// PasswordHistory keeps hashes of a user's previous passwords to prevent reuse
+type PasswordHistory struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       uint      `json:"user_id" gorm:"not null;index"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -11,6 +11,8 @@ type Permission struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// AuthGroup is the legacy shape served by /api/auth-groups. Auth groups are now plain
+// Groups; the auth_groups table only exists until migration 015 folds it into groups.
 type AuthGroup struct {
 	ID          uint         `json:"id" gorm:"primaryKey"`
 	Name        string       `json:"name" gorm:"uniqueIndex;not null"`
@@ -0,0 +1,140 @@
+package models
+
+import "time"
+
+// SAMLConnection is an organization's SAML 2.0 identity provider, with this server acting as
+// the service provider. Users it asserts are provisioned into the organization on first login.
+type SAMLConnection struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	OrganizationID uint          `json:"organization_id" gorm:"not null;uniqueIndex"`
+	Organization   *Organization `json:"-" gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE"`
+	IdPEntityID    string        `json:"idp_entity_id" gorm:"not null"`
+	IdPMetadata    string        `json:"-" gorm:"type:text;not null"` // uploaded EntityDescriptor XML
+	// PinnedFingerprints are SHA-256 fingerprints (lowercase hex) of the IdP certificates trusted
+	// to sign responses. When empty every signing certificate in the metadata is trusted.
+	PinnedFingerprints []string `json:"pinned_fingerprints" gorm:"serializer:json"`
+	EmailAttribute     string   `json:"email_attribute"`  // falls back to the NameID
+	NameAttribute      string   `json:"name_attribute"`   // falls back to the email address
+	GroupsAttribute    string   `json:"groups_attribute"` // group mapping is skipped when empty
+	// GroupMapping maps IdP group values to groups of the organization. Mapped groups are
+	// kept in sync on every login; other group memberships are left alone.
+	GroupMapping  map[string][]uint `json:"group_mapping" gorm:"serializer:json"`
+	SPCertificate string            `json:"-" gorm:"type:text;not null"` // PEM, published in the SP metadata
+	SPPrivateKey  string            `json:"-" gorm:"type:text;not null"` // AES-GCM encrypted PEM
+	IsActive      bool              `json:"is_active" gorm:"default:true"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// SAMLSession follows a SAML login from the authentication request to the issued session,
+// so single logout can find the refresh token family to revoke
+type SAMLSession struct {
+	ID           uint            `json:"id" gorm:"primaryKey"`
+	ConnectionID uint            `json:"connection_id" gorm:"not null;index"`
+	UserID       *uint           `json:"user_id,omitempty" gorm:"index"` // the linking user, or the user signed in once the IdP answered
+	TokenHash    *string         `json:"-" gorm:"uniqueIndex"`           // relay state while at the IdP, then the login code; cleared once active
+	Purpose      string          `json:"purpose" gorm:"not null;default:login"`
+	Stage        string          `json:"stage" gorm:"not null"`
+	RequestID    string          `json:"-"`
+	RedirectURL  string          `json:"-"`
+	NameID       string          `json:"-" gorm:"index"`
+	SessionIndex string          `json:"-"`
+	FamilyID     string          `json:"-" gorm:"index"` // refresh token family issued for the login
+	ExpiresAt    time.Time       `json:"expires_at" gorm:"not null"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Connection   *SAMLConnection `json:"-" gorm:"foreignKey:ConnectionID;constraint:OnDelete:CASCADE"`
+}
+
+// SAMLIdentity links the NameID an organization's IdP asserts to a user. Addresses at the
+// organization's verified domain are linked on their first login, any other account has to link
+// the IdP from the user's profile.
+type SAMLIdentity struct {
+	ID           uint            `json:"id" gorm:"primaryKey"`
+	ConnectionID uint            `json:"connection_id" gorm:"not null;uniqueIndex:idx_saml_identity_name_id;uniqueIndex:idx_saml_identity_user"`
+	Connection   *SAMLConnection `json:"-" gorm:"foreignKey:ConnectionID;constraint:OnDelete:CASCADE"`
+	UserID       uint            `json:"user_id" gorm:"not null;index;uniqueIndex:idx_saml_identity_user"`
+	User         *User           `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	NameID       string          `json:"-" gorm:"not null;uniqueIndex:idx_saml_identity_name_id"`
+	Email        string          `json:"email"` // as last asserted by the IdP
+	LastLoginAt  *time.Time      `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// SAML session purposes
+const (
+	SAMLPurposeLogin = "login"
+	SAMLPurposeLink  = "link"
+)
+
+// SAML session stages
+const (
+	SAMLStageRequest = "request" // waiting for the IdP's response
+	SAMLStageCode    = "code"    // assertion accepted, waiting for the login code to be exchanged
+	SAMLStageActive  = "active"  // tokens issued
+)
+
+// SAML DTOs and Requests
+
+// SAMLConnectionRequest creates or replaces an organization's SAML connection. The metadata is
+// required when creating and kept when left out of an update.
+type SAMLConnectionRequest struct {
+	IdPMetadata        *string           `json:"idp_metadata,omitempty"` // EntityDescriptor XML
+	PinnedFingerprints []string          `json:"pinned_fingerprints,omitempty"`
+	EmailAttribute     string            `json:"email_attribute,omitempty"`
+	NameAttribute      string            `json:"name_attribute,omitempty"`
+	GroupsAttribute    string            `json:"groups_attribute,omitempty"`
+	GroupMapping       map[string][]uint `json:"group_mapping,omitempty"`
+	IsActive           *bool             `json:"is_active,omitempty"`
+}
+
+// SAMLCertificate describes a signing certificate found in the IdP metadata
+type SAMLCertificate struct {
+	Subject     string `json:"subject"`
+	Fingerprint string `json:"fingerprint"` // SHA-256, lowercase hex
+	NotAfter    string `json:"not_after"`
+	Pinned      bool   `json:"pinned"`
+}
+
+// SAMLConnectionResponse shows the IdP configuration next to the SP endpoints to register with the IdP
+type SAMLConnectionResponse struct {
+	OrganizationID     uint              `json:"organization_id"`
+	IdPEntityID        string            `json:"idp_entity_id"`
+	IdPSSOURL          string            `json:"idp_sso_url"`
+	IdPSLOURL          string            `json:"idp_slo_url,omitempty"`
+	IdPCertificates    []SAMLCertificate `json:"idp_certificates"`
+	PinnedFingerprints []string          `json:"pinned_fingerprints"`
+	EmailAttribute     string            `json:"email_attribute"`
+	NameAttribute      string            `json:"name_attribute"`
+	GroupsAttribute    string            `json:"groups_attribute"`
+	GroupMapping       map[string][]uint `json:"group_mapping"`
+	IsActive           bool              `json:"is_active"`
+	SPEntityID         string            `json:"sp_entity_id"`
+	SPMetadataURL      string            `json:"sp_metadata_url"`
+	ACSURL             string            `json:"acs_url"`
+	SLOURL             string            `json:"slo_url"`
+	LoginURL           string            `json:"login_url"`
+	CreatedAt          string            `json:"created_at"`
+	UpdatedAt          string            `json:"updated_at"`
+}
+
+// SAMLExchangeRequest trades the code handed to the frontend after a SAML login for tokens
+type SAMLExchangeRequest struct {
+	Code     string `json:"code" binding:"required"`
+	ClientIP string `json:"-"`
+}
+
+// SAMLLinkRequest starts linking the IdP account of an organization the user belongs to
+type SAMLLinkRequest struct {
+	OrganizationID uint   `json:"organization_id" binding:"required"`
+	RedirectURL    string `json:"redirect_url,omitempty"` // frontend URL to return to, defaults to FRONTEND_URL/account/identities
+}
+
+// SAMLLogoutRequest ends a SAML session locally and at the IdP
+type SAMLLogoutRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// SAMLLogoutResponse carries the IdP single logout URL to send the browser to, if any
+type SAMLLogoutResponse struct {
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
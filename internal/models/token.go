@@ -0,0 +1,106 @@
+package models
+
+import "time"
+
+// RefreshToken is an opaque, single-use credential exchanged for a new access token.
+// Tokens issued from the same login share a FamilyID so that reuse of a rotated
+// token can revoke every descendant.
+type RefreshToken struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`
+	User         *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	FamilyID     string     `json:"family_id" gorm:"not null;index"`
+	TokenHash    string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+	// OrganizationID keeps refreshed access tokens scoped to the organization the session was opened in
+	OrganizationID *uint     `json:"organization_id,omitempty"`
+	LoginMethod    string    `json:"login_method,omitempty"` // how the session was signed in, see LoginMethodPassword
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// IsExpired reports whether the refresh token can no longer be used
+func (t *RefreshToken) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+// Token DTOs and Requests
+
+// RefreshTokenRequest for exchanging a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	ClientIP     string `json:"-"` // set by the handler for the organization's IP allowlist
+}
+
+// RevokedToken records an access token (by jti) that was invalidated before it expired.
+// Rows can be pruned once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LogoutRequest for ending the current session
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// SigningKey is an asymmetric key pair used to sign access tokens.
+// Retired keys keep being published in the JWKS until ExpiresAt.
+type SigningKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	KID        string     `json:"kid" gorm:"column:kid;not null;uniqueIndex"`
+	Algorithm  string     `json:"algorithm" gorm:"not null"`
+	PrivateKey string     `json:"-" gorm:"not null"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use, time-limited token emailed to reset a password
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	User      *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Passwordless login modes
+const (
+	EmailLoginModeLink = "link"
+	EmailLoginModeCode = "code"
+)
+
+// EmailLoginToken is a single-use magic link or one-time code emailed for passwordless login
+type EmailLoginToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	User      *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Mode      string     `json:"mode" gorm:"not null"`
+	TokenHash string     `json:"-" gorm:"not null"`
+	Attempts  int        `json:"attempts" gorm:"default:0"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MagicLinkRequest asks for a passwordless login email
+type MagicLinkRequest struct {
+	Email       string `json:"email" binding:"required,email"`
+	Mode        string `json:"mode,omitempty" binding:"omitempty,oneof=link code"` // link (default) or code
+	RedirectURL string `json:"redirect_url,omitempty"`
+	ClientIP    string `json:"-"` // set by the handler for throttling
+}
+
+// MagicLinkVerifyRequest exchanges a magic link token, or an email and one-time code, for tokens
+type MagicLinkVerifyRequest struct {
+	Token          string `json:"token,omitempty"`
+	Email          string `json:"email,omitempty" binding:"omitempty,email"`
+	OrganizationID *uint  `json:"organization_id,omitempty"` // organization to sign into, defaults to the user's default
+	Code           string `json:"code,omitempty"`
+	ClientIP       string `json:"-"` // set by the handler for the organization's IP allowlist
+}
@@ -8,27 +8,38 @@ import (
 
 // User model
 type User struct {
-	ID             uint          `json:"id" gorm:"primaryKey"`
-	Email          string        `json:"email" gorm:"not null;index:idx_email_org,unique"`
-	Name           string        `json:"name" gorm:"not null"`
-	Password       string        `json:"-" gorm:"not null"`
-	PhoneNumber    *string       `json:"phone_number,omitempty"`
-	ProfilePicture *string       `json:"profile_picture,omitempty"`
-	Country        *string       `json:"country,omitempty"`
-	City           *string       `json:"city,omitempty"`
-	WhatsappNo     *string       `json:"whatsapp_no,omitempty"`
-	SendWhatsapp   bool          `json:"send_whatsapp" gorm:"default:false"`
-	SendEmail      bool          `json:"send_email" gorm:"default:false"`
-	IsVerified     bool          `json:"is_verified" gorm:"default:false"`
-	IsDeleted      bool          `json:"is_deleted" gorm:"default:false"`
-	IsStaff        bool          `json:"is_staff" gorm:"default:false"`
-	IsAdmin        bool          `json:"is_admin" gorm:"default:false"`
-	IsActive       bool          `json:"is_active" gorm:"default:true"`
-	OrganizationID *uint         `json:"organization_id,omitempty" gorm:"index:idx_email_org,unique;index"`
-	Organization   *Organization `json:"organization,omitempty" gorm:"foreignKey:OrganizationID"`
-	CreatedAt      time.Time     `json:"created_at"`
-	UpdatedAt      time.Time     `json:"updated_at"`
-	Groups         []Group       `json:"groups,omitempty" gorm:"many2many:user_groups;"`
+	ID             uint    `json:"id" gorm:"primaryKey"`
+	Email          string  `json:"email" gorm:"not null;uniqueIndex"`
+	Name           string  `json:"name" gorm:"not null"`
+	Password       string  `json:"-" gorm:"not null"`
+	PhoneNumber    *string `json:"phone_number,omitempty"`
+	ProfilePicture *string `json:"profile_picture,omitempty"`
+	Country        *string `json:"country,omitempty"`
+	City           *string `json:"city,omitempty"`
+	WhatsappNo     *string `json:"whatsapp_no,omitempty"`
+	SendWhatsapp   bool    `json:"send_whatsapp" gorm:"default:false"`
+	SendEmail      bool    `json:"send_email" gorm:"default:false"`
+	IsVerified     bool    `json:"is_verified" gorm:"default:false"`
+	IsDeleted      bool    `json:"is_deleted" gorm:"default:false"`
+	IsStaff        bool    `json:"is_staff" gorm:"default:false"`
+	IsAdmin        bool    `json:"is_admin" gorm:"default:false"`
+	IsSuperuser    bool    `json:"is_superuser" gorm:"default:false"` // platform operator, bypasses permission and organization checks
+	IsActive       bool    `json:"is_active" gorm:"default:true"`
+	// OrganizationID is the organization signed into by default; after Authenticate it is the
+	// token's organization. Access to an organization comes from Memberships.
+	OrganizationID *uint                `json:"organization_id,omitempty" gorm:"index"`
+	Organization   *Organization        `json:"organization,omitempty" gorm:"foreignKey:OrganizationID"`
+	Memberships    []OrganizationMember `json:"-" gorm:"foreignKey:UserID"`
+	// Access tokens issued before this moment are rejected (logout-all, password change)
+	TokensValidAfter   *time.Time `json:"-"`
+	MFAEnabled         bool       `json:"mfa_enabled" gorm:"default:false"`
+	TOTPSecret         string     `json:"-"` // AES-GCM encrypted, set on setup and kept once verified
+	TOTPLastStep       int64      `json:"-" gorm:"default:0"`
+	VerificationSentAt *time.Time `json:"-"` // throttles verification email resends
+	PasswordChangedAt  *time.Time `json:"password_changed_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	Groups             []Group    `json:"groups,omitempty" gorm:"many2many:user_groups;"`
 }
 
 // Group model
@@ -36,7 +47,8 @@ type Group struct {
 	ID             uint          `json:"id" gorm:"primaryKey"`
 	Name           string        `json:"name" gorm:"not null;index:idx_group_name_org,unique"`
 	Description    *string       `json:"description,omitempty"`
-	Permissions    []int         `json:"permissions" gorm:"type:integer[]"`
+	Permissions    []Permission  `json:"-" gorm:"many2many:group_permissions;constraint:OnDelete:CASCADE"`
+	PermissionIDs  []uint        `json:"permissions" gorm:"-"` // filled from Permissions after loading
 	IsActive       bool          `json:"is_active" gorm:"default:true"`
 	IsDefault      bool          `json:"is_default" gorm:"default:false"`
 	OrganizationID *uint         `json:"organization_id,omitempty" gorm:"index:idx_group_name_org,unique;index"`
@@ -70,6 +82,49 @@ func (u *User) GetStatus() UserStatus {
 	return StatusUnknown
 }
 
+// AfterFind keeps the permissions ID list served by /api/groups in sync with the loaded relation
+func (g *Group) AfterFind(tx *gorm.DB) error {
+	g.PermissionIDs = make([]uint, len(g.Permissions))
+	for i, permission := range g.Permissions {
+		g.PermissionIDs[i] = permission.ID
+	}
+	return nil
+}
+
+// Membership returns the user's membership of the organization, or nil. Memberships must be preloaded.
+func (u *User) Membership(organizationID uint) *OrganizationMember {
+	for i := range u.Memberships {
+		if u.Memberships[i].OrganizationID == organizationID {
+			return &u.Memberships[i]
+		}
+	}
+	return nil
+}
+
+// SetActiveOrganization scopes the loaded user to one organization for the current session.
+// Groups of other organizations are dropped so they grant nothing here; global groups stay.
+func (u *User) SetActiveOrganization(org *Organization) {
+	if org == nil {
+		u.OrganizationID = nil
+	} else {
+		id := org.ID
+		u.OrganizationID = &id
+	}
+	u.Organization = org
+	u.Groups = u.GroupsIn(u.OrganizationID)
+}
+
+// GroupsIn returns the user's groups that apply within the organization: its own and global ones
+func (u *User) GroupsIn(organizationID *uint) []Group {
+	groups := make([]Group, 0, len(u.Groups))
+	for _, group := range u.Groups {
+		if group.OrganizationID == nil || (organizationID != nil && *group.OrganizationID == *organizationID) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	u.CreatedAt = time.Now()
 	u.UpdatedAt = time.Now()
@@ -87,27 +142,34 @@ func (u *User) BeforeUpdate(tx *gorm.DB) error {
 type RegisterRequest struct {
 	Name           string `json:"name" binding:"required"`
 	Email          string `json:"email" binding:"required,email"`
-	Password       string `json:"password" binding:"required,min=6"`
+	Password       string `json:"password" binding:"required"` // checked against the password policy
 	OrganizationID *uint  `json:"organization_id,omitempty"`
+	RedirectURL    string `json:"redirect_url,omitempty"` // target of the verification link, must be an allowed origin
 }
 
 // LoginRequest for user authentication
 type LoginRequest struct {
 	Email          string `json:"email" binding:"required,email"`
 	Password       string `json:"password" binding:"required"`
-	OrganizationID *uint  `json:"organization_id,omitempty"`
+	OrganizationID *uint  `json:"organization_id,omitempty"` // organization to sign into, defaults to the user's default
+	ClientIP       string `json:"-"`                         // set by the handler for throttling
 }
 
 // LoginResponse after successful authentication
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token                 string `json:"token"`
+	RefreshToken          string `json:"refresh_token"`
+	ExpiresIn             int    `json:"expires_in"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	// PasswordChangeRequired is set when the password is past the policy's maximum age
+	PasswordChangeRequired bool  `json:"password_change_required,omitempty"`
+	User                   *User `json:"user"`
 }
 
 // ChangePasswordRequest for password updates
 type ChangePasswordRequest struct {
 	OldPassword string `json:"old_password" binding:"required"`
-	NewPassword string `json:"new_password" binding:"required,min=6"`
+	NewPassword string `json:"new_password" binding:"required"`
 }
 
 // ResetPasswordEmailRequest for password reset emails
@@ -120,7 +182,34 @@ type ResetPasswordEmailRequest struct {
 type SetNewPasswordRequest struct {
 	Token    string `json:"token" binding:"required"`
 	UID      string `json:"uid" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyEmailRequest carries the token from a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// ResendVerificationRequest for requesting another verification email
+type ResendVerificationRequest struct {
+	Email       string `json:"email" binding:"required,email"`
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// SwitchOrganizationRequest selects the organization a new token is scoped to
+type SwitchOrganizationRequest struct {
+	OrganizationID uint   `json:"organization_id" binding:"required"`
+	LoginMethod    string `json:"-"` // set by the handler from the current token
+	ClientIP       string `json:"-"`
+}
+
+// UserOrganizationResponse is one of the current user's organizations
+type UserOrganizationResponse struct {
+	ID        uint    `json:"id"`
+	Name      string  `json:"name"`
+	Domain    *string `json:"domain,omitempty"`
+	Role      string  `json:"role"`
+	IsCurrent bool    `json:"is_current"`
 }
 
 // UserUpdateRequest for user profile updates
@@ -152,12 +241,14 @@ type UserResponse struct {
 	IsDeleted      bool          `json:"is_deleted"`
 	IsStaff        bool          `json:"is_staff"`
 	IsAdmin        bool          `json:"is_admin"`
+	IsSuperuser    bool          `json:"is_superuser"`
 	IsActive       bool          `json:"is_active"`
+	MFAEnabled     bool          `json:"mfa_enabled"`
 	OrganizationID *uint         `json:"organization_id,omitempty"`
 	Organization   *Organization `json:"organization,omitempty"`
 	Status         UserStatus    `json:"status"`
 	Groups         []Group       `json:"groups,omitempty"`
-	Permissions    []int         `json:"permissions,omitempty"`
+	Permissions    []uint        `json:"permissions,omitempty"`
 }
 
 // Group DTOs and Requests
@@ -166,11 +257,27 @@ type UserResponse struct {
 type GroupRequest struct {
 	Name        string  `json:"name" binding:"required"`
 	Description *string `json:"description,omitempty"`
-	Permissions []int   `json:"permissions"`
+	Permissions []uint  `json:"permissions"` // permission IDs
 	IsActive    *bool   `json:"is_active,omitempty"`
 	IsDefault   *bool   `json:"is_default,omitempty"`
 }
 
+// GroupCloneRequest for copying a global template group into an organization
+type GroupCloneRequest struct {
+	Name           *string `json:"name,omitempty"`            // defaults to the template's name
+	OrganizationID *uint   `json:"organization_id,omitempty"` // only used by callers outside any organization
+}
+
+// GroupMembersRequest for adding users to or removing them from a group
+type GroupMembersRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required,min=1"`
+}
+
+// UserGroupsRequest replaces every group a user belongs to
+type UserGroupsRequest struct {
+	GroupIDs []uint `json:"group_ids" binding:"required"` // an empty list removes the user from all groups
+}
+
 // PaginatedUserResponse for Swagger documentation
 type PaginatedUserResponse struct {
 	Data       []UserResponse `json:"data"`
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user
+type WebAuthnCredential struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"not null;index"`
+	User            *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Name            string     `json:"name" gorm:"not null"`
+	CredentialID    []byte     `json:"-" gorm:"not null;uniqueIndex"`
+	UserHandle      []byte     `json:"-"` // user.id given to the authenticator, kept when accounts are merged
+	PublicKey       []byte     `json:"-" gorm:"not null"`
+	AttestationType string     `json:"attestation_type"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"sign_count" gorm:"default:0"`
+	Transports      []string   `json:"transports" gorm:"serializer:json"`
+	Flags           uint8      `json:"-"` // raw authenticator flags from registration
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// WebAuthnSession holds the server side state of a registration or login ceremony
+type WebAuthnSession struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    *uint     `json:"user_id,omitempty" gorm:"index"`
+	TokenHash string    `json:"-" gorm:"not null;uniqueIndex"`
+	Ceremony  string    `json:"ceremony" gorm:"not null"`
+	Data      string    `json:"-" gorm:"type:text;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebAuthn ceremonies
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// WebAuthn DTOs and Requests
+
+// WebAuthnBeginResponse carries the options for navigator.credentials.create/get and the session to finish with
+type WebAuthnBeginResponse struct {
+	SessionToken string      `json:"session_token"`
+	Options      interface{} `json:"options"`
+}
+
+// WebAuthnRegisterFinishRequest carries the authenticator's attestation response
+type WebAuthnRegisterFinishRequest struct {
+	SessionToken string          `json:"session_token" binding:"required"`
+	Name         string          `json:"name,omitempty"`
+	Credential   json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// WebAuthnLoginBeginRequest for starting a passkey login; without an email any discoverable passkey is accepted
+type WebAuthnLoginBeginRequest struct {
+	Email string `json:"email,omitempty" binding:"omitempty,email"`
+}
+
+// WebAuthnLoginFinishRequest carries the authenticator's assertion response
+type WebAuthnLoginFinishRequest struct {
+	SessionToken string          `json:"session_token" binding:"required"`
+	Credential   json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+	ClientIP     string          `json:"-"` // set by the handler for the organization's IP allowlist
+}
+
+// WebAuthnCredentialUpdateRequest for renaming a credential
+type WebAuthnCredentialUpdateRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
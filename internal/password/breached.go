@@ -0,0 +1,167 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BreachedSource answers k-anonymity range queries: given the first five hex characters
+// of a SHA-1 hash it returns the remaining 35 characters of every breached hash with
+// that prefix, the same shape as the Have I Been Pwned range API.
+type BreachedSource interface {
+	Range(prefix string) ([]string, error)
+}
+
+var (
+	breachedMu     sync.RWMutex
+	breachedSource BreachedSource
+)
+
+// SetBreachedSource installs the dataset used by the breached password check
+func SetBreachedSource(source BreachedSource) {
+	breachedMu.Lock()
+	defer breachedMu.Unlock()
+	breachedSource = source
+}
+
+// IsBreached reports whether the password appears in the loaded dataset.
+// Without a dataset every password passes.
+func IsBreached(password string) (bool, error) {
+	breachedMu.RLock()
+	source := breachedSource
+	breachedMu.RUnlock()
+
+	if source == nil {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+
+	i := sort.SearchStrings(suffixes, hash[5:])
+	return i < len(suffixes) && suffixes[i] == hash[5:], nil
+}
+
+// FileSource is a breached password dataset file of "SHA1HEX" or "SHA1HEX:COUNT" lines
+// sorted by hash, such as the HIBP ordered-by-hash export. Such files are tens of gigabytes,
+// so rather than loading it each range query binary searches the file on disk.
+type FileSource struct {
+	file *os.File
+	size int64
+}
+
+// LoadBreachedFile opens a dataset file and checks that its first line looks like a hash
+func LoadBreachedFile(path string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	source := &FileSource{file: f, size: info.Size()}
+	if _, _, err := source.lineAt(0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return source, nil
+}
+
+func (s *FileSource) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+
+	// Find the first line whose hash is not below the prefix. The line starting at or after
+	// an offset only moves forward as the offset grows, so the search is monotonic.
+	lo, hi := int64(0), s.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hash, _, err := s.lineAt(mid)
+		if err != nil {
+			return nil, err
+		}
+		if hash == "" || hash >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	var suffixes []string
+	for offset := lo; ; {
+		hash, next, err := s.lineAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			return suffixes, nil
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+		offset = next
+	}
+}
+
+// Close releases the dataset file
+func (s *FileSource) Close() error {
+	return s.file.Close()
+}
+
+// lineAt returns the hash on the first line starting at or after offset, and the offset of
+// the line after it. Blank lines are skipped and the hash is empty at the end of the file.
+func (s *FileSource) lineAt(offset int64) (string, int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(s.file, offset, s.size-offset))
+	position := offset
+
+	if offset > 0 {
+		// offset may fall inside a line: skip to the next one, unless it already starts one
+		var previous [1]byte
+		if _, err := s.file.ReadAt(previous[:], offset-1); err != nil {
+			return "", 0, err
+		}
+		if previous[0] != '\n' {
+			skipped, err := reader.ReadString('\n')
+			position += int64(len(skipped))
+			if err == io.EOF {
+				return "", position, nil
+			}
+			if err != nil {
+				return "", 0, err
+			}
+		}
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		position += int64(len(line))
+		if err != nil && err != io.EOF {
+			return "", 0, err
+		}
+
+		hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if hash != "" {
+			if len(hash) != 40 {
+				return "", 0, errors.New("expected a 40 character SHA-1 hash")
+			}
+			return strings.ToUpper(hash), position, nil
+		}
+		if err == io.EOF {
+			return "", position, nil
+		}
+	}
+}
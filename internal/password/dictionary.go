@@ -0,0 +1,42 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords()
+
+func loadCommonPasswords() map[string]struct{} {
+	words := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words[word] = struct{}{}
+		}
+	}
+	return words
+}
+
+// isCommon reports whether the password is a well known one, also after stripping
+// the digits and symbols people tend to append ("Password123!")
+func isCommon(password string) bool {
+	lower := strings.ToLower(password)
+	if _, ok := commonPasswords[lower]; ok {
+		return true
+	}
+
+	trimmed := strings.TrimRightFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if trimmed == "" {
+		return false
+	}
+	_, ok := commonPasswords[trimmed]
+	return ok
+}
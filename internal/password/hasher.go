@@ -0,0 +1,336 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Hasher creates password hashes with the configured algorithm and verifies hashes in
+// any supported format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2[,keyid=ID]$salt$hash   (PHC string format)
+//	$2a$/$2b$/$2y$ bcrypt                                  (stored as is)
+//	$bcrypt$keyid=ID$2b$...                                (bcrypt of a peppered password)
+//	pbkdf2_sha256$iterations$salt$hash                     (imported from Django, verify only)
+//
+// With a pepper, the password is first run through HMAC-SHA256 keyed with it. The
+// keyid parameter names the pepper a hash was made with, so after a rotation hashes
+// made with a previous pepper still verify and are rehashed with the current one.
+type Hasher struct {
+	algorithm   string
+	bcryptCost  int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	pepperID    string            // id of the pepper new hashes are made with
+	peppers     map[string][]byte // current and previous peppers by id
+}
+
+// NewHasher builds a hasher from the password hashing settings
+func NewHasher(cfg config.PasswordHashConfig) (*Hasher, error) {
+	h := &Hasher{
+		algorithm:   cfg.Algorithm,
+		bcryptCost:  cfg.BcryptCost,
+		memory:      uint32(cfg.Argon2Memory),
+		iterations:  uint32(cfg.Argon2Iterations),
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}
+
+	switch h.algorithm {
+	case AlgorithmArgon2id:
+		if h.memory == 0 || h.iterations == 0 || h.parallelism == 0 {
+			return nil, fmt.Errorf("argon2id memory, iterations and parallelism must be positive")
+		}
+	case AlgorithmBcrypt:
+		if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", h.algorithm)
+	}
+
+	h.peppers = make(map[string][]byte)
+	for _, pepper := range cfg.PreviousPeppers {
+		h.peppers[pepperID(pepper)] = []byte(pepper)
+	}
+	if cfg.Pepper != "" {
+		h.pepperID = pepperID(cfg.Pepper)
+		h.peppers[h.pepperID] = []byte(cfg.Pepper)
+	}
+
+	return h, nil
+}
+
+// pepperID derives the id stored in a hash from the pepper it was made with
+func pepperID(pepper string) string {
+	sum := sha256.Sum256([]byte(pepper))
+	return base64.RawStdEncoding.EncodeToString(sum[:6])
+}
+
+var (
+	hasherMu sync.RWMutex
+	hasher   = mustDefaultHasher()
+)
+
+func mustDefaultHasher() *Hasher {
+	h, err := NewHasher(config.PasswordHashConfig{
+		Algorithm:         AlgorithmArgon2id,
+		BcryptCost:        bcrypt.DefaultCost,
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 2,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// Configure replaces the package hasher used by Hash, Verify and NeedsRehash
+func Configure(cfg *config.Config) error {
+	h, err := NewHasher(cfg.PasswordHash)
+	if err != nil {
+		return err
+	}
+
+	hasherMu.Lock()
+	defer hasherMu.Unlock()
+	hasher = h
+	return nil
+}
+
+func current() *Hasher {
+	hasherMu.RLock()
+	defer hasherMu.RUnlock()
+	return hasher
+}
+
+// Hash hashes a password with the configured hasher
+func Hash(password string) (string, error) {
+	return current().Hash(password)
+}
+
+// Verify reports whether the password matches the encoded hash
+func Verify(password, encoded string) bool {
+	return current().Verify(password, encoded)
+}
+
+// NeedsRehash reports whether the hash should be replaced with one made by the configured hasher
+func NeedsRehash(encoded string) bool {
+	return current().NeedsRehash(encoded)
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	input, _ := h.peppered(password, h.pepperID)
+
+	switch h.algorithm {
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword(input, h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		if h.pepperID != "" {
+			return "$bcrypt$keyid=" + h.pepperID + string(hash), nil
+		}
+		return string(hash), nil
+	default:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey(input, salt, h.iterations, h.memory, h.parallelism, 32)
+
+		params := fmt.Sprintf("m=%d,t=%d,p=%d", h.memory, h.iterations, h.parallelism)
+		if h.pepperID != "" {
+			params += ",keyid=" + h.pepperID
+		}
+		return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+}
+
+func (h *Hasher) Verify(password, encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hash, err := parseArgon2id(encoded)
+		if err != nil {
+			return false
+		}
+		input, ok := h.peppered(password, hash.keyID)
+		if !ok {
+			return false
+		}
+		key := argon2.IDKey(input, hash.salt, hash.iterations, hash.memory, hash.parallelism, uint32(len(hash.key)))
+		return subtle.ConstantTimeCompare(key, hash.key) == 1
+	case strings.HasPrefix(encoded, "$bcrypt$"):
+		keyID, native, ok := parseBcryptPeppered(encoded)
+		if !ok {
+			return false
+		}
+		input, ok := h.peppered(password, keyID)
+		if !ok {
+			return false
+		}
+		return bcrypt.CompareHashAndPassword([]byte(native), input) == nil
+	case isBcrypt(encoded):
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	case strings.HasPrefix(encoded, "pbkdf2_sha256$"):
+		return verifyDjangoPBKDF2(password, encoded)
+	default:
+		return false
+	}
+}
+
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hash, err := parseArgon2id(encoded)
+		return err != nil || h.algorithm != AlgorithmArgon2id || hash.keyID != h.pepperID ||
+			hash.memory != h.memory || hash.iterations != h.iterations || hash.parallelism != h.parallelism
+	case strings.HasPrefix(encoded, "$bcrypt$"):
+		keyID, native, ok := parseBcryptPeppered(encoded)
+		if !ok || h.algorithm != AlgorithmBcrypt || keyID != h.pepperID {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(native))
+		return err != nil || cost != h.bcryptCost
+	case isBcrypt(encoded):
+		if h.algorithm != AlgorithmBcrypt || h.pepperID != "" {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.bcryptCost
+	default:
+		// Imported formats are only ever verified, never written
+		return true
+	}
+}
+
+// peppered returns the bytes fed to the hash function for the given pepper id, and false
+// when the pepper is not configured
+func (h *Hasher) peppered(password, keyID string) ([]byte, bool) {
+	if keyID == "" {
+		return []byte(password), true
+	}
+	pepper, ok := h.peppers[keyID]
+	if !ok {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	// Hex keeps the input printable and well under bcrypt's 72 byte limit
+	return []byte(hex.EncodeToString(mac.Sum(nil))), true
+}
+
+type argon2idHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyID       string
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", params, salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("malformed argon2id hash")
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	hash := &argon2idHash{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "m", "t", "p":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("malformed argon2id parameter %q", param)
+			}
+			switch name {
+			case "m":
+				hash.memory = uint32(n)
+			case "t":
+				hash.iterations = uint32(n)
+			case "p":
+				if n > 255 {
+					return nil, fmt.Errorf("malformed argon2id parameter %q", param)
+				}
+				hash.parallelism = uint8(n)
+			}
+		case "keyid":
+			hash.keyID = value
+		}
+	}
+	if hash.memory == 0 || hash.iterations == 0 || hash.parallelism == 0 {
+		return nil, fmt.Errorf("missing argon2id parameters")
+	}
+
+	var err error
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(hash.key) == 0 {
+		return nil, fmt.Errorf("malformed argon2id hash")
+	}
+	return hash, nil
+}
+
+// parseBcryptPeppered splits "$bcrypt$keyid=ID$2b$..." into the pepper id and the native hash
+func parseBcryptPeppered(encoded string) (keyID, native string, ok bool) {
+	rest := strings.TrimPrefix(encoded, "$bcrypt$")
+	params, _, found := strings.Cut(rest, "$")
+	if !found || !strings.HasPrefix(params, "keyid=") {
+		return "", "", false
+	}
+	return strings.TrimPrefix(params, "keyid="), rest[len(params):], true
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// verifyDjangoPBKDF2 checks a hash from Django's PBKDF2PasswordHasher
+func verifyDjangoPBKDF2(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	key := pbkdf2.Key([]byte(password), []byte(parts[2]), iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
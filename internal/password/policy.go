@@ -0,0 +1,210 @@
+// Package password validates new passwords against a configurable policy
+package password
+
+import (
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Policy is the effective set of rules a new password must satisfy
+type Policy struct {
+	MinLength       int  `json:"min_length"`
+	MaxLength       int  `json:"max_length"`
+	RequireUpper    bool `json:"require_upper"`
+	RequireLower    bool `json:"require_lower"`
+	RequireDigit    bool `json:"require_digit"`
+	RequireSymbol   bool `json:"require_symbol"`
+	CheckDictionary bool `json:"check_dictionary"`
+	CheckContext    bool `json:"check_context"`
+	CheckBreached   bool `json:"check_breached"`
+	// HistorySize is how many previous passwords may not be reused, 0 disables the check
+	HistorySize int `json:"history_size"`
+	// MaxAgeDays forces a change after this many days, 0 disables expiry
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// Context holds account details a password must not contain
+type Context struct {
+	Email            string
+	Name             string
+	OrganizationName string
+}
+
+// ViolationError lists every rule a password failed
+type ViolationError struct {
+	Violations []string
+}
+
+func (e *ViolationError) Error() string {
+	return "password does not meet policy: " + strings.Join(e.Violations, "; ")
+}
+
+// minContextWordLength keeps short name fragments from rejecting unrelated passwords
+const minContextWordLength = 4
+
+// Validate checks a password against the policy. History is checked separately
+// since it needs the stored hashes.
+func Validate(password string, policy Policy, ctx Context) error {
+	var violations []string
+
+	length := len([]rune(password))
+	if policy.MinLength > 0 && length < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters", policy.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if policy.CheckDictionary && isCommon(password) {
+		violations = append(violations, "is too common")
+	}
+
+	if policy.CheckContext {
+		lower := strings.ToLower(password)
+		for _, word := range contextWords(ctx) {
+			if strings.Contains(lower, word) {
+				violations = append(violations, "must not contain your name, email or organization")
+				break
+			}
+		}
+	}
+
+	if policy.CheckBreached {
+		breached, err := IsBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, "has appeared in a data breach")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// contextWords splits the account details into lowercase words worth checking
+func contextWords(ctx Context) []string {
+	var words []string
+	add := func(value string) {
+		for _, word := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(word)) >= minContextWordLength {
+				words = append(words, word)
+			}
+		}
+	}
+
+	local, domain, _ := strings.Cut(ctx.Email, "@")
+	add(local)
+	if label, _, ok := strings.Cut(domain, "."); ok {
+		add(label)
+	}
+	add(ctx.Name)
+	add(ctx.OrganizationName)
+
+	return words
+}
+
+// Override holds per organization changes to the global policy, unset fields keep the global value
+type Override struct {
+	MinLength       *int  `json:"min_length,omitempty"`
+	MaxLength       *int  `json:"max_length,omitempty"`
+	RequireUpper    *bool `json:"require_upper,omitempty"`
+	RequireLower    *bool `json:"require_lower,omitempty"`
+	RequireDigit    *bool `json:"require_digit,omitempty"`
+	RequireSymbol   *bool `json:"require_symbol,omitempty"`
+	CheckDictionary *bool `json:"check_dictionary,omitempty"`
+	CheckContext    *bool `json:"check_context,omitempty"`
+	CheckBreached   *bool `json:"check_breached,omitempty"`
+	HistorySize     *int  `json:"history_size,omitempty"`
+	MaxAgeDays      *int  `json:"max_age_days,omitempty"`
+}
+
+// FromConfig builds the global policy
+func FromConfig(cfg *config.Config) Policy {
+	p := cfg.PasswordPolicy
+	return Policy{
+		MinLength:       p.MinLength,
+		MaxLength:       p.MaxLength,
+		RequireUpper:    p.RequireUpper,
+		RequireLower:    p.RequireLower,
+		RequireDigit:    p.RequireDigit,
+		RequireSymbol:   p.RequireSymbol,
+		CheckDictionary: p.CheckDictionary,
+		CheckContext:    p.CheckContext,
+		CheckBreached:   p.BreachedDataset != "",
+		HistorySize:     p.HistorySize,
+		MaxAgeDays:      p.MaxAgeDays,
+	}
+}
+
+// With returns the policy with an organization's overrides applied
+func (p Policy) With(o *Override) Policy {
+	if o == nil {
+		return p
+	}
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setInt(&p.MinLength, o.MinLength)
+	setInt(&p.MaxLength, o.MaxLength)
+	setBool(&p.RequireUpper, o.RequireUpper)
+	setBool(&p.RequireLower, o.RequireLower)
+	setBool(&p.RequireDigit, o.RequireDigit)
+	setBool(&p.RequireSymbol, o.RequireSymbol)
+	setBool(&p.CheckDictionary, o.CheckDictionary)
+	setBool(&p.CheckContext, o.CheckContext)
+	setBool(&p.CheckBreached, o.CheckBreached)
+	setInt(&p.HistorySize, o.HistorySize)
+	setInt(&p.MaxAgeDays, o.MaxAgeDays)
+	return p
+}
+
+// Expired reports whether a password set at changedAt is past the maximum age.
+// Passwords with no recorded change time never expire.
+func (p Policy) Expired(changedAt *time.Time) bool {
+	if p.MaxAgeDays <= 0 || changedAt == nil {
+		return false
+	}
+	return time.Since(*changedAt) > time.Duration(p.MaxAgeDays)*24*time.Hour
+}
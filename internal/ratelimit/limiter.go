@@ -0,0 +1,172 @@
+// Package ratelimit throttles repeated failed logins per account and per client IP
+package ratelimit
+
+import (
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"strings"
+	"time"
+)
+
+const (
+	// freeAttempts is how many failures are allowed before backoff kicks in
+	freeAttempts = 3
+	backoffBase  = time.Second
+)
+
+// Entry is the recorded failure state for a key
+type Entry struct {
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+// Store persists failure counters. Implementations must make Increment atomic.
+type Store interface {
+	// Get returns the entry for key, or a zero Entry if there is none
+	Get(key string) (Entry, error)
+	// Increment records a failure and returns the updated entry.
+	// Counters whose last failure is older than window restart at one.
+	Increment(key string, window time.Duration) (Entry, error)
+	// Block rejects attempts for key until the given time
+	Block(key string, until time.Time) error
+	// Reset clears key after a successful login or an admin unlock
+	Reset(key string) error
+}
+
+// Policy configures backoff and lockout thresholds
+type Policy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	LockoutDuration    time.Duration
+	Window             time.Duration
+}
+
+// BlockedError is returned while a key is backed off or locked out
+type BlockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %d seconds", int(e.RetryAfter.Seconds()+0.5))
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Logins is the limiter consulted by password authentication
+var Logins = NewLimiter(NewMemoryStore(), Policy{
+	MaxAccountFailures: 10,
+	MaxIPFailures:      100,
+	LockoutDuration:    15 * time.Minute,
+	Window:             15 * time.Minute,
+})
+
+// Configure replaces Logins with a limiter built from the configuration
+func Configure(cfg *config.Config) {
+	var store Store = NewMemoryStore()
+	if cfg.LoginThrottle.Store == "postgres" {
+		store = NewDBStore()
+	}
+
+	Logins = NewLimiter(store, Policy{
+		MaxAccountFailures: cfg.LoginThrottle.MaxAccountFailures,
+		MaxIPFailures:      cfg.LoginThrottle.MaxIPFailures,
+		LockoutDuration:    time.Duration(cfg.LoginThrottle.LockoutDuration) * time.Second,
+		Window:             time.Duration(cfg.LoginThrottle.Window) * time.Second,
+	})
+}
+
+// AccountKey identifies an account the same way login does, by email.
+// Unknown emails are throttled too, so responses do not reveal which accounts exist.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// IPKey identifies a client address
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// EmailLoginKey and EmailLoginIPKey count sign-in emails requested for an address and from a
+// client address. They are kept apart from the login keys so requests never lock out a password.
+func EmailLoginKey(email string) string {
+	return "email-login:" + strings.ToLower(email)
+}
+
+func EmailLoginIPKey(ip string) string {
+	return "email-login-ip:" + ip
+}
+
+// Check returns a *BlockedError if any of the keys is currently blocked
+func (l *Limiter) Check(keys ...string) error {
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		entry, err := l.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if remaining := entry.BlockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	if wait > 0 {
+		return &BlockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// AccountFailure records a failed login for an account key
+func (l *Limiter) AccountFailure(key string) error {
+	return l.failure(key, l.policy.MaxAccountFailures)
+}
+
+// IPFailure records a failed login for a client IP key
+func (l *Limiter) IPFailure(key string) error {
+	if key == "" {
+		return nil
+	}
+	return l.failure(key, l.policy.MaxIPFailures)
+}
+
+// Reset clears the failures recorded for key
+func (l *Limiter) Reset(key string) error {
+	return l.store.Reset(key)
+}
+
+// failure doubles the delay with every failure past the free attempts and locks
+// the key out once maxFailures is reached
+func (l *Limiter) failure(key string, maxFailures int) error {
+	entry, err := l.store.Increment(key, l.policy.Window)
+	if err != nil {
+		return err
+	}
+
+	var delay time.Duration
+	switch {
+	case maxFailures > 0 && entry.Failures >= maxFailures:
+		delay = l.policy.LockoutDuration
+	case entry.Failures > freeAttempts:
+		delay = backoffBase << min(entry.Failures-freeAttempts-1, 16)
+		if delay > l.policy.LockoutDuration {
+			delay = l.policy.LockoutDuration
+		}
+	default:
+		return nil
+	}
+
+	return l.store.Block(key, entry.LastFailureAt.Add(delay))
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DBStore keeps counters in Postgres so every instance sees the same failures
+type DBStore struct{}
+
+func NewDBStore() *DBStore {
+	return &DBStore{}
+}
+
+func (s *DBStore) Get(key string) (Entry, error) {
+	var attempt models.LoginAttempt
+	if err := database.GetDB().Where("key = ?", key).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Entry{}, nil
+		}
+		return Entry{}, err
+	}
+	return toEntry(&attempt), nil
+}
+
+func (s *DBStore) Increment(key string, window time.Duration) (Entry, error) {
+	now := time.Now()
+	db := database.GetDB()
+
+	// Opportunistically drop stale counters
+	if err := db.Where("last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", now.Add(-window), now).
+		Delete(&models.LoginAttempt{}).Error; err != nil {
+		return Entry{}, err
+	}
+
+	var attempt models.LoginAttempt
+	err := db.Raw(`
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING key, failures, last_failure_at, blocked_until`,
+		key, now, now.Add(-window)).Scan(&attempt).Error
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return toEntry(&attempt), nil
+}
+
+func (s *DBStore) Block(key string, until time.Time) error {
+	return database.GetDB().Model(&models.LoginAttempt{}).Where("key = ?", key).Update("blocked_until", until).Error
+}
+
+func (s *DBStore) Reset(key string) error {
+	return database.GetDB().Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func toEntry(attempt *models.LoginAttempt) Entry {
+	entry := Entry{
+		Failures:      attempt.Failures,
+		LastFailureAt: attempt.LastFailureAt,
+	}
+	if attempt.BlockedUntil != nil {
+		entry.BlockedUntil = *attempt.BlockedUntil
+	}
+	return entry
+}
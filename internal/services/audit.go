@@ -0,0 +1,19 @@
+package services
+
+import (
+	"kepler-auth-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// recordAudit writes an audit entry in the same transaction as the change it describes
+func recordAudit(tx *gorm.DB, actorID uint, organizationID *uint, action, targetType string, targetID uint, details map[string]interface{}) error {
+	return tx.Create(&models.AuditLog{
+		ActorID:        actorID,
+		OrganizationID: organizationID,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+		Details:        details,
+	}).Error
+}
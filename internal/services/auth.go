@@ -6,75 +6,191 @@ import (
 	"kepler-auth-go/internal/database"
 	"kepler-auth-go/internal/middleware"
 	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/ratelimit"
+	"kepler-auth-go/internal/tokens"
+	"log"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AuthService struct {
-	cfg *config.Config
+	cfg          *config.Config
+	mfaService   *MFAService
+	emailService *EmailService
 }
 
 func NewAuthService(cfg *config.Config) *AuthService {
-	return &AuthService{cfg: cfg}
+	return &AuthService{
+		cfg:          cfg,
+		mfaService:   NewMFAService(cfg),
+		emailService: NewEmailService(cfg),
+	}
 }
 
 func (s *AuthService) Register(req *models.RegisterRequest) (*models.User, error) {
-	// Check if user with same email exists in the same organization
+	// One account per email, further organizations are joined as memberships
 	var existingUser models.User
-	query := database.GetDB().Where("email = ?", req.Email)
-	if req.OrganizationID != nil {
-		query = query.Where("organization_id = ?", *req.OrganizationID)
-	} else {
-		query = query.Where("organization_id IS NULL")
+	if err := database.GetDB().Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		return nil, errors.New("user with this email already exists")
 	}
 
-	if err := query.First(&existingUser).Error; err == nil {
-		return nil, errors.New("user with this email already exists in this organization")
+	// An organization that verified the email's domain takes in its users without an invitation
+	domainOrg, err := findOrganizationByEmail(database.GetDB(), req.Email)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate organization exists if provided
+	org := domainOrg
 	if req.OrganizationID != nil {
-		var org models.Organization
-		if err := database.GetDB().First(&org, *req.OrganizationID).Error; err != nil {
+		org = &models.Organization{}
+		if err := database.GetDB().Preload("Settings").First(org, *req.OrganizationID).Error; err != nil {
 			return nil, errors.New("organization not found")
 		}
+		if !s.settingsFor(org).AllowSelfRegistration && (domainOrg == nil || domainOrg.ID != org.ID) {
+			return nil, errors.New("organization requires an invitation to join")
+		}
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/verify-email", nil); err != nil {
 		return nil, err
 	}
 
+	var organizationID *uint
+	if org != nil {
+		organizationID = &org.ID
+	}
+
+	now := time.Now()
 	user := &models.User{
-		Email:          req.Email,
-		Name:           req.Name,
-		Password:       string(hashedPassword),
-		OrganizationID: req.OrganizationID,
-		IsActive:       true,
+		Email:             req.Email,
+		Name:              req.Name,
+		OrganizationID:    organizationID,
+		IsActive:          true,
+		PasswordChangedAt: &now,
+	}
+
+	if err := s.checkNewPassword(database.GetDB(), user, org, req.Password); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := password.Hash(req.Password)
+	if err != nil {
+		return nil, err
 	}
+	user.Password = hashedPassword
 
-	if err := database.GetDB().Create(user).Error; err != nil {
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := s.assignDefaultGroups(tx, user); err != nil {
+			return err
+		}
+		if user.OrganizationID != nil {
+			if err := addMembership(tx, *user.OrganizationID, user.ID, models.OrgRoleMember); err != nil {
+				return err
+			}
+		}
+		if domainOrg != nil && org.ID == domainOrg.ID {
+			if err := recordAudit(tx, user.ID, &org.ID, models.AuditOrgDomainJoin, "user", user.ID,
+				map[string]interface{}{"domain": *org.Domain}); err != nil {
+				return err
+			}
+		}
+		return s.recordPassword(tx, user.ID, org, user.Password)
+	})
+	if err != nil {
 		return nil, err
 	}
 
+	// The account exists at this point, a failed email can be retried through resend
+	if err := s.sendVerificationEmail(user, req.RedirectURL); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	return user, nil
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.LoginResponse, error) {
-	var user models.User
-	query := database.GetDB().Preload("Groups").Preload("Organization").Where("email = ?", req.Email)
-
-	// Filter by organization if provided
-	if req.OrganizationID != nil {
-		query = query.Where("organization_id = ?", *req.OrganizationID)
+// assignDefaultGroups adds a new user to the active groups flagged IsDefault in their organization
+func (s *AuthService) assignDefaultGroups(tx *gorm.DB, user *models.User) error {
+	query := tx.Where("is_default = ? AND is_active = ?", true, true)
+	if user.OrganizationID != nil {
+		query = query.Where("organization_id = ?", *user.OrganizationID)
 	} else {
 		query = query.Where("organization_id IS NULL")
 	}
 
-	if err := query.First(&user).Error; err != nil {
+	var groups []models.Group
+	if err := query.Find(&groups).Error; err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	return tx.Model(user).Association("Groups").Append(groups)
+}
+
+func (s *AuthService) Login(req *models.LoginRequest) (*models.LoginResponse, error) {
+	user, err := s.Authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		challenge, err := s.CreateMFAChallenge(user, models.LoginMethodPassword)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{Challenge: challenge}
+	}
+
+	return s.issueSession(database.GetDB(), user, models.LoginMethodPassword)
+}
+
+// Authenticate checks the email and password without issuing any tokens.
+// Failed attempts are throttled per account and per client IP.
+func (s *AuthService) Authenticate(req *models.LoginRequest) (*models.User, error) {
+	accountKey := ratelimit.AccountKey(req.Email)
+	ipKey := ""
+	if req.ClientIP != "" {
+		ipKey = ratelimit.IPKey(req.ClientIP)
+	}
+
+	if err := ratelimit.Logins.Check(accountKey, ipKey); err != nil {
+		return nil, err
+	}
+
+	user, err := s.checkCredentials(req)
+	if err != nil {
+		if err.Error() == "invalid credentials" {
+			if err := ratelimit.Logins.AccountFailure(accountKey); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+			if err := ratelimit.Logins.IPFailure(ipKey); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+		}
+		return nil, err
+	}
+
+	// The IP counter is left alone, otherwise one valid account would let an attacker reset it
+	if err := ratelimit.Logins.Reset(accountKey); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	return user, nil
+}
+
+func (s *AuthService) checkCredentials(req *models.LoginRequest) (*models.User, error) {
+	var user models.User
+	if err := database.GetDB().Preload("Groups.Permissions").Preload("Organization.Settings").
+		Where("email = ?", req.Email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("invalid credentials")
 		}
@@ -85,46 +201,288 @@ func (s *AuthService) Login(req *models.LoginRequest) (*models.LoginResponse, er
 		return nil, errors.New("account is deactivated")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	if !password.Verify(req.Password, user.Password) {
 		return nil, errors.New("invalid credentials")
 	}
 
-	token, err := s.generateToken(&user)
+	if password.NeedsRehash(user.Password) {
+		s.rehashPassword(&user, req.Password)
+	}
+
+	organizationID, err := loginOrganization(database.GetDB(), &user, req.OrganizationID)
 	if err != nil {
 		return nil, err
 	}
+	if err := selectOrganization(database.GetDB(), &user, organizationID); err != nil {
+		return nil, err
+	}
 
-	user.Password = ""
+	if err := s.checkLoginAllowed(&user, models.LoginMethodPassword, req.ClientIP); err != nil {
+		return nil, err
+	}
 
-	return &models.LoginResponse{
-		Token: token,
-		User:  &user,
-	}, nil
+	if !user.IsVerified && s.requiresVerifiedEmail(&user) {
+		return nil, errors.New("email address is not verified")
+	}
+
+	return &user, nil
+}
+
+// rehashPassword upgrades an outdated hash after a successful login. The update only
+// applies if the hash has not changed meanwhile, and a failure never fails the login.
+func (s *AuthService) rehashPassword(user *models.User, plain string) {
+	hashedPassword, err := password.Hash(plain)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+
+	if err := database.GetDB().Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashedPassword).Error; err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = hashedPassword
+}
+
+// Refresh rotates a refresh token and issues a fresh access token.
+// Presenting a token that was already rotated revokes its whole family.
+func (s *AuthService) Refresh(req *models.RefreshTokenRequest) (*models.LoginResponse, error) {
+	var response *models.LoginResponse
+	reused := false
+	leftOrganization := false
+	idle := false
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", tokens.Hash(req.RefreshToken)).
+			First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("invalid refresh token")
+			}
+			return err
+		}
+
+		if current.RevokedAt != nil {
+			reused = true
+			return s.revokeRefreshFamily(tx, current.FamilyID)
+		}
+
+		if current.IsExpired() {
+			return errors.New("refresh token expired")
+		}
+
+		// Reload the user so group permission changes are reflected in the new access token
+		var user models.User
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, current.UserID).Error; err != nil {
+			return errors.New("invalid refresh token")
+		}
+
+		if user.IsDeleted || !user.IsActive {
+			if err := s.revokeRefreshFamily(tx, current.FamilyID); err != nil {
+				return err
+			}
+			return errors.New("account is deactivated")
+		}
+
+		// Stay in the session's organization, which may no longer be the user's default
+		if err := scopeToOrganization(tx, &user, current.OrganizationID); err != nil {
+			if err.Error() != "not a member of this organization" {
+				return err
+			}
+			leftOrganization = true
+			return s.revokeRefreshFamily(tx, current.FamilyID)
+		}
+
+		if err := s.checkLoginAllowed(&user, "", req.ClientIP); err != nil {
+			return err
+		}
+
+		// Every refresh issues a new token, so the current one's age is the time since the last refresh
+		if timeout := s.settingsFor(user.Organization).SessionIdleTimeout; timeout > 0 &&
+			time.Since(current.CreatedAt) > time.Duration(timeout)*time.Second {
+			idle = true
+			return s.revokeRefreshFamily(tx, current.FamilyID)
+		}
+
+		next, raw, err := s.createRefreshToken(tx, &user, current.FamilyID, current.LoginMethod)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&current).Updates(map[string]interface{}{
+			"revoked_at":     now,
+			"replaced_by_id": next.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		response, err = s.buildLoginResponse(&user, raw, current.LoginMethod)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, errors.New("refresh token reuse detected")
+	}
+	if leftOrganization {
+		return nil, errors.New("not a member of this organization")
+	}
+	if idle {
+		return nil, errors.New("session expired due to inactivity")
+	}
+
+	return response, nil
 }
 
 func (s *AuthService) ChangePassword(userID uint, req *models.ChangePasswordRequest) error {
 	var user models.User
-	if err := database.GetDB().First(&user, userID).Error; err != nil {
+	if err := database.GetDB().Preload("Organization.Settings").First(&user, userID).Error; err != nil {
 		return errors.New("user not found")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)); err != nil {
+	if !password.Verify(req.OldPassword, user.Password) {
 		return errors.New("old password is incorrect")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err := s.checkNewPassword(database.GetDB(), &user, user.Organization, req.NewPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := password.Hash(req.NewPassword)
 	if err != nil {
 		return err
 	}
 
-	return database.GetDB().Model(&user).Update("password", string(hashedPassword)).Error
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		return s.setPassword(tx, &user, user.Organization, hashedPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A password change must cut off every session that may have been opened with the old one
+	return tokens.Revocations.RevokeUser(user.ID)
+}
+
+// Logout revokes the presented access token and, if given, the refresh token family it belongs to
+func (s *AuthService) Logout(claims *middleware.Claims, req *models.LogoutRequest) error {
+	if err := s.revokeAccessToken(claims); err != nil {
+		return err
+	}
+
+	if req.RefreshToken == "" {
+		return nil
+	}
+
+	var refreshToken models.RefreshToken
+	if err := database.GetDB().Where("token_hash = ? AND user_id = ?", tokens.Hash(req.RefreshToken), claims.UserID).
+		First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return s.revokeRefreshFamily(database.GetDB(), refreshToken.FamilyID)
 }
 
-func (s *AuthService) generateToken(user *models.User) (string, error) {
+// LogoutAll revokes every access and refresh token issued to the user
+func (s *AuthService) LogoutAll(claims *middleware.Claims) error {
+	if err := tokens.Revocations.RevokeUser(claims.UserID); err != nil {
+		return err
+	}
+
+	// Tokens issued within the same second as the cutoff survive it, so revoke the caller's explicitly
+	return s.revokeAccessToken(claims)
+}
+
+func (s *AuthService) revokeAccessToken(claims *middleware.Claims) error {
+	expiresAt := time.Now().Add(time.Duration(s.cfg.JWT.Expiration) * time.Second)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return tokens.Revocations.Revoke(claims.ID, expiresAt)
+}
+
+// issueSession starts a new refresh token family for the user and returns the login payload.
+// The login method is kept with the session so it can be checked again on organization switch.
+func (s *AuthService) issueSession(tx *gorm.DB, user *models.User, loginMethod string) (*models.LoginResponse, error) {
+	familyID, err := tokens.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	_, raw, err := s.createRefreshToken(tx, user, familyID, loginMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.buildLoginResponse(user, raw, loginMethod)
+}
+
+func (s *AuthService) buildLoginResponse(user *models.User, refreshToken, loginMethod string) (*models.LoginResponse, error) {
+	// Groups of the user's other organizations grant nothing in this session
+	user.Groups = user.GroupsIn(user.OrganizationID)
+
+	token, err := s.generateToken(user, loginMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	effective := s.settingsFor(user.Organization)
+
+	return &models.LoginResponse{
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              effective.AccessTokenLifetime,
+		MFAEnrollmentRequired:  effective.RequireMFA && !user.MFAEnabled,
+		PasswordChangeRequired: effective.PasswordPolicy.Expired(user.PasswordChangedAt),
+		User:                   user,
+	}, nil
+}
+
+func (s *AuthService) createRefreshToken(tx *gorm.DB, user *models.User, familyID, loginMethod string) (*models.RefreshToken, string, error) {
+	raw, hash, err := tokens.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	refreshToken := &models.RefreshToken{
+		UserID:         user.ID,
+		FamilyID:       familyID,
+		TokenHash:      hash,
+		ExpiresAt:      time.Now().Add(time.Duration(s.settingsFor(user.Organization).RefreshTokenLifetime) * time.Second),
+		OrganizationID: user.OrganizationID,
+		LoginMethod:    loginMethod,
+	}
+
+	if err := tx.Create(refreshToken).Error; err != nil {
+		return nil, "", err
+	}
+
+	return refreshToken, raw, nil
+}
+
+func (s *AuthService) revokeRefreshFamily(tx *gorm.DB, familyID string) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *AuthService) generateToken(user *models.User, loginMethod string) (string, error) {
 	// Collect all permissions from user's groups
 	permissions := make([]int, 0)
 	for _, group := range user.Groups {
-		permissions = append(permissions, group.Permissions...)
+		for _, id := range group.PermissionIDs {
+			permissions = append(permissions, int(id))
+		}
 	}
 
 	// Remove duplicates
@@ -137,6 +495,11 @@ func (s *AuthService) generateToken(user *models.User) (string, error) {
 		}
 	}
 
+	jti, err := tokens.NewID()
+	if err != nil {
+		return "", err
+	}
+
 	claims := &middleware.Claims{
 		UserID:         user.ID,
 		Email:          user.Email,
@@ -144,12 +507,13 @@ func (s *AuthService) generateToken(user *models.User) (string, error) {
 		Permissions:    uniquePermissions,
 		IsAdmin:        user.IsAdmin,
 		IsStaff:        user.IsStaff,
+		LoginMethod:    loginMethod,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(s.cfg.JWT.Expiration) * time.Second)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(s.settingsFor(user.Organization).AccessTokenLifetime) * time.Second)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.cfg.JWT.Secret))
+	return tokens.GetKeyManager().Sign(claims)
 }
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// domainChallengeLabel is prepended to the domain to name the TXT record checked for ownership
+	domainChallengeLabel = "_kepler-auth-challenge"
+	// domainChallengePrefix starts the expected TXT record value, followed by the organization's token
+	domainChallengePrefix = "kepler-auth-verification="
+	domainLookupTimeout   = 5 * time.Second
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var (
+	resolverMu  sync.RWMutex
+	txtResolver TXTResolver = net.DefaultResolver
+)
+
+// SetTXTResolver replaces the resolver used for domain verification, e.g. with a stub in tests
+func SetTXTResolver(resolver TXTResolver) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	txtResolver = resolver
+}
+
+func currentTXTResolver() TXTResolver {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	return txtResolver
+}
+
+// GetDomainVerification returns the TXT record that proves ownership of the organization's domain,
+// creating the challenge token on first use
+func (s *OrganizationService) GetDomainVerification(id uint, organizationID *uint) (*models.DomainVerificationResponse, error) {
+	organization, err := findScopedOrganization(database.GetDB(), id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if organization.Domain == nil || *organization.Domain == "" {
+		return nil, errors.New("organization has no domain")
+	}
+
+	if organization.DomainVerificationToken == "" {
+		token, err := tokens.NewID()
+		if err != nil {
+			return nil, err
+		}
+		// Only set if still empty, so concurrent callers end up with the same token
+		if err := database.GetDB().Model(&models.Organization{}).
+			Where("id = ? AND (domain_verification_token IS NULL OR domain_verification_token = '')", id).
+			Update("domain_verification_token", token).Error; err != nil {
+			return nil, err
+		}
+		if err := database.GetDB().First(organization, id).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return toDomainVerificationResponse(organization), nil
+}
+
+// VerifyDomain checks the domain's TXT challenge record and, when it matches, marks the domain as
+// verified. A domain can only be verified by one organization at a time.
+func (s *OrganizationService) VerifyDomain(id uint, actor *models.User, organizationID *uint) (*models.DomainVerificationResponse, error) {
+	organization, err := findScopedOrganization(database.GetDB(), id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if organization.Domain == nil || *organization.Domain == "" {
+		return nil, errors.New("organization has no domain")
+	}
+	if organization.DomainVerificationToken == "" {
+		return nil, errors.New("domain verification has not been started")
+	}
+	if organization.DomainVerifiedAt != nil {
+		return toDomainVerificationResponse(organization), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), domainLookupTimeout)
+	defer cancel()
+
+	records, err := currentTXTResolver().LookupTXT(ctx, domainChallengeLabel+"."+*organization.Domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, errors.New("domain verification lookup failed")
+		}
+	}
+
+	expected := domainChallengePrefix + organization.DomainVerificationToken
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("domain verification record not found")
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var existing models.Organization
+		if err := tx.Where("LOWER(domain) = ? AND domain_verified_at IS NOT NULL AND id != ?", strings.ToLower(*organization.Domain), id).
+			First(&existing).Error; err == nil {
+			return errors.New("domain is already verified by another organization")
+		}
+
+		now := time.Now()
+		// The domain is matched again so a concurrent domain change is not marked verified
+		result := tx.Model(&models.Organization{}).
+			Where("id = ? AND domain = ? AND domain_verification_token = ?", id, *organization.Domain, organization.DomainVerificationToken).
+			Update("domain_verified_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("domain changed during verification")
+		}
+		organization.DomainVerifiedAt = &now
+
+		return recordAudit(tx, actor.ID, &id, models.AuditOrgDomainVerify, "organization", id,
+			map[string]interface{}{"domain": *organization.Domain})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainVerificationResponse(organization), nil
+}
+
+// DiscoverOrganization finds the organization that verified the email's domain, so clients can
+// send users to the right organization, or its SAML login, from their email alone
+func (s *AuthService) DiscoverOrganization(req *models.DomainDiscoveryRequest) (*models.DomainDiscoveryResponse, error) {
+	org, err := findOrganizationByEmail(database.GetDB(), req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("no organization found for this email domain")
+	}
+
+	response := &models.DomainDiscoveryResponse{
+		OrganizationID:   org.ID,
+		OrganizationName: org.Name,
+	}
+
+	// Organizations with an active SAML connection sign their users in at the IdP
+	if _, err := findActiveSAMLConnection(database.GetDB(), org.ID); err == nil {
+		ssoURL := samlBaseURL(s.cfg, org.ID) + "/login"
+		response.SSOURL = &ssoURL
+	}
+
+	return response, nil
+}
+
+// findOrganizationByEmail returns the organization that verified the email's domain, or nil
+func findOrganizationByEmail(tx *gorm.DB, email string) (*models.Organization, error) {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok || domain == "" {
+		return nil, nil
+	}
+
+	var org models.Organization
+	if err := tx.Preload("Settings").Where("LOWER(domain) = ? AND domain_verified_at IS NOT NULL", strings.ToLower(domain)).
+		First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// loginOrganization picks the organization a login signs into: the requested one, otherwise the
+// organization owning the email's verified domain if the user is a member, otherwise nil for the
+// user's default
+func loginOrganization(tx *gorm.DB, user *models.User, requested *uint) (*uint, error) {
+	if requested != nil {
+		return requested, nil
+	}
+
+	org, err := findOrganizationByEmail(tx, user.Email)
+	if err != nil || org == nil {
+		return nil, err
+	}
+
+	var member models.OrganizationMember
+	if err := tx.Where("organization_id = ? AND user_id = ?", org.ID, user.ID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org.ID, nil
+}
+
+// emailHasDomain reports whether the email address is at the domain
+func emailHasDomain(email, domain string) bool {
+	_, emailDomain, ok := strings.Cut(email, "@")
+	return ok && strings.EqualFold(emailDomain, domain)
+}
+
+// normalizeDomain lowercases a domain and strips surrounding whitespace and a trailing dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+func toDomainVerificationResponse(org *models.Organization) *models.DomainVerificationResponse {
+	response := &models.DomainVerificationResponse{
+		Domain:      *org.Domain,
+		RecordName:  domainChallengeLabel + "." + *org.Domain,
+		RecordValue: domainChallengePrefix + org.DomainVerificationToken,
+		Verified:    org.DomainVerifiedAt != nil,
+	}
+	if org.DomainVerifiedAt != nil {
+		verifiedAt := org.DomainVerifiedAt.Format(time.RFC3339)
+		response.VerifiedAt = &verifiedAt
+	}
+	return response
+}
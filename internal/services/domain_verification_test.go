@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"net"
+	"testing"
+)
+
+// stubResolver answers TXT lookups from a fixed table instead of DNS
+type stubResolver struct {
+	records map[string][]string
+	err     error
+	queries []string
+}
+
+func (r *stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.queries = append(r.queries, name)
+	if r.err != nil {
+		return nil, r.err
+	}
+	records, ok := r.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func useStubResolver(t *testing.T) *stubResolver {
+	t.Helper()
+
+	resolver := &stubResolver{records: map[string][]string{}}
+	SetTXTResolver(resolver)
+	t.Cleanup(func() { SetTXTResolver(net.DefaultResolver) })
+	return resolver
+}
+
+func createDomainOrganization(t *testing.T, name, domain string) *models.Organization {
+	t.Helper()
+
+	org := &models.Organization{Name: name, Domain: &domain}
+	if err := database.GetDB().Create(org).Error; err != nil {
+		t.Fatalf("failed to create organization: %v", err)
+	}
+	return org
+}
+
+// verifyTestDomain publishes the organization's challenge record and verifies the domain
+func verifyTestDomain(t *testing.T, resolver *stubResolver, org *models.Organization, actor *models.User) {
+	t.Helper()
+
+	s := NewOrganizationService()
+	challenge, err := s.GetDomainVerification(org.ID, nil)
+	if err != nil {
+		t.Fatalf("GetDomainVerification: %v", err)
+	}
+	resolver.records[challenge.RecordName] = []string{challenge.RecordValue}
+
+	if _, err := s.VerifyDomain(org.ID, actor, nil); err != nil {
+		t.Fatalf("VerifyDomain: %v", err)
+	}
+}
+
+func TestVerifyDomainChecksTheChallengeRecord(t *testing.T) {
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+	s := NewOrganizationService()
+
+	org := createDomainOrganization(t, "Acme", "acme.test")
+	actor := createTestUser(t, "owner@acme.test", org)
+
+	if _, err := s.VerifyDomain(org.ID, actor, nil); err == nil || err.Error() != "domain verification has not been started" {
+		t.Fatalf("expected verification to need a challenge first, got %v", err)
+	}
+
+	challenge, err := s.GetDomainVerification(org.ID, nil)
+	if err != nil {
+		t.Fatalf("GetDomainVerification: %v", err)
+	}
+	if challenge.RecordName != "_kepler-auth-challenge.acme.test" || challenge.Verified {
+		t.Fatalf("unexpected challenge %+v", challenge)
+	}
+
+	again, err := s.GetDomainVerification(org.ID, nil)
+	if err != nil || again.RecordValue != challenge.RecordValue {
+		t.Fatalf("challenge changed between calls: %+v, %v", again, err)
+	}
+
+	cases := []struct {
+		name    string
+		records []string
+		err     error
+		want    string
+	}{
+		{"missing record", nil, nil, "domain verification record not found"},
+		{"other value", []string{"kepler-auth-verification=someone-else", "v=spf1 -all"}, nil, "domain verification record not found"},
+		{"lookup failure", nil, &net.DNSError{Err: "server misbehaving", IsTemporary: true}, "domain verification lookup failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver.records = map[string][]string{}
+			if tc.records != nil {
+				resolver.records[challenge.RecordName] = tc.records
+			}
+			resolver.err = tc.err
+
+			_, err := s.VerifyDomain(org.ID, actor, nil)
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("expected %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	resolver.err = nil
+	resolver.records = map[string][]string{challenge.RecordName: {"v=spf1 -all", " " + challenge.RecordValue + " "}}
+	verified, err := s.VerifyDomain(org.ID, actor, nil)
+	if err != nil {
+		t.Fatalf("VerifyDomain: %v", err)
+	}
+	if !verified.Verified || verified.VerifiedAt == nil {
+		t.Fatalf("domain not verified: %+v", verified)
+	}
+	if last := resolver.queries[len(resolver.queries)-1]; last != challenge.RecordName {
+		t.Errorf("looked up %q, want %q", last, challenge.RecordName)
+	}
+
+	var audits int64
+	database.GetDB().Model(&models.AuditLog{}).Where("action = ?", models.AuditOrgDomainVerify).Count(&audits)
+	if audits != 1 {
+		t.Errorf("expected one domain verification audit entry, got %d", audits)
+	}
+}
+
+func TestDomainIsVerifiedByOneOrganizationOnly(t *testing.T) {
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+
+	first := createDomainOrganization(t, "Acme", "acme.test")
+	second := createDomainOrganization(t, "Acme Impostor", "ACME.test")
+	actor := createTestUser(t, "owner@acme.test", first, second)
+
+	verifyTestDomain(t, resolver, first, actor)
+
+	s := NewOrganizationService()
+	challenge, err := s.GetDomainVerification(second.ID, nil)
+	if err != nil {
+		t.Fatalf("GetDomainVerification: %v", err)
+	}
+	resolver.records[challenge.RecordName] = append(resolver.records[challenge.RecordName], challenge.RecordValue)
+
+	_, err = s.VerifyDomain(second.ID, actor, nil)
+	if err == nil || err.Error() != "domain is already verified by another organization" {
+		t.Fatalf("expected the second organization to be refused, got %v", err)
+	}
+}
+
+func TestRegistrationJoinsTheOrganizationOfAVerifiedDomain(t *testing.T) {
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+	s := NewAuthService(testConfig(t))
+
+	verified := createDomainOrganization(t, "Acme", "acme.test")
+	unverified := createDomainOrganization(t, "Globex", "globex.test")
+	verifyTestDomain(t, resolver, verified, createTestUser(t, "owner@elsewhere.test"))
+
+	register := func(email string) *models.User {
+		t.Helper()
+		user, err := s.Register(&models.RegisterRequest{Name: "New User", Email: email, Password: "Vt4#qPz8!mWx2rLk"})
+		if err != nil {
+			t.Fatalf("Register %s: %v", email, err)
+		}
+		return user
+	}
+
+	joined := register("new@ACME.test")
+	if joined.OrganizationID == nil || *joined.OrganizationID != verified.ID {
+		t.Fatalf("user joined organization %v, want %d", joined.OrganizationID, verified.ID)
+	}
+	var member models.OrganizationMember
+	if err := database.GetDB().Where("organization_id = ? AND user_id = ?", verified.ID, joined.ID).First(&member).Error; err != nil {
+		t.Fatalf("no membership created: %v", err)
+	}
+	if member.Role != models.OrgRoleMember {
+		t.Errorf("joined with role %q", member.Role)
+	}
+
+	// A domain that was only claimed, not verified, takes in nobody
+	if outsider := register("new@globex.test"); outsider.OrganizationID != nil {
+		t.Errorf("user joined organization %d through an unverified domain", *outsider.OrganizationID)
+	}
+
+	// Organizations requiring invitations still take in users of their verified domain
+	_, err := s.Register(&models.RegisterRequest{Name: "Other", Email: "other@acme.test", Password: "Vt4#qPz8!mWx2rLk", OrganizationID: &verified.ID})
+	if err != nil {
+		t.Fatalf("Register into the domain's organization: %v", err)
+	}
+	_, err = s.Register(&models.RegisterRequest{Name: "Other", Email: "other@globex.test", Password: "Vt4#qPz8!mWx2rLk", OrganizationID: &unverified.ID})
+	if err == nil || err.Error() != "organization requires an invitation to join" {
+		t.Fatalf("expected the invitation requirement, got %v", err)
+	}
+}
+
+func TestLoginFindsTheOrganizationFromTheEmail(t *testing.T) {
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+	s := NewAuthService(testConfig(t))
+
+	home := createTestOrganization(t, "Home")
+	acme := createDomainOrganization(t, "Acme", "acme.test")
+	verifyTestDomain(t, resolver, acme, createTestUser(t, "owner@elsewhere.test"))
+
+	user, err := s.Register(&models.RegisterRequest{Name: "Member", Email: "member@acme.test", Password: "Vt4#qPz8!mWx2rLk"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	// Their default is another organization, the email's domain still picks Acme once they
+	// have confirmed the address
+	if err := addMembership(database.GetDB(), home.ID, user.ID, models.OrgRoleMember); err != nil {
+		t.Fatalf("addMembership: %v", err)
+	}
+	if err := database.GetDB().Model(user).Updates(map[string]interface{}{"organization_id": home.ID, "is_verified": true}).Error; err != nil {
+		t.Fatalf("failed to change default organization: %v", err)
+	}
+
+	signedIn, err := s.Authenticate(&models.LoginRequest{Email: "member@acme.test", Password: "Vt4#qPz8!mWx2rLk"})
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if signedIn.OrganizationID == nil || *signedIn.OrganizationID != acme.ID {
+		t.Errorf("signed into organization %v, want %d", signedIn.OrganizationID, acme.ID)
+	}
+
+	discovered, err := s.DiscoverOrganization(&models.DomainDiscoveryRequest{Email: "anyone@Acme.test"})
+	if err != nil {
+		t.Fatalf("DiscoverOrganization: %v", err)
+	}
+	if discovered.OrganizationID != acme.ID || discovered.SSOURL != nil {
+		t.Errorf("unexpected discovery %+v", discovered)
+	}
+
+	_, err = s.DiscoverOrganization(&models.DomainDiscoveryRequest{Email: "anyone@unknown.test"})
+	if err == nil || err.Error() != "no organization found for this email domain" {
+		t.Fatalf("expected no organization for an unknown domain, got %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"kepler-auth-go/internal/config"
 	"kepler-auth-go/internal/models"
 	"net/smtp"
+	"net/url"
+	"strings"
 )
 
 type EmailService struct {
@@ -36,3 +39,41 @@ func (s *EmailService) SendEmail(req *models.EmailRequest) error {
 
 	return err
 }
+
+// BuildLink returns the link to put in an email. The caller's redirect URL is used when its
+// origin is allowed, otherwise defaultPath on the frontend URL.
+func (s *EmailService) BuildLink(redirectURL, defaultPath string, params url.Values) (string, error) {
+	if redirectURL == "" {
+		redirectURL = strings.TrimRight(s.cfg.Frontend.URL, "/") + defaultPath
+	} else if !s.isAllowedRedirect(redirectURL) {
+		return "", errors.New("redirect_url is not allowed")
+	}
+
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", errors.New("invalid redirect_url")
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func (s *EmailService) isAllowedRedirect(redirectURL string) bool {
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range s.cfg.Frontend.AllowedRedirectOrigins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
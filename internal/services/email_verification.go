@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const emailVerificationPurpose = "email_verification"
+
+// emailVerificationClaims binds a verification link to the address it was sent to,
+// so changing the email invalidates links sent to the old one
+type emailVerificationClaims struct {
+	UserID uint   `json:"uid"`
+	Email  string `json:"email"`
+}
+
+// VerifyEmail marks the user's email as verified using a signed verification token
+func (s *AuthService) VerifyEmail(req *models.VerifyEmailRequest) error {
+	var claims emailVerificationClaims
+	if err := tokens.Verify(s.cfg.Security.EncryptionKey, emailVerificationPurpose, req.Token, &claims); err != nil {
+		if errors.Is(err, tokens.ErrTokenExpired) {
+			return errors.New("verification link has expired")
+		}
+		return errors.New("invalid verification link")
+	}
+
+	var user models.User
+	if err := database.GetDB().First(&user, claims.UserID).Error; err != nil {
+		return errors.New("invalid verification link")
+	}
+
+	if user.Email != claims.Email {
+		return errors.New("invalid verification link")
+	}
+
+	if user.IsVerified {
+		return nil
+	}
+
+	return database.GetDB().Model(&user).Update("is_verified", true).Error
+}
+
+// ResendVerification sends a new verification link. Like password reset it responds the
+// same way for unknown, already verified and throttled accounts.
+func (s *AuthService) ResendVerification(req *models.ResendVerificationRequest) error {
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/verify-email", nil); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.GetDB().Where("email = ? AND is_deleted = ?", req.Email, false).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if user.IsVerified {
+		return nil
+	}
+
+	return s.sendVerificationEmail(&user, req.RedirectURL)
+}
+
+// sendVerificationEmail emails a signed verification link unless one was sent within the resend interval
+func (s *AuthService) sendVerificationEmail(user *models.User, redirectURL string) error {
+	interval := time.Duration(s.cfg.Security.EmailVerificationResendInterval) * time.Second
+	cutoff := time.Now().Add(-interval)
+
+	// Claim the send slot atomically so parallel requests cannot bypass the throttle
+	result := database.GetDB().Model(&models.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", user.ID, cutoff).
+		Update("verification_sent_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	expiration := time.Duration(s.cfg.Security.EmailVerificationExpiration) * time.Second
+	token, err := tokens.Sign(s.cfg.Security.EncryptionKey, emailVerificationPurpose, emailVerificationClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+	}, expiration)
+	if err != nil {
+		return err
+	}
+
+	link, err := s.emailService.BuildLink(redirectURL, "/verify-email", url.Values{"token": {token}})
+	if err != nil {
+		return err
+	}
+
+	email := &models.EmailRequest{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+			user.Name, s.cfg.Security.EmailVerificationExpiration/3600, link),
+	}
+
+	go func() {
+		if err := s.emailService.SendEmail(email); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// requiresVerifiedEmail reports whether the settings in effect for the user block login until the
+// email is verified. Emails at an organization's verified domain always need verifying, since
+// registering with one joins the organization.
+func (s *AuthService) requiresVerifiedEmail(user *models.User) bool {
+	org := user.Organization
+	if org != nil && org.HasVerifiedDomain() && emailHasDomain(user.Email, *org.Domain) {
+		return true
+	}
+	return s.settingsFor(org).RequireEmailVerification
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/encryption"
+	"kepler-auth-go/internal/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type FederationService struct {
+	cfg          *config.Config
+	authService  *AuthService
+	emailService *EmailService
+}
+
+func NewFederationService(cfg *config.Config) *FederationService {
+	return &FederationService{
+		cfg:          cfg,
+		authService:  NewAuthService(cfg),
+		emailService: NewEmailService(cfg),
+	}
+}
+
+// GetProviders lists the identity providers of the caller's organization, or every provider
+// for superusers
+func (s *FederationService) GetProviders(organizationID *uint) ([]models.IdentityProviderResponse, error) {
+	db := database.GetDB()
+	if organizationID != nil {
+		db = db.Where("organization_id = ?", *organizationID)
+	}
+
+	var providers []models.IdentityProvider
+	if err := db.Order("id").Find(&providers).Error; err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.IdentityProviderResponse, 0, len(providers))
+	for i := range providers {
+		responses = append(responses, s.toProviderResponse(&providers[i]))
+	}
+	return responses, nil
+}
+
+func (s *FederationService) GetProvider(id uint, organizationID *uint) (*models.IdentityProviderResponse, error) {
+	provider, err := findIdentityProvider(database.GetDB(), id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.toProviderResponse(provider)
+	return &response, nil
+}
+
+// CreateProvider registers an identity provider after reading its discovery document. Providers
+// created by organization owners belong to their organization; superusers may create global ones.
+func (s *FederationService) CreateProvider(req *models.IdentityProviderRequest, actor *models.User, organizationID *uint) (*models.IdentityProviderResponse, error) {
+	if organizationID != nil {
+		req.OrganizationID = organizationID
+	}
+
+	if req.OrganizationID != nil {
+		var org models.Organization
+		if err := database.GetDB().First(&org, *req.OrganizationID).Error; err != nil {
+			return nil, errors.New("organization not found")
+		}
+	}
+
+	provider := &models.IdentityProvider{
+		Name:           req.Name,
+		OrganizationID: req.OrganizationID,
+		Issuer:         strings.TrimSpace(req.Issuer),
+		ClientID:       req.ClientID,
+		Scopes:         req.Scopes,
+		AutoProvision:  req.AutoProvision,
+		IsActive:       true,
+	}
+
+	allowedDomains, err := normalizeAllowedDomains(req.AllowedDomains)
+	if err != nil {
+		return nil, err
+	}
+	provider.AllowedDomains = allowedDomains
+
+	if err := checkIdentityProvider(provider); err != nil {
+		return nil, err
+	}
+
+	if req.ClientSecret != "" {
+		if provider.ClientSecret, err = s.encrypt(req.ClientSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(provider).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor.ID, provider.OrganizationID, models.AuditIdentityProviderCreate, "identity_provider", provider.ID,
+			map[string]interface{}{"name": provider.Name, "issuer": provider.Issuer, "auto_provision": provider.AutoProvision})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.toProviderResponse(provider)
+	return &response, nil
+}
+
+func (s *FederationService) UpdateProvider(id uint, req *models.IdentityProviderUpdateRequest, actor *models.User, organizationID *uint) (*models.IdentityProviderResponse, error) {
+	provider, err := findIdentityProvider(database.GetDB(), id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		provider.Name = *req.Name
+	}
+	if req.Issuer != nil {
+		provider.Issuer = strings.TrimSpace(*req.Issuer)
+	}
+	if req.ClientID != nil {
+		provider.ClientID = *req.ClientID
+	}
+	if req.Scopes != nil {
+		provider.Scopes = req.Scopes
+	}
+	if req.AllowedDomains != nil {
+		if provider.AllowedDomains, err = normalizeAllowedDomains(*req.AllowedDomains); err != nil {
+			return nil, err
+		}
+	}
+	if req.AutoProvision != nil {
+		provider.AutoProvision = *req.AutoProvision
+	}
+	if req.IsActive != nil {
+		provider.IsActive = *req.IsActive
+	}
+
+	if err := checkIdentityProvider(provider); err != nil {
+		return nil, err
+	}
+
+	if req.ClientSecret != nil {
+		provider.ClientSecret = ""
+		if *req.ClientSecret != "" {
+			if provider.ClientSecret, err = s.encrypt(*req.ClientSecret); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(provider).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor.ID, provider.OrganizationID, models.AuditIdentityProviderUpdate, "identity_provider", provider.ID,
+			map[string]interface{}{
+				"issuer":         provider.Issuer,
+				"auto_provision": provider.AutoProvision,
+				"is_active":      provider.IsActive,
+				"secret_changed": req.ClientSecret != nil,
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.toProviderResponse(provider)
+	return &response, nil
+}
+
+// DeleteProvider removes an identity provider and the identities linked through it. Users it
+// provisioned keep their accounts and sign in another way, e.g. after a password reset.
+func (s *FederationService) DeleteProvider(id uint, actor *models.User, organizationID *uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		provider, err := findIdentityProvider(tx, id, organizationID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("provider_id = ?", provider.ID).Delete(&models.FederationLogin{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("provider_id = ?", provider.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(provider).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, provider.OrganizationID, models.AuditIdentityProviderDelete, "identity_provider", provider.ID,
+			map[string]interface{}{"name": provider.Name, "issuer": provider.Issuer})
+	})
+}
+
+// GetLoginProviders lists the active providers offered on the login page: the global ones and,
+// when an organization is given, that organization's own
+func (s *FederationService) GetLoginProviders(organizationID *uint) ([]models.FederationProviderResponse, error) {
+	db := database.GetDB().Where("is_active = ?", true)
+	if organizationID != nil {
+		db = db.Where("organization_id IS NULL OR organization_id = ?", *organizationID)
+	} else {
+		db = db.Where("organization_id IS NULL")
+	}
+
+	var providers []models.IdentityProvider
+	if err := db.Order("id").Find(&providers).Error; err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.FederationProviderResponse, 0, len(providers))
+	for _, provider := range providers {
+		responses = append(responses, models.FederationProviderResponse{
+			ID:             provider.ID,
+			Name:           provider.Name,
+			OrganizationID: provider.OrganizationID,
+			LoginURL:       federationBaseURL(s.cfg, provider.ID) + "/login",
+		})
+	}
+	return responses, nil
+}
+
+// findIdentityProvider loads a provider, treating one outside the caller's organization as missing
+func findIdentityProvider(tx *gorm.DB, id uint, organizationID *uint) (*models.IdentityProvider, error) {
+	if organizationID != nil {
+		tx = tx.Where("organization_id = ?", *organizationID)
+	}
+
+	var provider models.IdentityProvider
+	if err := tx.First(&provider, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("identity provider not found")
+		}
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// findActiveIdentityProvider loads a provider users may sign in through
+func findActiveIdentityProvider(tx *gorm.DB, id uint) (*models.IdentityProvider, error) {
+	provider, err := findIdentityProvider(tx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !provider.IsActive {
+		return nil, errors.New("identity provider not found")
+	}
+	return provider, nil
+}
+
+// checkIdentityProvider validates the issuer and reads its discovery document, so a
+// misconfigured provider is caught when it is saved rather than at the first login
+func checkIdentityProvider(provider *models.IdentityProvider) error {
+	if provider.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	if err := checkIssuerURL(provider.Issuer); err != nil {
+		return err
+	}
+	_, err := discoverProvider(provider.Issuer)
+	return err
+}
+
+func normalizeAllowedDomains(domains []string) ([]string, error) {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		value := normalizeDomain(domain)
+		if value == "" || strings.ContainsAny(value, "@/ ") {
+			return nil, fmt.Errorf("invalid allowed domain %q", domain)
+		}
+		normalized = append(normalized, value)
+	}
+	return normalized, nil
+}
+
+// federationBaseURL is the base of a provider's login endpoints
+func federationBaseURL(cfg *config.Config, providerID uint) string {
+	return fmt.Sprintf("%s/api/federation/%d", strings.TrimSuffix(cfg.OIDC.Issuer, "/"), providerID)
+}
+
+func (s *FederationService) encrypt(value string) (string, error) {
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Encrypt(value)
+}
+
+func (s *FederationService) decrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Decrypt(value)
+}
+
+func (s *FederationService) toProviderResponse(provider *models.IdentityProvider) models.IdentityProviderResponse {
+	base := federationBaseURL(s.cfg, provider.ID)
+	return models.IdentityProviderResponse{
+		IdentityProvider: *provider,
+		HasClientSecret:  provider.ClientSecret != "",
+		RedirectURI:      base + "/callback",
+		LoginURL:         base + "/login",
+	}
+}
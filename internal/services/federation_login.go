@@ -0,0 +1,545 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	federationRequestTTL = 10 * time.Minute
+	federationCodeTTL    = time.Minute
+)
+
+var errInvalidFederationLogin = errors.New("invalid or expired identity provider login")
+
+// BeginLogin starts a login through the identity provider and returns the provider URL to send
+// the browser to. organizationID picks the organization to sign into through a global provider.
+func (s *FederationService) BeginLogin(id uint, redirectURL string, organizationID *uint) (string, error) {
+	if _, err := s.emailService.BuildLink(redirectURL, "/federation/callback", nil); err != nil {
+		return "", err
+	}
+
+	provider, err := findActiveIdentityProvider(database.GetDB(), id)
+	if err != nil {
+		return "", err
+	}
+
+	return s.beginFederation(provider, &models.FederationLogin{
+		Purpose:        models.FederationPurposeLogin,
+		OrganizationID: organizationID,
+		RedirectURL:    redirectURL,
+	})
+}
+
+// BeginLink starts linking an account at the identity provider to the current user. The browser
+// returns to the redirect URL once the provider confirmed the account.
+func (s *FederationService) BeginLink(userID uint, req *models.IdentityLinkRequest) (*models.IdentityLinkResponse, error) {
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/account/identities", nil); err != nil {
+		return nil, err
+	}
+
+	provider, err := findActiveIdentityProvider(database.GetDB(), req.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := s.beginFederation(provider, &models.FederationLogin{
+		UserID:      &userID,
+		Purpose:     models.FederationPurposeLink,
+		RedirectURL: req.RedirectURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.IdentityLinkResponse{AuthorizationURL: location}, nil
+}
+
+// beginFederation stores the state, nonce and PKCE verifier of an authorization request and
+// returns the provider's authorization URL
+func (s *FederationService) beginFederation(provider *models.IdentityProvider, login *models.FederationLogin) (string, error) {
+	config, err := discoverProvider(provider.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, hash, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := tokens.NewID()
+	if err != nil {
+		return "", err
+	}
+	verifier, _, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	login.ProviderID = provider.ID
+	login.Stage = models.FederationStageRequest
+	login.TokenHash = hash
+	login.Nonce = nonce
+	login.CodeVerifier = verifier
+	login.ExpiresAt = time.Now().Add(federationRequestTTL)
+	if err := database.GetDB().Create(login).Error; err != nil {
+		return "", err
+	}
+
+	return upstreamAuthorizationURL(config, provider, federationBaseURL(s.cfg, provider.ID)+"/callback", state, nonce, verifier)
+}
+
+// Callback completes the authorization request the provider redirected back from. The ID token
+// is validated against the provider's JWKS before the identity is trusted. Logins return the
+// frontend URL carrying a single-use login code; links return the frontend URL directly.
+func (s *FederationService) Callback(id uint, query url.Values) (string, error) {
+	state := query.Get("state")
+	if state == "" {
+		return "", errInvalidFederationLogin
+	}
+
+	provider, err := findActiveIdentityProvider(database.GetDB(), id)
+	if err != nil {
+		return "", err
+	}
+
+	var login models.FederationLogin
+	if err := database.GetDB().Where("token_hash = ? AND provider_id = ? AND stage = ?", tokens.Hash(state), provider.ID, models.FederationStageRequest).
+		First(&login).Error; err != nil {
+		return "", errInvalidFederationLogin
+	}
+	if time.Now().After(login.ExpiresAt) {
+		return "", errInvalidFederationLogin
+	}
+
+	// The user declined or the provider failed, the request cannot be resumed
+	if providerErr := query.Get("error"); providerErr != "" {
+		if err := database.GetDB().Delete(&login).Error; err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("identity provider returned an error: %s", providerErr)
+	}
+
+	identity, err := s.verifyCallback(provider, &login, query.Get("code"))
+	if err != nil {
+		log.Printf("Rejected identity provider callback for provider %d: %v", provider.ID, err)
+		return "", err
+	}
+
+	var redirect string
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Lock the request so a replayed callback cannot use it twice
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND stage = ?", login.ID, models.FederationStageRequest).
+			First(&login).Error; err != nil {
+			return errInvalidFederationLogin
+		}
+
+		if login.Purpose == models.FederationPurposeLink {
+			if err := s.linkIdentity(tx, provider, &login, identity); err != nil {
+				return err
+			}
+			if err := tx.Delete(&login).Error; err != nil {
+				return err
+			}
+			redirect, err = s.emailService.BuildLink(login.RedirectURL, "/account/identities",
+				url.Values{"linked": {strconv.FormatUint(uint64(provider.ID), 10)}})
+			return err
+		}
+
+		user, organizationID, err := s.resolveUser(tx, provider, &login, identity)
+		if err != nil {
+			return err
+		}
+
+		code, hash, err := tokens.Generate()
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&login).Updates(map[string]interface{}{
+			"user_id":         user.ID,
+			"organization_id": organizationID,
+			"token_hash":      hash,
+			"stage":           models.FederationStageCode,
+			"expires_at":      time.Now().Add(federationCodeTTL),
+		}).Error; err != nil {
+			return err
+		}
+
+		redirect, err = s.emailService.BuildLink(login.RedirectURL, "/federation/callback", url.Values{"code": {code}})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return redirect, nil
+}
+
+// ExchangeCode trades the login code from Callback for the same tokens as Login. Accounts with
+// MFA still complete the TOTP challenge, as the provider may not enforce a second factor.
+func (s *FederationService) ExchangeCode(req *models.FederationExchangeRequest) (*models.LoginResponse, error) {
+	db := database.GetDB()
+
+	var login models.FederationLogin
+	if err := db.Preload("Provider").
+		Where("token_hash = ? AND stage = ?", tokens.Hash(req.Code), models.FederationStageCode).
+		First(&login).Error; err != nil {
+		return nil, errInvalidFederationLogin
+	}
+	// The code is consumed on its own before the checks below, so it stays used when one of them
+	// fails. Only the request whose delete removed the row may go on.
+	result := db.Where("id = ? AND stage = ?", login.ID, models.FederationStageCode).Delete(&models.FederationLogin{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInvalidFederationLogin
+	}
+	if time.Now().After(login.ExpiresAt) || login.UserID == nil ||
+		login.Provider == nil || !login.Provider.IsActive {
+		return nil, errInvalidFederationLogin
+	}
+
+	var user models.User
+	if err := db.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, *login.UserID).Error; err != nil {
+		return nil, errInvalidFederationLogin
+	}
+	if user.IsDeleted || !user.IsActive {
+		return nil, errors.New("account is deactivated")
+	}
+
+	if err := selectOrganization(db, &user, login.OrganizationID); err != nil {
+		return nil, err
+	}
+	if err := s.authService.checkLoginAllowed(&user, models.LoginMethodOIDC, req.ClientIP); err != nil {
+		return nil, err
+	}
+	if !user.IsVerified && s.authService.requiresVerifiedEmail(&user) {
+		return nil, errors.New("email address is not verified")
+	}
+
+	if user.MFAEnabled {
+		challenge, err := s.authService.CreateMFAChallenge(&user, models.LoginMethodOIDC)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{Challenge: challenge}
+	}
+
+	return s.authService.issueSession(db, &user, models.LoginMethodOIDC)
+}
+
+// GetIdentities lists the provider accounts linked to the user
+func (s *FederationService) GetIdentities(userID uint) ([]models.UserIdentity, error) {
+	identities := make([]models.UserIdentity, 0)
+	if err := database.GetDB().Preload("Provider").Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity removes a linked provider account. The last one cannot be removed from an
+// account without a password or passkey, which would leave it no way to sign in.
+func (s *FederationService) UnlinkIdentity(userID, id uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		if err := tx.Preload("Provider").Where("id = ? AND user_id = ?", id, userID).First(&identity).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("identity not found")
+			}
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		if user.Password == "" {
+			var others, passkeys int64
+			if err := tx.Model(&models.UserIdentity{}).Where("user_id = ? AND id <> ?", userID, id).Count(&others).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&passkeys).Error; err != nil {
+				return err
+			}
+			if others == 0 && passkeys == 0 {
+				return errors.New("set a password before unlinking your last sign-in method")
+			}
+		}
+
+		if err := tx.Delete(&identity).Error; err != nil {
+			return err
+		}
+
+		var organizationID *uint
+		if identity.Provider != nil {
+			organizationID = identity.Provider.OrganizationID
+		}
+		return recordAudit(tx, userID, organizationID, models.AuditIdentityUnlink, "user", userID,
+			map[string]interface{}{"provider_id": identity.ProviderID, "email": identity.Email})
+	})
+}
+
+// verifyCallback redeems the authorization code and returns the identity from the verified ID token
+func (s *FederationService) verifyCallback(provider *models.IdentityProvider, login *models.FederationLogin, code string) (*upstreamIdentity, error) {
+	if code == "" {
+		return nil, errInvalidFederationLogin
+	}
+
+	config, err := discoverProvider(provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	clientSecret, err := s.decrypt(provider.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := exchangeUpstreamCode(config, provider, clientSecret, code, federationBaseURL(s.cfg, provider.ID)+"/callback", login.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyUpstreamIDToken(config, provider, response.IDToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := readUpstreamIdentity(config, claims, response.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Email != "" {
+		if address, err := mail.ParseAddress(identity.Email); err != nil || address.Address != identity.Email {
+			return nil, errors.New("identity provider returned an invalid email address")
+		}
+	}
+	if len(provider.AllowedDomains) > 0 && !slices.ContainsFunc(provider.AllowedDomains, func(domain string) bool {
+		return emailHasDomain(identity.Email, domain)
+	}) {
+		return nil, errors.New("email domain is not allowed for this identity provider")
+	}
+
+	return identity, nil
+}
+
+// resolveUser finds the user a verified identity signs in, provisioning one when the provider
+// allows it, and returns the organization to sign into. An existing account is only matched by
+// email when the provider's organization verified the email's domain; otherwise the user has to
+// link the provider from their profile, so a provider cannot claim accounts by email alone.
+func (s *FederationService) resolveUser(tx *gorm.DB, provider *models.IdentityProvider, login *models.FederationLogin, identity *upstreamIdentity) (*models.User, *uint, error) {
+	var org *models.Organization
+	if provider.OrganizationID != nil {
+		org = &models.Organization{}
+		if err := tx.First(org, *provider.OrganizationID).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	// The provider's organization vouches for verified addresses at its own verified domain
+	domainEmail := org != nil && identity.EmailVerified && org.HasVerifiedDomain() && emailHasDomain(identity.Email, *org.Domain)
+
+	var user models.User
+	var link models.UserIdentity
+	err := tx.Where("provider_id = ? AND subject = ?", provider.ID, identity.Subject).First(&link).Error
+	switch {
+	case err == nil:
+		if err := tx.First(&user, link.UserID).Error; err != nil {
+			return nil, nil, err
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil, err
+	case identity.Email == "":
+		return nil, nil, errors.New("identity provider returned no email address")
+	default:
+		err := tx.Where("LOWER(email) = ?", strings.ToLower(identity.Email)).First(&user).Error
+		switch {
+		case err == nil:
+			if !domainEmail {
+				return nil, nil, errors.New("an account with this email already exists, sign in and link the identity provider from your profile")
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, nil, err
+		case !provider.AutoProvision:
+			return nil, nil, errors.New("no account is linked to this identity")
+		default:
+			if err := s.provisionUser(tx, provider, org, identity, &user); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		link = models.UserIdentity{ProviderID: provider.ID, UserID: user.ID, Subject: identity.Subject}
+		if err := tx.Create(&link).Error; err != nil {
+			return nil, nil, err
+		}
+		if err := recordAudit(tx, user.ID, provider.OrganizationID, models.AuditIdentityLink, "user", user.ID,
+			map[string]interface{}{"provider_id": provider.ID, "email": identity.Email}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if user.IsSuperuser {
+		return nil, nil, errors.New("superusers cannot sign in with an external identity provider")
+	}
+	if user.IsDeleted || !user.IsActive {
+		return nil, nil, errors.New("account is deactivated")
+	}
+
+	if err := s.touchIdentity(tx, &link, &user, identity); err != nil {
+		return nil, nil, err
+	}
+
+	// Organization providers sign into their organization, global ones follow the usual rules
+	if org == nil {
+		organizationID, err := loginOrganization(tx, &user, login.OrganizationID)
+		return &user, organizationID, err
+	}
+
+	var member models.OrganizationMember
+	if err := tx.Where("organization_id = ? AND user_id = ?", org.ID, user.ID).First(&member).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		if !domainEmail {
+			return nil, nil, errors.New("not a member of this organization")
+		}
+		if err := addMembership(tx, org.ID, user.ID, models.OrgRoleMember); err != nil {
+			return nil, nil, err
+		}
+		if err := recordAudit(tx, user.ID, &org.ID, models.AuditOrgDomainJoin, "user", user.ID,
+			map[string]interface{}{"domain": *org.Domain, "provider_id": provider.ID}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &user, &org.ID, nil
+}
+
+// provisionUser creates an account for an identity nobody has linked yet (JIT provisioning). It
+// joins the provider's organization, or for global providers the organization that verified the
+// email's domain, like Register.
+func (s *FederationService) provisionUser(tx *gorm.DB, provider *models.IdentityProvider, org *models.Organization, identity *upstreamIdentity, user *models.User) error {
+	if org == nil && identity.EmailVerified {
+		domainOrg, err := findOrganizationByEmail(tx, identity.Email)
+		if err != nil {
+			return err
+		}
+		org = domainOrg
+	}
+
+	name := identity.Name
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	// No password is set, the account signs in through the provider until one is reset
+	*user = models.User{
+		Email:      identity.Email,
+		Name:       name,
+		IsActive:   true,
+		IsVerified: identity.EmailVerified,
+	}
+	if org != nil {
+		user.OrganizationID = &org.ID
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := s.authService.assignDefaultGroups(tx, user); err != nil {
+		return err
+	}
+	if org != nil {
+		if err := addMembership(tx, org.ID, user.ID, models.OrgRoleMember); err != nil {
+			return err
+		}
+	}
+
+	return recordAudit(tx, user.ID, user.OrganizationID, models.AuditIdentityProvision, "user", user.ID,
+		map[string]interface{}{"provider_id": provider.ID, "email": identity.Email})
+}
+
+// linkIdentity attaches the verified identity to the user who started the link
+func (s *FederationService) linkIdentity(tx *gorm.DB, provider *models.IdentityProvider, login *models.FederationLogin, identity *upstreamIdentity) error {
+	if login.UserID == nil {
+		return errInvalidFederationLogin
+	}
+
+	var user models.User
+	if err := tx.First(&user, *login.UserID).Error; err != nil {
+		return errInvalidFederationLogin
+	}
+	if user.IsSuperuser {
+		return errors.New("superusers cannot sign in with an external identity provider")
+	}
+	if user.IsDeleted || !user.IsActive {
+		return errors.New("account is deactivated")
+	}
+
+	if provider.OrganizationID != nil {
+		var member models.OrganizationMember
+		if err := tx.Where("organization_id = ? AND user_id = ?", *provider.OrganizationID, user.ID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("not a member of this organization")
+			}
+			return err
+		}
+	}
+
+	var link models.UserIdentity
+	err := tx.Where("provider_id = ? AND (subject = ? OR user_id = ?)", provider.ID, identity.Subject, user.ID).First(&link).Error
+	switch {
+	case err == nil:
+		if link.UserID != user.ID {
+			return errors.New("this identity is already linked to another account")
+		}
+		if link.Subject != identity.Subject {
+			return errors.New("another account at this identity provider is already linked")
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	default:
+		link = models.UserIdentity{ProviderID: provider.ID, UserID: user.ID, Subject: identity.Subject}
+		if err := tx.Create(&link).Error; err != nil {
+			return err
+		}
+		if err := recordAudit(tx, user.ID, provider.OrganizationID, models.AuditIdentityLink, "user", user.ID,
+			map[string]interface{}{"provider_id": provider.ID, "email": identity.Email}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&link).Update("email", identity.Email).Error
+}
+
+// touchIdentity records the login on the linked identity. An address the provider verified
+// counts as verified when it is the account's own.
+func (s *FederationService) touchIdentity(tx *gorm.DB, link *models.UserIdentity, user *models.User, identity *upstreamIdentity) error {
+	if err := tx.Model(link).Updates(map[string]interface{}{
+		"email":         identity.Email,
+		"last_login_at": time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	if user.IsVerified || !identity.EmailVerified || !strings.EqualFold(identity.Email, user.Email) {
+		return nil
+	}
+	user.IsVerified = true
+	return tx.Model(user).Update("is_verified", true).Error
+}
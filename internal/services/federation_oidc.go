@@ -0,0 +1,391 @@
+package services
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	upstreamDiscoveryTTL = time.Hour
+	// upstreamJWKSRefresh is the least time between JWKS fetches triggered by an unknown key ID
+	upstreamJWKSRefresh = time.Minute
+	upstreamHTTPTimeout = 10 * time.Second
+	upstreamMaxResponse = 1 << 20
+	upstreamClockSkew   = time.Minute
+)
+
+// upstreamSigningMethods are the ID token algorithms accepted from identity providers
+var upstreamSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var upstreamHTTPClient = &http.Client{Timeout: upstreamHTTPTimeout}
+
+// upstreamProvider caches an identity provider's discovery document and signing keys
+type upstreamProvider struct {
+	config        *models.OpenIDConfiguration
+	fetchedAt     time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+var upstreamCache = struct {
+	sync.Mutex
+	providers map[string]*upstreamProvider
+}{providers: make(map[string]*upstreamProvider)}
+
+// upstreamTokens is the token endpoint response of an identity provider
+type upstreamTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// upstreamClaims are the ID token claims read from identity providers
+type upstreamClaims struct {
+	Email           string          `json:"email"`
+	EmailVerified   json.RawMessage `json:"email_verified"` // a boolean, or a string at some providers
+	Name            string          `json:"name"`
+	Nonce           string          `json:"nonce"`
+	AuthorizedParty string          `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// upstreamIdentity is what an identity provider says about the user
+type upstreamIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// checkIssuerURL requires an https issuer, plain http is only accepted for local test servers
+func checkIssuerURL(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("invalid issuer url")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+	}
+	return errors.New("issuer must use https")
+}
+
+// discoverProvider returns the provider's discovery document, fetched at most once an hour
+func discoverProvider(issuer string) (*models.OpenIDConfiguration, error) {
+	upstreamCache.Lock()
+	cached := upstreamCache.providers[issuer]
+	upstreamCache.Unlock()
+	if cached != nil && time.Since(cached.fetchedAt) < upstreamDiscoveryTTL {
+		return cached.config, nil
+	}
+
+	var config models.OpenIDConfiguration
+	if err := fetchUpstreamJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, fmt.Errorf("failed to read discovery document: %w", err)
+	}
+
+	// The issuer must match exactly, or tokens from another issuer could be accepted (OIDC Discovery 4.3)
+	if config.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", config.Issuer, issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	upstreamCache.Lock()
+	defer upstreamCache.Unlock()
+	provider := upstreamCache.providers[issuer]
+	if provider == nil || provider.config.JWKSURI != config.JWKSURI {
+		provider = &upstreamProvider{}
+		upstreamCache.providers[issuer] = provider
+	}
+	provider.config = &config
+	provider.fetchedAt = time.Now()
+
+	return &config, nil
+}
+
+// upstreamKey finds the provider's signing key by key ID, refetching the JWKS when the key is
+// unknown so rotated keys are picked up
+func upstreamKey(issuer, jwksURI, kid string) (crypto.PublicKey, error) {
+	upstreamCache.Lock()
+	provider := upstreamCache.providers[issuer]
+	if provider == nil {
+		provider = &upstreamProvider{}
+		upstreamCache.providers[issuer] = provider
+	}
+	key, found := lookupUpstreamKey(provider.keys, kid)
+	stale := time.Since(provider.keysFetchedAt) >= upstreamJWKSRefresh
+	upstreamCache.Unlock()
+
+	if found {
+		return key, nil
+	}
+	if !stale {
+		return nil, errors.New("unknown signing key")
+	}
+
+	var set tokens.JWKS
+	if err := fetchUpstreamJSON(jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = public
+	}
+
+	upstreamCache.Lock()
+	provider.keys = keys
+	provider.keysFetchedAt = time.Now()
+	upstreamCache.Unlock()
+
+	if key, found := lookupUpstreamKey(keys, kid); found {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// lookupUpstreamKey matches a key ID, a token without one only matches a set of a single key
+func lookupUpstreamKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// upstreamAuthorizationURL builds the authorization code request with PKCE (S256)
+func upstreamAuthorizationURL(config *models.OpenIDConfiguration, provider *models.IdentityProvider, redirectURI, state, nonce, verifier string) (string, error) {
+	endpoint, err := url.Parse(config.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.New("invalid authorization endpoint")
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(identityProviderScopes(provider), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+// exchangeUpstreamCode redeems an authorization code at the provider's token endpoint.
+// Confidential clients authenticate with client_secret_basic unless the provider only
+// supports client_secret_post.
+func exchangeUpstreamCode(config *models.OpenIDConfiguration, provider *models.IdentityProvider, clientSecret, code, redirectURI, verifier string) (*upstreamTokens, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	basic := clientSecret != "" && (len(config.TokenEndpointAuthMethodsSupported) == 0 ||
+		slices.Contains(config.TokenEndpointAuthMethodsSupported, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", provider.ClientID)
+		if clientSecret != "" {
+			form.Set("client_secret", clientSecret)
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if basic {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(clientSecret))
+	}
+
+	var response upstreamTokens
+	if err := doUpstreamRequest(request, &response); err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
+	}
+	if response.IDToken == "" {
+		return nil, errors.New("identity provider returned no id token")
+	}
+	return &response, nil
+}
+
+// verifyUpstreamIDToken checks the ID token signature against the provider's JWKS and validates
+// the issuer, audience, expiry and nonce (OIDC Core 3.1.3.7)
+func verifyUpstreamIDToken(config *models.OpenIDConfiguration, provider *models.IdentityProvider, raw, nonce string) (*upstreamClaims, error) {
+	var claims upstreamClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return upstreamKey(config.Issuer, config.JWKSURI, kid)
+	},
+		jwt.WithValidMethods(upstreamSigningMethods),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(upstreamClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id token: missing subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != provider.ClientID {
+		return nil, errors.New("invalid id token: unexpected authorized party")
+	}
+
+	return &claims, nil
+}
+
+// readUpstreamIdentity takes the user's details from the ID token, asking the userinfo endpoint
+// when the token carries no email address
+func readUpstreamIdentity(config *models.OpenIDConfiguration, claims *upstreamClaims, accessToken string) (*upstreamIdentity, error) {
+	identity := &upstreamIdentity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claimIsTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+
+	if identity.Email == "" && config.UserinfoEndpoint != "" && accessToken != "" {
+		request, err := http.NewRequest(http.MethodGet, config.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+		request.Header.Set("Accept", "application/json")
+
+		var info struct {
+			Subject       string          `json:"sub"`
+			Email         string          `json:"email"`
+			EmailVerified json.RawMessage `json:"email_verified"`
+			Name          string          `json:"name"`
+		}
+		if err := doUpstreamRequest(request, &info); err != nil {
+			return nil, fmt.Errorf("userinfo request failed: %w", err)
+		}
+		// The userinfo response must be about the user the ID token is for (OIDC Core 5.3.2)
+		if info.Subject != claims.Subject {
+			return nil, errors.New("userinfo subject does not match the id token")
+		}
+
+		identity.Email = info.Email
+		identity.EmailVerified = claimIsTrue(info.EmailVerified)
+		if identity.Name == "" {
+			identity.Name = info.Name
+		}
+	}
+
+	identity.Email = strings.TrimSpace(identity.Email)
+	identity.Name = strings.TrimSpace(identity.Name)
+	return identity, nil
+}
+
+// claimIsTrue reads a boolean claim that some providers send as a string
+func claimIsTrue(raw json.RawMessage) bool {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// identityProviderScopes returns the scopes to request, always including openid
+func identityProviderScopes(provider *models.IdentityProvider) []string {
+	if len(provider.Scopes) == 0 {
+		return []string{"openid", "email", "profile"}
+	}
+	if slices.Contains(provider.Scopes, "openid") {
+		return provider.Scopes
+	}
+	return append([]string{"openid"}, provider.Scopes...)
+}
+
+func fetchUpstreamJSON(location string, v interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	return doUpstreamRequest(request, v)
+}
+
+// doUpstreamRequest sends a request to an identity provider and decodes the JSON response.
+// OAuth error responses are reported by their error code.
+func doUpstreamRequest(request *http.Request, v interface{}) error {
+	response, err := upstreamHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, upstreamMaxResponse))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var oauthErr models.OAuthError
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.New("invalid json response")
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an OpenID Connect provider on a local test server. It signs every user in as
+// the identity it is given, issuing ID tokens for the authorization requests it saw.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	identity map[string]interface{} // claims of the user signing in, besides the protocol claims
+	userinfo map[string]interface{} // served at the userinfo endpoint
+	nonce    string
+	verifier string // PKCE challenge of the pending authorization
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate provider key: %v", err)
+	}
+	p := &mockProvider{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.OpenIDConfiguration{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			UserinfoEndpoint:      p.server.URL + "/userinfo",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := tokens.NewJWK("mock-key", "RS256", &p.key.PublicKey)
+		if err != nil {
+			t.Errorf("failed to build jwk: %v", err)
+		}
+		json.NewEncoder(w).Encode(tokens.JWKS{Keys: []tokens.JWK{jwk}})
+	})
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mock-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		json.NewEncoder(w).Encode(p.userinfo)
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// token redeems the code of the pending authorization after checking the PKCE verifier
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != "mock-code" || base64.RawURLEncoding.EncodeToString(challenge[:]) != p.verifier {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   "mock-client",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": p.nonce,
+	}
+	for name, value := range p.identity {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-key"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		p.t.Errorf("failed to sign id token: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// authorize signs the identity in at the provider for the authorization URL and returns the
+// callback query the browser brings back
+func (p *mockProvider) authorize(authorizationURL string, identity map[string]interface{}) url.Values {
+	p.t.Helper()
+
+	location, err := url.Parse(authorizationURL)
+	if err != nil || !strings.HasPrefix(authorizationURL, p.server.URL+"/authorize") {
+		p.t.Fatalf("unexpected authorization url %q", authorizationURL)
+	}
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "mock-client" {
+		p.t.Fatalf("unexpected authorization request %v", query)
+	}
+
+	p.mu.Lock()
+	p.identity = identity
+	p.nonce = query.Get("nonce")
+	p.verifier = query.Get("code_challenge")
+	p.mu.Unlock()
+
+	return url.Values{"state": {query.Get("state")}, "code": {"mock-code"}}
+}
+
+// setupFederationTenant registers the mock provider for an organization that verified acme.test
+func setupFederationTenant(t *testing.T) (*FederationService, *mockProvider, *models.Organization, uint) {
+	t.Helper()
+
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+	s := NewFederationService(testConfig(t))
+
+	org := createDomainOrganization(t, "Acme", "acme.test")
+	owner := createTestUser(t, "owner@acme.test", org)
+	verifyTestDomain(t, resolver, org, owner)
+
+	provider := newMockProvider(t)
+	created, err := s.CreateProvider(&models.IdentityProviderRequest{
+		Name:          "Mock",
+		Issuer:        provider.server.URL,
+		ClientID:      "mock-client",
+		ClientSecret:  "mock-secret",
+		AutoProvision: true,
+	}, owner, &org.ID)
+	if err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	return s, provider, org, created.ID
+}
+
+// loginCode runs a provider login up to the login code handed to the frontend
+func loginCode(t *testing.T, s *FederationService, provider *mockProvider, providerID uint, identity map[string]interface{}) string {
+	t.Helper()
+
+	authorizationURL, err := s.BeginLogin(providerID, "", nil)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	redirect, err := s.Callback(providerID, provider.authorize(authorizationURL, identity))
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	location, err := url.Parse(redirect)
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("expected a redirect carrying a login code, got %q", redirect)
+	}
+	return location.Query().Get("code")
+}
+
+func TestFederationLoginWithAMockProvider(t *testing.T) {
+	s, provider, org, providerID := setupFederationTenant(t)
+
+	code := loginCode(t, s, provider, providerID, map[string]interface{}{
+		"sub":            "alice-subject",
+		"email":          "alice@acme.test",
+		"email_verified": true,
+		"name":           "Alice",
+	})
+	response, err := s.ExchangeCode(&models.FederationExchangeRequest{Code: code})
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	if response.User.Email != "alice@acme.test" || !response.User.IsVerified {
+		t.Errorf("provisioned %+v", response.User)
+	}
+	if response.User.OrganizationID == nil || *response.User.OrganizationID != org.ID {
+		t.Errorf("signed into organization %v, want %d", response.User.OrganizationID, org.ID)
+	}
+
+	var identity models.UserIdentity
+	if err := database.GetDB().Where("provider_id = ? AND subject = ?", providerID, "alice-subject").First(&identity).Error; err != nil {
+		t.Fatalf("no identity linked: %v", err)
+	}
+	if identity.UserID != response.User.ID {
+		t.Errorf("identity linked to user %d, want %d", identity.UserID, response.User.ID)
+	}
+
+	if _, err := s.ExchangeCode(&models.FederationExchangeRequest{Code: code}); err != errInvalidFederationLogin {
+		t.Errorf("expected the login code to be refused the second time, got %v", err)
+	}
+}
+
+func TestFederationLoginReadsTheEmailFromUserinfo(t *testing.T) {
+	s, provider, _, providerID := setupFederationTenant(t)
+
+	provider.userinfo = map[string]interface{}{"sub": "someone-else", "email": "bob@acme.test", "email_verified": true}
+	authorizationURL, err := s.BeginLogin(providerID, "", nil)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	_, err = s.Callback(providerID, provider.authorize(authorizationURL, map[string]interface{}{"sub": "bob-subject"}))
+	if err == nil || err.Error() != "userinfo subject does not match the id token" {
+		t.Fatalf("expected userinfo about another subject to be refused, got %v", err)
+	}
+
+	provider.userinfo["sub"] = "bob-subject"
+	code := loginCode(t, s, provider, providerID, map[string]interface{}{"sub": "bob-subject"})
+	response, err := s.ExchangeCode(&models.FederationExchangeRequest{Code: code})
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	if response.User.Email != "bob@acme.test" {
+		t.Errorf("signed in %q, want bob@acme.test", response.User.Email)
+	}
+}
+
+func TestFederationLoginCodeIsConsumedWhenACheckFails(t *testing.T) {
+	s, provider, org, providerID := setupFederationTenant(t)
+
+	code := loginCode(t, s, provider, providerID, map[string]interface{}{
+		"sub":            "carol-subject",
+		"email":          "carol@acme.test",
+		"email_verified": true,
+	})
+
+	settings := models.OrganizationSettings{OrganizationID: org.ID, AllowedLoginMethods: []string{models.LoginMethodPassword}}
+	if err := database.GetDB().Create(&settings).Error; err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	if _, err := s.ExchangeCode(&models.FederationExchangeRequest{Code: code}); err == nil || err.Error() != "login method is not allowed by your organization" {
+		t.Fatalf("expected the organization to refuse the login method, got %v", err)
+	}
+
+	// Allowing the method again does not bring the refused code back
+	if err := database.GetDB().Model(&settings).Update("allowed_login_methods", nil).Error; err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+	if _, err := s.ExchangeCode(&models.FederationExchangeRequest{Code: code}); err != errInvalidFederationLogin {
+		t.Errorf("expected the used login code to be refused, got %v", err)
+	}
+}
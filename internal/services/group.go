@@ -9,17 +9,29 @@ import (
 	"gorm.io/gorm"
 )
 
+// GroupService manages groups within the caller's organization. Groups without an
+// organization are global templates: organization members can list and clone them
+// but only callers outside any organization can change them.
 type GroupService struct{}
 
 func NewGroupService() *GroupService {
 	return &GroupService{}
 }
 
-func (s *GroupService) GetGroups(query *models.PaginationQuery) (*models.PaginatedGroupResponse, error) {
+func (s *GroupService) GetGroups(query *models.PaginationQuery, organizationID *uint) (*models.PaginatedGroupResponse, error) {
+	return s.listGroups(scopeGroups(database.GetDB().Model(&models.Group{}), organizationID), query)
+}
+
+// GetTemplates lists the global groups organizations can clone
+func (s *GroupService) GetTemplates(query *models.PaginationQuery) (*models.PaginatedGroupResponse, error) {
+	return s.listGroups(database.GetDB().Model(&models.Group{}).Where("organization_id IS NULL"), query)
+}
+
+func (s *GroupService) listGroups(db *gorm.DB, query *models.PaginationQuery) (*models.PaginatedGroupResponse, error) {
 	var groups []models.Group
 	var total int64
 
-	db := database.GetDB().Model(&models.Group{})
+	db = db.Preload("Permissions")
 
 	if query.Search != "" {
 		db = db.Where("name ILIKE ? OR description ILIKE ?", "%"+query.Search+"%", "%"+query.Search+"%")
@@ -49,9 +61,9 @@ func (s *GroupService) GetGroups(query *models.PaginationQuery) (*models.Paginat
 	}, nil
 }
 
-func (s *GroupService) GetGroupByID(id uint) (*models.Group, error) {
+func (s *GroupService) GetGroupByID(id uint, organizationID *uint) (*models.Group, error) {
 	var group models.Group
-	if err := database.GetDB().First(&group, id).Error; err != nil {
+	if err := scopeGroups(database.GetDB().Preload("Permissions"), organizationID).First(&group, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("group not found")
 		}
@@ -60,18 +72,24 @@ func (s *GroupService) GetGroupByID(id uint) (*models.Group, error) {
 	return &group, nil
 }
 
-func (s *GroupService) CreateGroup(req *models.GroupRequest) (*models.Group, error) {
-	var existingGroup models.Group
-	if err := database.GetDB().Where("name = ?", req.Name).First(&existingGroup).Error; err == nil {
+// CreateGroup creates a group in the caller's organization, or a global template for callers without one
+func (s *GroupService) CreateGroup(req *models.GroupRequest, organizationID *uint) (*models.Group, error) {
+	if groupNameTaken(req.Name, organizationID, 0) {
 		return nil, errors.New("group with this name already exists")
 	}
 
+	permissions, err := findPermissions(database.GetDB(), req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
 	group := &models.Group{
-		Name:        req.Name,
-		Description: req.Description,
-		Permissions: req.Permissions,
-		IsActive:    true,
-		IsDefault:   false,
+		Name:           req.Name,
+		Description:    req.Description,
+		Permissions:    permissions,
+		IsActive:       true,
+		IsDefault:      false,
+		OrganizationID: organizationID,
 	}
 
 	if req.IsActive != nil {
@@ -85,29 +103,75 @@ func (s *GroupService) CreateGroup(req *models.GroupRequest) (*models.Group, err
 		return nil, err
 	}
 
-	return group, nil
+	return s.GetGroupByID(group.ID, organizationID)
 }
 
-func (s *GroupService) UpdateGroup(id uint, req *models.GroupRequest) (*models.Group, error) {
-	var group models.Group
-	if err := database.GetDB().First(&group, id).Error; err != nil {
+// CloneTemplate copies a global template group, with its permissions, into an organization
+func (s *GroupService) CloneTemplate(templateID uint, req *models.GroupCloneRequest, organizationID *uint) (*models.Group, error) {
+	// Callers outside any organization pick the target, everyone else clones into their own
+	target := organizationID
+	if target == nil {
+		target = req.OrganizationID
+	}
+	if target == nil {
+		return nil, errors.New("organization_id is required")
+	}
+
+	var template models.Group
+	if err := database.GetDB().Preload("Permissions").Where("organization_id IS NULL").First(&template, templateID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("group not found")
 		}
 		return nil, err
 	}
 
-	if req.Name != group.Name {
-		var existingGroup models.Group
-		if err := database.GetDB().Where("name = ? AND id != ?", req.Name, id).First(&existingGroup).Error; err == nil {
-			return nil, errors.New("group with this name already exists")
-		}
+	var org models.Organization
+	if err := database.GetDB().First(&org, *target).Error; err != nil {
+		return nil, errors.New("organization not found")
+	}
+
+	name := template.Name
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if groupNameTaken(name, target, 0) {
+		return nil, errors.New("group with this name already exists")
+	}
+
+	group := &models.Group{
+		Name:           name,
+		Description:    template.Description,
+		Permissions:    template.Permissions,
+		IsActive:       template.IsActive,
+		IsDefault:      template.IsDefault,
+		OrganizationID: target,
+	}
+
+	if err := database.GetDB().Create(group).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetGroupByID(group.ID, target)
+}
+
+func (s *GroupService) UpdateGroup(id uint, req *models.GroupRequest, organizationID *uint) (*models.Group, error) {
+	group, err := s.GetGroupByID(id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != group.Name && groupNameTaken(req.Name, group.OrganizationID, id) {
+		return nil, errors.New("group with this name already exists")
+	}
+
+	permissions, err := findPermissions(database.GetDB(), req.Permissions)
+	if err != nil {
+		return nil, err
 	}
 
 	updates := map[string]interface{}{
 		"name":        req.Name,
 		"description": req.Description,
-		"permissions": req.Permissions,
 	}
 
 	if req.IsActive != nil {
@@ -117,25 +181,46 @@ func (s *GroupService) UpdateGroup(id uint, req *models.GroupRequest) (*models.G
 		updates["is_default"] = *req.IsDefault
 	}
 
-	if err := database.GetDB().Model(&group).Updates(updates).Error; err != nil {
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(group).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Model(group).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	if err := database.GetDB().First(&group, id).Error; err != nil {
-		return nil, err
+	return s.GetGroupByID(id, organizationID)
+}
+
+func (s *GroupService) DeleteGroup(id uint, organizationID *uint) error {
+	group, err := s.GetGroupByID(id, organizationID)
+	if err != nil {
+		return err
 	}
 
-	return &group, nil
+	return database.GetDB().Delete(group).Error
 }
 
-func (s *GroupService) DeleteGroup(id uint) error {
-	var group models.Group
-	if err := database.GetDB().First(&group, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("group not found")
-		}
-		return err
+// scopeGroups limits a query to the caller's organization. Callers outside any
+// organization manage every group, as with users.
+func scopeGroups(db *gorm.DB, organizationID *uint) *gorm.DB {
+	if organizationID != nil {
+		return db.Where("organization_id = ?", *organizationID)
+	}
+	return db
+}
+
+// groupNameTaken checks name uniqueness within one organization, or among global groups
+func groupNameTaken(name string, organizationID *uint, excludeID uint) bool {
+	query := database.GetDB().Model(&models.Group{}).Where("name = ? AND id != ?", name, excludeID)
+	if organizationID != nil {
+		query = query.Where("organization_id = ?", *organizationID)
+	} else {
+		query = query.Where("organization_id IS NULL")
 	}
 
-	return database.GetDB().Delete(&group).Error
+	var count int64
+	return query.Count(&count).Error == nil && count > 0
 }
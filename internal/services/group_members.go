@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// GetMembers lists the users in a group. Callers with an organization only see their own groups.
+func (s *GroupService) GetMembers(groupID uint, query *models.PaginationQuery, organizationID *uint) (*models.PaginatedResponse[models.UserResponse], error) {
+	if _, err := findScopedGroup(database.GetDB(), groupID, organizationID); err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	var total int64
+
+	db := scopeUsers(database.GetDB().Model(&models.User{}), organizationID).
+		Joins("JOIN user_groups ON user_groups.user_id = users.id").
+		Where("user_groups.group_id = ?", groupID)
+
+	if query.Search != "" {
+		db = db.Where("users.name ILIKE ? OR users.email ILIKE ?", "%"+query.Search+"%", "%"+query.Search+"%")
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	offset := (query.Page - 1) * query.PageSize
+	if err := db.Order("users.id").Offset(offset).Limit(query.PageSize).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	userService := NewUserService()
+	userResponses := make([]models.UserResponse, len(users))
+	for i, user := range users {
+		viewFromOrganization(&user, organizationID)
+		userResponses[i] = userService.toUserResponse(&user)
+	}
+
+	totalPages := int(math.Ceil(float64(total) / float64(query.PageSize)))
+
+	return &models.PaginatedResponse[models.UserResponse]{
+		Data:       userResponses,
+		Total:      int(total),
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// AddMembers adds users to a group
+func (s *GroupService) AddMembers(groupID uint, req *models.GroupMembersRequest, actorID uint, organizationID *uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		group, users, err := s.loadMembership(tx, groupID, req.UserIDs, organizationID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(group).Association("Users").Append(users); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actorID, organizationID, models.AuditGroupMembersAdd, "group", group.ID,
+			map[string]interface{}{"user_ids": req.UserIDs})
+	})
+}
+
+// RemoveMembers removes users from a group
+func (s *GroupService) RemoveMembers(groupID uint, req *models.GroupMembersRequest, actorID uint, organizationID *uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		group, users, err := s.loadMembership(tx, groupID, req.UserIDs, organizationID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(group).Association("Users").Delete(users); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actorID, organizationID, models.AuditGroupMembersRemove, "group", group.ID,
+			map[string]interface{}{"user_ids": req.UserIDs})
+	})
+}
+
+func (s *GroupService) loadMembership(tx *gorm.DB, groupID uint, userIDs []uint, organizationID *uint) (*models.Group, []models.User, error) {
+	group, err := findScopedGroup(tx, groupID, organizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := tx.Preload("Memberships").Where("id IN ?", userIDs)
+	if organizationID != nil {
+		query = query.Where("id IN (SELECT user_id FROM organization_members WHERE organization_id = ?)", *organizationID)
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[uint]bool, len(users))
+	for _, user := range users {
+		found[user.ID] = true
+		if !canJoinGroup(&user, group) {
+			return nil, nil, fmt.Errorf("user %d is not a member of the group's organization", user.ID)
+		}
+	}
+	for _, id := range userIDs {
+		if !found[id] {
+			return nil, nil, fmt.Errorf("user %d not found", id)
+		}
+	}
+
+	return group, users, nil
+}
+
+// SetGroups replaces every group the user belongs to
+func (s *UserService) SetGroups(userID uint, req *models.UserGroupsRequest, actorID uint, organizationID *uint) (*models.UserResponse, error) {
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		query := tx.Preload("Groups").Preload("Memberships")
+		if organizationID != nil {
+			query = query.Where("id IN (SELECT user_id FROM organization_members WHERE organization_id = ?)", *organizationID)
+		}
+		if err := query.First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("user not found")
+			}
+			return err
+		}
+
+		groups := make([]models.Group, 0, len(req.GroupIDs))
+		for _, id := range req.GroupIDs {
+			group, err := findScopedGroup(tx, id, organizationID)
+			if err != nil {
+				if err.Error() == "group not found" {
+					return fmt.Errorf("group %d not found", id)
+				}
+				return err
+			}
+			if !canJoinGroup(&user, group) {
+				return fmt.Errorf("group %d belongs to an organization the user is not a member of", id)
+			}
+			groups = append(groups, *group)
+		}
+
+		// Groups the caller cannot manage, such as those of the user's other organizations, are kept
+		previous := make([]uint, 0, len(user.Groups))
+		for _, group := range user.Groups {
+			if organizationID != nil && (group.OrganizationID == nil || *group.OrganizationID != *organizationID) {
+				groups = append(groups, group)
+				continue
+			}
+			previous = append(previous, group.ID)
+		}
+
+		if err := tx.Model(&user).Association("Groups").Replace(groups); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actorID, organizationID, models.AuditUserGroupsReplace, "user", user.ID,
+			map[string]interface{}{"group_ids": req.GroupIDs, "previous_group_ids": previous})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUserByID(userID, organizationID)
+}
+
+// findScopedGroup loads a group the caller's organization may manage
+func findScopedGroup(tx *gorm.DB, id uint, organizationID *uint) (*models.Group, error) {
+	var group models.Group
+	if err := scopeGroups(tx, organizationID).First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("group not found")
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// canJoinGroup reports whether the user may be a member: organization groups only take
+// members of their organization, global groups take anyone. Memberships must be preloaded.
+func canJoinGroup(user *models.User, group *models.Group) bool {
+	if group.OrganizationID == nil {
+		return true
+	}
+	return user.Membership(*group.OrganizationID) != nil
+}
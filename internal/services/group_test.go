@@ -0,0 +1,152 @@
+package services
+
+import (
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"testing"
+)
+
+// groupTenants sets up two organizations with a group and a member each, plus a global template
+type groupTenants struct {
+	orgA, orgB     *models.Organization
+	groupA, groupB *models.Group
+	userA, userB   *models.User
+	template       *models.Group
+}
+
+func setupGroupTenants(t *testing.T) *groupTenants {
+	t.Helper()
+	setupTestDB(t)
+
+	s := NewGroupService()
+	tenants := &groupTenants{
+		orgA: createTestOrganization(t, "Tenant A"),
+		orgB: createTestOrganization(t, "Tenant B"),
+	}
+	tenants.userA = createTestUser(t, "a@a.example", tenants.orgA)
+	tenants.userB = createTestUser(t, "b@b.example", tenants.orgB)
+
+	var err error
+	if tenants.groupA, err = s.CreateGroup(&models.GroupRequest{Name: "Editors"}, &tenants.orgA.ID); err != nil {
+		t.Fatalf("CreateGroup A: %v", err)
+	}
+	if tenants.groupB, err = s.CreateGroup(&models.GroupRequest{Name: "Editors"}, &tenants.orgB.ID); err != nil {
+		t.Fatalf("CreateGroup B: %v", err)
+	}
+	if tenants.template, err = s.CreateGroup(&models.GroupRequest{Name: "Viewers"}, nil); err != nil {
+		t.Fatalf("CreateGroup template: %v", err)
+	}
+	return tenants
+}
+
+func TestGroupsAreListedPerOrganization(t *testing.T) {
+	tenants := setupGroupTenants(t)
+	s := NewGroupService()
+	query := &models.PaginationQuery{Page: 1, PageSize: 100}
+
+	for _, tc := range []struct {
+		org   *models.Organization
+		group *models.Group
+	}{{tenants.orgA, tenants.groupA}, {tenants.orgB, tenants.groupB}} {
+		groups, err := s.GetGroups(query, &tc.org.ID)
+		if err != nil {
+			t.Fatalf("GetGroups: %v", err)
+		}
+		if groups.Total != 1 || groups.Data[0].ID != tc.group.ID {
+			t.Errorf("organization %d sees groups %+v, want only group %d", tc.org.ID, groups.Data, tc.group.ID)
+		}
+	}
+
+	// Callers outside any organization see every group
+	all, err := s.GetGroups(query, nil)
+	if err != nil {
+		t.Fatalf("GetGroups: %v", err)
+	}
+	if all.Total != 3 {
+		t.Errorf("expected 3 groups without an organization, got %d", all.Total)
+	}
+}
+
+func TestGroupsOfAnotherOrganizationCannotBeReachedByID(t *testing.T) {
+	tenants := setupGroupTenants(t)
+	s := NewGroupService()
+	orgB := &tenants.orgB.ID
+
+	_, err := s.GetGroupByID(tenants.groupA.ID, orgB)
+	mustNotFind(t, err, "group")
+
+	_, err = s.UpdateGroup(tenants.groupA.ID, &models.GroupRequest{Name: "Taken over"}, orgB)
+	mustNotFind(t, err, "group")
+
+	mustNotFind(t, s.DeleteGroup(tenants.groupA.ID, orgB), "group")
+
+	_, err = s.GetMembers(tenants.groupA.ID, &models.PaginationQuery{Page: 1, PageSize: 10}, orgB)
+	mustNotFind(t, err, "group")
+
+	err = s.AddMembers(tenants.groupA.ID, &models.GroupMembersRequest{UserIDs: []uint{tenants.userB.ID}}, tenants.userB.ID, orgB)
+	mustNotFind(t, err, "group")
+
+	// Global templates are not editable from inside an organization either
+	_, err = s.UpdateGroup(tenants.template.ID, &models.GroupRequest{Name: "Taken over"}, orgB)
+	mustNotFind(t, err, "group")
+	mustNotFind(t, s.DeleteGroup(tenants.template.ID, orgB), "group")
+
+	var group models.Group
+	if err := database.GetDB().First(&group, tenants.groupA.ID).Error; err != nil {
+		t.Fatalf("group A is gone: %v", err)
+	}
+	if group.Name != "Editors" {
+		t.Errorf("group A was renamed to %q", group.Name)
+	}
+}
+
+func TestGroupMembersMustBelongToTheGroupsOrganization(t *testing.T) {
+	tenants := setupGroupTenants(t)
+	s := NewGroupService()
+
+	// Adding another tenant's user looks exactly like adding one that does not exist
+	err := s.AddMembers(tenants.groupA.ID, &models.GroupMembersRequest{UserIDs: []uint{tenants.userB.ID}}, tenants.userA.ID, &tenants.orgA.ID)
+	if err == nil {
+		t.Fatal("expected adding a user of another organization to fail")
+	}
+
+	// Even callers outside any organization cannot put a user in a group of an organization
+	// they are not a member of
+	err = s.AddMembers(tenants.groupA.ID, &models.GroupMembersRequest{UserIDs: []uint{tenants.userB.ID}}, tenants.userA.ID, nil)
+	if err == nil {
+		t.Fatal("expected adding a non-member to an organization group to fail")
+	}
+
+	if err := s.AddMembers(tenants.groupA.ID, &models.GroupMembersRequest{UserIDs: []uint{tenants.userA.ID}}, tenants.userA.ID, &tenants.orgA.ID); err != nil {
+		t.Fatalf("AddMembers: %v", err)
+	}
+
+	members, err := s.GetMembers(tenants.groupA.ID, &models.PaginationQuery{Page: 1, PageSize: 10}, &tenants.orgA.ID)
+	if err != nil {
+		t.Fatalf("GetMembers: %v", err)
+	}
+	if members.Total != 1 || members.Data[0].ID != tenants.userA.ID {
+		t.Errorf("unexpected members %+v", members.Data)
+	}
+
+	_, err = NewUserService().SetGroups(tenants.userB.ID, &models.UserGroupsRequest{GroupIDs: []uint{tenants.groupA.ID}}, tenants.userA.ID, &tenants.orgA.ID)
+	mustNotFind(t, err, "user")
+}
+
+func TestTemplatesAreClonedIntoTheCallersOrganization(t *testing.T) {
+	tenants := setupGroupTenants(t)
+	s := NewGroupService()
+
+	// The requested organization is ignored for callers inside one
+	clone, err := s.CloneTemplate(tenants.template.ID, &models.GroupCloneRequest{OrganizationID: &tenants.orgA.ID}, &tenants.orgB.ID)
+	if err != nil {
+		t.Fatalf("CloneTemplate: %v", err)
+	}
+	if clone.OrganizationID == nil || *clone.OrganizationID != tenants.orgB.ID {
+		t.Errorf("template cloned into organization %v, want %d", clone.OrganizationID, tenants.orgB.ID)
+	}
+
+	// Only global groups are templates
+	_, err = s.CloneTemplate(tenants.groupA.ID, &models.GroupCloneRequest{Name: stringPtr("Stolen")}, &tenants.orgB.ID)
+	mustNotFind(t, err, "group")
+}
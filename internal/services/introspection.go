@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/middleware"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+// Introspect reports whether a token is active (RFC 7662).
+// Clients owned by an organization only see tokens from that organization.
+func (s *OIDCService) Introspect(req *models.TokenIntrospectionRequest) (*models.TokenIntrospectionResponse, error) {
+	client, err := s.authenticateResourceClient(req)
+	if err != nil {
+		return nil, err
+	}
+
+	inactive := &models.TokenIntrospectionResponse{Active: false}
+
+	if req.TokenTypeHint != tokenTypeRefresh {
+		if response, ok := s.introspectAccessToken(req.Token); ok {
+			if !sameOrganization(client, response.OrganizationID) {
+				return inactive, nil
+			}
+			return response, nil
+		}
+	}
+
+	response, err := s.introspectRefreshToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil || !sameOrganization(client, response.OrganizationID) {
+		return inactive, nil
+	}
+
+	return response, nil
+}
+
+// Revoke invalidates an access or refresh token (RFC 7009).
+// Unknown tokens are not an error, so the response never reveals whether a token existed.
+func (s *OIDCService) Revoke(req *models.TokenIntrospectionRequest) error {
+	client, err := s.authenticateResourceClient(req)
+	if err != nil {
+		return err
+	}
+
+	if req.TokenTypeHint != tokenTypeRefresh {
+		if principal, err := middleware.Authenticate(req.Token); err == nil {
+			if !sameOrganization(client, principal.Claims.OrganizationID) {
+				return nil
+			}
+			return s.authService.revokeAccessToken(principal.Claims)
+		}
+	}
+
+	var refreshToken models.RefreshToken
+	if err := database.GetDB().Preload("User").Where("token_hash = ?", tokens.Hash(req.Token)).First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if refreshToken.User == nil || !sameOrganization(client, refreshToken.OrganizationID) {
+		return nil
+	}
+
+	return s.authService.revokeRefreshFamily(database.GetDB(), refreshToken.FamilyID)
+}
+
+// authenticateResourceClient authenticates the caller of the introspection and revocation endpoints
+func (s *OIDCService) authenticateResourceClient(req *models.TokenIntrospectionRequest) (*models.OAuthClient, error) {
+	client, err := s.clientService.AuthenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsConfidential {
+		return nil, &models.OAuthError{Code: "invalid_client", Description: "only confidential clients may call this endpoint"}
+	}
+
+	return client, nil
+}
+
+func (s *OIDCService) introspectAccessToken(raw string) (*models.TokenIntrospectionResponse, bool) {
+	principal, err := middleware.Authenticate(raw)
+	if err != nil {
+		return nil, false
+	}
+
+	claims := principal.Claims
+	response := &models.TokenIntrospectionResponse{
+		Active:         true,
+		TokenType:      tokenTypeAccess,
+		ClientID:       claims.ClientID,
+		OrganizationID: claims.OrganizationID,
+		Permissions:    claims.Permissions,
+		Scope:          claims.Scope,
+		JTI:            claims.ID,
+	}
+
+	if principal.User != nil {
+		response.Subject = strconv.FormatUint(uint64(principal.User.ID), 10)
+		response.Username = principal.User.Email
+	} else {
+		response.Subject = claims.Subject
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	return response, true
+}
+
+func (s *OIDCService) introspectRefreshToken(raw string) (*models.TokenIntrospectionResponse, error) {
+	var refreshToken models.RefreshToken
+	if err := database.GetDB().Preload("User").Where("token_hash = ?", tokens.Hash(raw)).First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	user := refreshToken.User
+	if refreshToken.RevokedAt != nil || refreshToken.IsExpired() || user == nil || user.IsDeleted || !user.IsActive {
+		return nil, nil
+	}
+
+	return &models.TokenIntrospectionResponse{
+		Active:         true,
+		TokenType:      tokenTypeRefresh,
+		Subject:        strconv.FormatUint(uint64(user.ID), 10),
+		Username:       user.Email,
+		OrganizationID: refreshToken.OrganizationID,
+		ExpiresAt:      refreshToken.ExpiresAt.Unix(),
+		IssuedAt:       refreshToken.CreatedAt.Unix(),
+	}, nil
+}
+
+// sameOrganization reports whether a client may see a token from the given organization.
+// Platform clients (no organization) can see every token.
+func sameOrganization(client *models.OAuthClient, organizationID *uint) bool {
+	if client.OrganizationID == nil {
+		return true
+	}
+	return organizationID != nil && *organizationID == *client.OrganizationID
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"math"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errInvalidInvitation = errors.New("invalid or expired invitation")
+
+type InvitationService struct {
+	cfg          *config.Config
+	authService  *AuthService
+	emailService *EmailService
+}
+
+func NewInvitationService(cfg *config.Config) *InvitationService {
+	return &InvitationService{
+		cfg:          cfg,
+		authService:  NewAuthService(cfg),
+		emailService: NewEmailService(cfg),
+	}
+}
+
+// CreateInvitation emails an invitation to join the organization. A pending invitation to the
+// same email is replaced, so only the latest link works.
+func (s *InvitationService) CreateInvitation(id uint, req *models.InvitationRequest, actor *models.User, organizationID *uint) (*models.InvitationResponse, error) {
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/accept-invitation", nil); err != nil {
+		return nil, err
+	}
+
+	role := req.Role
+	if role == "" {
+		role = models.OrgRoleMember
+	}
+
+	var invitation *models.OrganizationInvitation
+	var org *models.Organization
+	var raw string
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if org, err = findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		if role == models.OrgRoleOwner {
+			if err := checkOwnerChange(tx, id, actor); err != nil {
+				return err
+			}
+		}
+
+		var member models.OrganizationMember
+		if err := tx.Joins("JOIN users ON users.id = organization_members.user_id").
+			Where("organization_members.organization_id = ? AND users.email = ?", id, req.Email).
+			First(&member).Error; err == nil {
+			return errors.New("user is already a member of this organization")
+		}
+
+		for _, groupID := range req.GroupIDs {
+			if _, err := findScopedGroup(tx, groupID, &id); err != nil {
+				if err.Error() == "group not found" {
+					return fmt.Errorf("group %d not found", groupID)
+				}
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.OrganizationInvitation{}).
+			Where("organization_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL", id, req.Email).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var hash string
+		if raw, hash, err = tokens.Generate(); err != nil {
+			return err
+		}
+
+		invitation = &models.OrganizationInvitation{
+			OrganizationID: id,
+			Email:          req.Email,
+			Role:           role,
+			GroupIDs:       req.GroupIDs,
+			TokenHash:      hash,
+			RedirectURL:    req.RedirectURL,
+			InvitedByID:    actor.ID,
+			ExpiresAt:      time.Now().Add(time.Duration(s.cfg.Security.InvitationExpiration) * time.Second),
+		}
+		if err := tx.Create(invitation).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditInvitationCreate, "invitation", invitation.ID,
+			map[string]interface{}{"email": req.Email, "role": role})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The invitation exists at this point, a failed email can be retried through resend
+	if err := s.sendInvitationEmail(invitation, org, actor, raw); err != nil {
+		log.Printf("Failed to send invitation %d: %v", invitation.ID, err)
+	}
+
+	response := toInvitationResponse(invitation)
+	return &response, nil
+}
+
+// GetInvitations lists an organization's invitations, newest first, optionally by status
+func (s *InvitationService) GetInvitations(id uint, query *models.PaginationQuery, organizationID *uint) (*models.PaginatedResponse[models.InvitationResponse], error) {
+	if _, err := findScopedOrganization(database.GetDB(), id, organizationID); err != nil {
+		return nil, err
+	}
+
+	var invitations []models.OrganizationInvitation
+	var total int64
+
+	db := database.GetDB().Model(&models.OrganizationInvitation{}).Where("organization_id = ?", id)
+
+	if query.Search != "" {
+		db = db.Where("email ILIKE ?", "%"+query.Search+"%")
+	}
+
+	if query.Status != "" {
+		now := time.Now()
+		switch query.Status {
+		case models.InvitationPending:
+			db = db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+		case models.InvitationAccepted:
+			db = db.Where("accepted_at IS NOT NULL")
+		case models.InvitationRevoked:
+			db = db.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+		case models.InvitationExpired:
+			db = db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+		}
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	offset := (query.Page - 1) * query.PageSize
+	if err := db.Order("created_at DESC").Offset(offset).Limit(query.PageSize).Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+
+	invitationResponses := make([]models.InvitationResponse, len(invitations))
+	for i, invitation := range invitations {
+		invitationResponses[i] = toInvitationResponse(&invitation)
+	}
+
+	totalPages := int(math.Ceil(float64(total) / float64(query.PageSize)))
+
+	return &models.PaginatedResponse[models.InvitationResponse]{
+		Data:       invitationResponses,
+		Total:      int(total),
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// ResendInvitation emails a fresh link for a pending or expired invitation. The old link stops
+// working, as do other pending invitations to the same email, so only the resent link works.
+func (s *InvitationService) ResendInvitation(id, invitationID uint, actor *models.User, organizationID *uint) (*models.InvitationResponse, error) {
+	var invitation models.OrganizationInvitation
+	var org *models.Organization
+	var raw string
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if org, err = findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		if err := findInvitation(tx, id, invitationID, &invitation); err != nil {
+			return err
+		}
+
+		if status := invitation.Status(); status != models.InvitationPending && status != models.InvitationExpired {
+			return errors.New("invitation is no longer pending")
+		}
+
+		if err := tx.Model(&models.OrganizationInvitation{}).
+			Where("organization_id = ? AND email = ? AND id != ? AND accepted_at IS NULL AND revoked_at IS NULL", id, invitation.Email, invitation.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var hash string
+		if raw, hash, err = tokens.Generate(); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&invitation).Updates(map[string]interface{}{
+			"token_hash": hash,
+			"expires_at": time.Now().Add(time.Duration(s.cfg.Security.InvitationExpiration) * time.Second),
+		}).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditInvitationResend, "invitation", invitation.ID,
+			map[string]interface{}{"email": invitation.Email})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sendInvitationEmail(&invitation, org, actor, raw); err != nil {
+		return nil, err
+	}
+
+	response := toInvitationResponse(&invitation)
+	return &response, nil
+}
+
+// RevokeInvitation cancels a pending invitation
+func (s *InvitationService) RevokeInvitation(id, invitationID uint, actor *models.User, organizationID *uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if _, err := findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		var invitation models.OrganizationInvitation
+		if err := findInvitation(tx, id, invitationID, &invitation); err != nil {
+			return err
+		}
+
+		if invitation.Status() != models.InvitationPending {
+			return errors.New("invitation is no longer pending")
+		}
+
+		if err := tx.Model(&invitation).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditInvitationRevoke, "invitation", invitation.ID,
+			map[string]interface{}{"email": invitation.Email})
+	})
+}
+
+// AcceptInvitation joins the invited email to the organization with the invitation's role and
+// groups. An existing account is attached as is; otherwise one is created with the given name
+// and password, already verified since the link proves ownership of the address.
+func (s *InvitationService) AcceptInvitation(req *models.AcceptInvitationRequest) (*models.User, error) {
+	var user models.User
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var invitation models.OrganizationInvitation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Organization.Settings").
+			Where("token_hash = ?", tokens.Hash(req.Token)).First(&invitation).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidInvitation
+			}
+			return err
+		}
+
+		if invitation.Status() != models.InvitationPending || invitation.Organization == nil {
+			return errInvalidInvitation
+		}
+		org := invitation.Organization
+
+		err := tx.Where("email = ?", invitation.Email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := s.createInvitedUser(tx, &user, &invitation, req); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			if user.IsDeleted || !user.IsActive {
+				return errors.New("account is deactivated")
+			}
+			if user.OrganizationID == nil {
+				if err := tx.Model(&user).Update("organization_id", org.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := addMembership(tx, org.ID, user.ID, invitation.Role); err != nil {
+			return err
+		}
+
+		// Groups deleted since the invitation was sent are skipped
+		if len(invitation.GroupIDs) > 0 {
+			var groups []models.Group
+			if err := tx.Where("id IN ? AND organization_id = ?", invitation.GroupIDs, org.ID).Find(&groups).Error; err != nil {
+				return err
+			}
+			if len(groups) > 0 {
+				if err := tx.Model(&user).Association("Groups").Append(groups); err != nil {
+					return err
+				}
+			}
+		}
+
+		acceptedBy := user.ID
+		if err := tx.Model(&invitation).Updates(map[string]interface{}{
+			"accepted_at":    time.Now(),
+			"accepted_by_id": acceptedBy,
+		}).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, user.ID, &org.ID, models.AuditInvitationAccept, "invitation", invitation.ID,
+			map[string]interface{}{"role": invitation.Role})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// createInvitedUser creates the account for an invitation sent to an unknown email
+func (s *InvitationService) createInvitedUser(tx *gorm.DB, user *models.User, invitation *models.OrganizationInvitation, req *models.AcceptInvitationRequest) error {
+	if req.Name == "" || req.Password == "" {
+		return errors.New("name and password are required to create an account")
+	}
+
+	org := invitation.Organization
+	now := time.Now()
+	*user = models.User{
+		Email:             invitation.Email,
+		Name:              req.Name,
+		OrganizationID:    &org.ID,
+		IsActive:          true,
+		IsVerified:        true,
+		PasswordChangedAt: &now,
+	}
+
+	if err := s.authService.checkNewPassword(tx, user, org, req.Password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := password.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := s.authService.assignDefaultGroups(tx, user); err != nil {
+		return err
+	}
+	return s.authService.recordPassword(tx, user.ID, org, user.Password)
+}
+
+func (s *InvitationService) sendInvitationEmail(invitation *models.OrganizationInvitation, org *models.Organization, inviter *models.User, raw string) error {
+	link, err := s.emailService.BuildLink(invitation.RedirectURL, "/accept-invitation", url.Values{
+		"token": {raw},
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.emailService.SendEmail(&models.EmailRequest{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", org.Name),
+		Body: fmt.Sprintf("Hi,\n\n%s has invited you to join %s as %s. Use the link below to accept. It expires in %d days.\n\n%s\n\nIf you were not expecting this invitation you can ignore this email.\n",
+			inviter.Name, org.Name, invitation.Role, s.cfg.Security.InvitationExpiration/(24*60*60), link),
+	})
+}
+
+// findInvitation loads one of the organization's invitations
+func findInvitation(tx *gorm.DB, organizationID, invitationID uint, invitation *models.OrganizationInvitation) error {
+	if err := tx.Where("organization_id = ?", organizationID).First(invitation, invitationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invitation not found")
+		}
+		return err
+	}
+	return nil
+}
+
+func toInvitationResponse(invitation *models.OrganizationInvitation) models.InvitationResponse {
+	return models.InvitationResponse{
+		ID:             invitation.ID,
+		OrganizationID: invitation.OrganizationID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		GroupIDs:       invitation.GroupIDs,
+		Status:         invitation.Status(),
+		InvitedByID:    invitation.InvitedByID,
+		ExpiresAt:      invitation.ExpiresAt.Format(time.RFC3339),
+		CreatedAt:      invitation.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/ratelimit"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"math/big"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	magicLinkPurpose        = "magic_link"
+	emailCodeMaxAttempts    = 5
+	errInvalidEmailLoginMsg = "invalid or expired login link or code"
+)
+
+// magicLinkClaims is the signed payload of a magic link; the nonce ties it to a single-use row
+type magicLinkClaims struct {
+	UserID uint   `json:"uid"`
+	Nonce  string `json:"nonce"`
+}
+
+// RequestMagicLink emails a single-use login link or 6-digit code. It responds the same
+// way whether or not the account exists. Every request emails the account, so requests are
+// throttled per address and per client IP like failed logins.
+func (s *AuthService) RequestMagicLink(req *models.MagicLinkRequest) error {
+	mode := req.Mode
+	if mode == "" {
+		mode = models.EmailLoginModeLink
+	}
+
+	if mode == models.EmailLoginModeLink {
+		if _, err := s.emailService.BuildLink(req.RedirectURL, "/magic-link", nil); err != nil {
+			return err
+		}
+	}
+
+	accountKey := ratelimit.EmailLoginKey(req.Email)
+	ipKey := ""
+	if req.ClientIP != "" {
+		ipKey = ratelimit.EmailLoginIPKey(req.ClientIP)
+	}
+
+	if err := ratelimit.Logins.Check(accountKey, ipKey); err != nil {
+		return err
+	}
+	if err := ratelimit.Logins.AccountFailure(accountKey); err != nil {
+		log.Printf("Failed to record sign-in email request: %v", err)
+	}
+	if err := ratelimit.Logins.IPFailure(ipKey); err != nil {
+		log.Printf("Failed to record sign-in email request: %v", err)
+	}
+
+	user, err := s.findLoginUser(req.Email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.IsDeleted || !user.IsActive {
+		return nil
+	}
+
+	var secret string
+	if mode == models.EmailLoginModeCode {
+		secret, err = newEmailCode()
+	} else {
+		secret, err = tokens.NewID()
+	}
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Duration(s.cfg.Security.MagicLinkExpiration) * time.Second
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Only the most recently requested link or code stays valid
+		if err := tx.Model(&models.EmailLoginToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.EmailLoginToken{
+			UserID:    user.ID,
+			Mode:      mode,
+			TokenHash: emailLoginHash(user.ID, secret),
+			ExpiresAt: time.Now().Add(expiration),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	var email *models.EmailRequest
+	if mode == models.EmailLoginModeCode {
+		email = &models.EmailRequest{
+			To:      user.Email,
+			Subject: "Your sign-in code",
+			Body: fmt.Sprintf("Hi %s,\n\nYour sign-in code is %s. It expires in %d minutes.\n\nIf you did not try to sign in you can ignore this email.\n",
+				user.Name, secret, s.cfg.Security.MagicLinkExpiration/60),
+		}
+	} else {
+		token, err := tokens.Sign(s.cfg.Security.EncryptionKey, magicLinkPurpose, magicLinkClaims{UserID: user.ID, Nonce: secret}, expiration)
+		if err != nil {
+			return err
+		}
+
+		link, err := s.emailService.BuildLink(req.RedirectURL, "/magic-link", url.Values{"token": {token}})
+		if err != nil {
+			return err
+		}
+
+		email = &models.EmailRequest{
+			To:      user.Email,
+			Subject: "Your sign-in link",
+			Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to sign in. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you did not try to sign in you can ignore this email.\n",
+				user.Name, s.cfg.Security.MagicLinkExpiration/60, link),
+		}
+	}
+
+	go func() {
+		if err := s.emailService.SendEmail(email); err != nil {
+			log.Printf("Failed to send sign-in email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// VerifyMagicLink exchanges a magic link token or an emailed code for the same response as Login.
+// Wrong codes count towards the same per account and per IP lockout as wrong passwords, so
+// requesting a new code does not bring back the guesses used on the old one.
+func (s *AuthService) VerifyMagicLink(req *models.MagicLinkVerifyRequest) (*models.LoginResponse, error) {
+	if req.Token != "" || req.Email == "" || req.Code == "" {
+		return s.verifyEmailLogin(req)
+	}
+
+	accountKey := ratelimit.AccountKey(req.Email)
+	ipKey := ""
+	if req.ClientIP != "" {
+		ipKey = ratelimit.IPKey(req.ClientIP)
+	}
+
+	if err := ratelimit.Logins.Check(accountKey, ipKey); err != nil {
+		return nil, err
+	}
+
+	response, err := s.verifyEmailLogin(req)
+	var mfaErr *MFARequiredError
+	switch {
+	case err != nil && err.Error() == errInvalidEmailLoginMsg:
+		if err := ratelimit.Logins.AccountFailure(accountKey); err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+		}
+		if err := ratelimit.Logins.IPFailure(ipKey); err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+		}
+	case err == nil || errors.As(err, &mfaErr):
+		if err := ratelimit.Logins.Reset(accountKey); err != nil {
+			log.Printf("Failed to reset login failures: %v", err)
+		}
+	}
+	return response, err
+}
+
+// verifyEmailLogin consumes the link or code and signs the user in
+func (s *AuthService) verifyEmailLogin(req *models.MagicLinkVerifyRequest) (*models.LoginResponse, error) {
+	var userID uint
+	var secret string
+
+	switch {
+	case req.Token != "":
+		var claims magicLinkClaims
+		if err := tokens.Verify(s.cfg.Security.EncryptionKey, magicLinkPurpose, req.Token, &claims); err != nil {
+			return nil, errors.New(errInvalidEmailLoginMsg)
+		}
+		userID, secret = claims.UserID, claims.Nonce
+	case req.Email != "" && req.Code != "":
+		user, err := s.findLoginUser(req.Email)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New(errInvalidEmailLoginMsg)
+		}
+		userID, secret = user.ID, req.Code
+	default:
+		return nil, errors.New("token, or email and code, are required")
+	}
+
+	var user models.User
+	var verifyErr error
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// The latest unused row is the only valid one, see RequestMagicLink
+		var loginToken models.EmailLoginToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Order("created_at DESC").
+			First(&loginToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New(errInvalidEmailLoginMsg)
+			}
+			return err
+		}
+
+		if time.Now().After(loginToken.ExpiresAt) || loginToken.Attempts >= emailCodeMaxAttempts {
+			return errors.New(errInvalidEmailLoginMsg)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(loginToken.TokenHash), []byte(emailLoginHash(userID, secret))) != 1 {
+			verifyErr = errors.New(errInvalidEmailLoginMsg)
+			// Commit the attempt counter so codes cannot be guessed indefinitely
+			return tx.Model(&loginToken).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		if err := tx.Model(&loginToken).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, userID).Error; err != nil {
+			return errors.New(errInvalidEmailLoginMsg)
+		}
+		if user.IsDeleted || !user.IsActive {
+			return errors.New("account is deactivated")
+		}
+
+		organizationID, err := loginOrganization(tx, &user, req.OrganizationID)
+		if err != nil {
+			return err
+		}
+		if err := selectOrganization(tx, &user, organizationID); err != nil {
+			return err
+		}
+		if err := s.checkLoginAllowed(&user, models.LoginMethodMagicLink, req.ClientIP); err != nil {
+			return err
+		}
+
+		// Receiving the link or code proves ownership of the address
+		if !user.IsVerified {
+			if err := tx.Model(&user).Update("is_verified", true).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+
+	if user.MFAEnabled {
+		challenge, err := s.CreateMFAChallenge(&user, models.LoginMethodMagicLink)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{Challenge: challenge}
+	}
+
+	return s.issueSession(database.GetDB(), &user, models.LoginMethodMagicLink)
+}
+
+// findLoginUser looks up a user by email the same way Login does
+func (s *AuthService) findLoginUser(email string) (*models.User, error) {
+	var user models.User
+	if err := database.GetDB().Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// emailLoginHash scopes the stored hash to the user so equal codes for different users never collide
+func emailLoginHash(userID uint, secret string) string {
+	return tokens.Hash(fmt.Sprintf("%d:%s", userID, secret))
+}
+
+func newEmailCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+// useTestLimiter gives the test its own login limiter so counters do not leak between tests
+func useTestLimiter(t *testing.T) {
+	t.Helper()
+
+	previous := ratelimit.Logins
+	ratelimit.Logins = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Policy{
+		MaxAccountFailures: 10,
+		MaxIPFailures:      100,
+		LockoutDuration:    15 * time.Minute,
+		Window:             15 * time.Minute,
+	})
+	t.Cleanup(func() { ratelimit.Logins = previous })
+}
+
+// issueTestEmailCode stores a sign-in code for the user the way RequestMagicLink does
+func issueTestEmailCode(t *testing.T, user *models.User, code string) {
+	t.Helper()
+
+	if err := database.GetDB().Model(&models.EmailLoginToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", time.Now()).Error; err != nil {
+		t.Fatalf("failed to retire login codes: %v", err)
+	}
+	if err := database.GetDB().Create(&models.EmailLoginToken{
+		UserID:    user.ID,
+		Mode:      models.EmailLoginModeCode,
+		TokenHash: emailLoginHash(user.ID, code),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}).Error; err != nil {
+		t.Fatalf("failed to create login code: %v", err)
+	}
+}
+
+func TestMagicLinkLoginAppliesTheIPAllowlist(t *testing.T) {
+	setupTestDB(t)
+	useTestLimiter(t)
+	s := NewAuthService(testConfig(t))
+
+	org := createTestOrganization(t, "Acme")
+	user := createTestUser(t, "alice@example.test", org)
+	if err := database.GetDB().Create(&models.OrganizationSettings{OrganizationID: org.ID, IPAllowlist: []string{"10.0.0.0/8"}}).Error; err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+
+	issueTestEmailCode(t, user, "123456")
+	_, err := s.VerifyMagicLink(&models.MagicLinkVerifyRequest{Email: user.Email, Code: "123456", ClientIP: "192.0.2.1"})
+	if err == nil || err.Error() != "access from this ip address is not allowed" {
+		t.Fatalf("expected a login from outside the allowlist to be refused, got %v", err)
+	}
+
+	issueTestEmailCode(t, user, "654321")
+	response, err := s.VerifyMagicLink(&models.MagicLinkVerifyRequest{Email: user.Email, Code: "654321", ClientIP: "10.1.2.3"})
+	if err != nil {
+		t.Fatalf("VerifyMagicLink from inside the allowlist: %v", err)
+	}
+	if response.User.ID != user.ID {
+		t.Errorf("signed in user %d, want %d", response.User.ID, user.ID)
+	}
+}
+
+func TestMagicLinkCodeGuessesCountAcrossCodes(t *testing.T) {
+	setupTestDB(t)
+	useTestLimiter(t)
+	s := NewAuthService(testConfig(t))
+	user := createTestUser(t, "alice@example.test")
+
+	issueTestEmailCode(t, user, "111111")
+	for i := 0; i < 3; i++ {
+		if _, err := s.VerifyMagicLink(&models.MagicLinkVerifyRequest{Email: user.Email, Code: "000000"}); err == nil || err.Error() != errInvalidEmailLoginMsg {
+			t.Fatalf("guess %d: expected a wrong code to be refused, got %v", i+1, err)
+		}
+	}
+
+	// A new code starts with a fresh attempt counter, but the account is still backed off
+	issueTestEmailCode(t, user, "222222")
+	if _, err := s.VerifyMagicLink(&models.MagicLinkVerifyRequest{Email: user.Email, Code: "000000"}); err == nil || err.Error() != errInvalidEmailLoginMsg {
+		t.Fatalf("expected a wrong code to be refused, got %v", err)
+	}
+	var blockedErr *ratelimit.BlockedError
+	if _, err := s.VerifyMagicLink(&models.MagicLinkVerifyRequest{Email: user.Email, Code: "222222"}); !errors.As(err, &blockedErr) {
+		t.Fatalf("expected the account to be backed off after repeated wrong codes, got %v", err)
+	}
+}
+
+func TestMagicLinkRequestsAreThrottled(t *testing.T) {
+	setupTestDB(t)
+	useTestLimiter(t)
+	s := NewAuthService(testConfig(t))
+	user := createTestUser(t, "alice@example.test")
+
+	request := &models.MagicLinkRequest{Email: user.Email, Mode: models.EmailLoginModeCode, ClientIP: "192.0.2.1"}
+	for i := 0; i < 4; i++ {
+		if err := s.RequestMagicLink(request); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	var blockedErr *ratelimit.BlockedError
+	if err := s.RequestMagicLink(request); !errors.As(err, &blockedErr) {
+		t.Fatalf("expected repeated requests to be throttled, got %v", err)
+	}
+
+	var count int64
+	database.GetDB().Model(&models.EmailLoginToken{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 4 {
+		t.Errorf("issued %d codes, want 4", count)
+	}
+
+	// Requesting codes does not lock out a password login
+	if err := ratelimit.Logins.Check(ratelimit.AccountKey(user.Email), ratelimit.IPKey(request.ClientIP)); err != nil {
+		t.Errorf("expected password logins to stay open, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/tokens"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB points the database package at a fresh SQLite database with every model
+// migrated, standing in for Postgres so services can be exercised end to end
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+// testConfig returns the default configuration with HS256 tokens, so no signing keys need to be
+// generated, and cheap password hashing
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := config.Load()
+	cfg.JWT.Algorithm = "HS256"
+	cfg.JWT.Secret = "test-secret"
+	cfg.Security.EncryptionKey = "test-encryption-key"
+	cfg.PasswordHash.Argon2Memory = 64
+	cfg.PasswordHash.Argon2Iterations = 1
+	cfg.PasswordHash.Argon2Parallelism = 1
+
+	if err := tokens.LoadKeys(cfg); err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+	if err := password.Configure(cfg); err != nil {
+		t.Fatalf("failed to configure password hashing: %v", err)
+	}
+	return cfg
+}
+
+func createTestOrganization(t *testing.T, name string) *models.Organization {
+	t.Helper()
+
+	org := &models.Organization{Name: name}
+	if err := database.GetDB().Create(org).Error; err != nil {
+		t.Fatalf("failed to create organization: %v", err)
+	}
+	return org
+}
+
+// createTestUser creates an active, verified user who is a member of the given organizations,
+// the first one being their default
+func createTestUser(t *testing.T, email string, orgs ...*models.Organization) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, Name: email, Password: "!", IsActive: true, IsVerified: true}
+	if len(orgs) > 0 {
+		user.OrganizationID = &orgs[0].ID
+	}
+	if err := database.GetDB().Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	for _, org := range orgs {
+		if err := addMembership(database.GetDB(), org.ID, user.ID, models.OrgRoleMember); err != nil {
+			t.Fatalf("failed to add membership: %v", err)
+		}
+	}
+	return user
+}
+
+func stringPtr(v string) *string {
+	return &v
+}
+
+func mustNotFind(t *testing.T, err error, what string) {
+	t.Helper()
+
+	if err == nil || err.Error() != what+" not found" {
+		t.Fatalf("expected %q, got %v", what+" not found", err)
+	}
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/encryption"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/settings"
+	"kepler-auth-go/internal/tokens"
+	"kepler-auth-go/internal/totp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+	// Crockford-style alphabet without easily confused characters
+	recoveryCodeAlphabet = "ABCDEFGHJKMNPQRSTVWXYZ23456789"
+)
+
+// MFARequiredError is returned by Login when the password was correct but a second factor is still needed
+type MFARequiredError struct {
+	Challenge *models.MFAChallengeResponse
+}
+
+func (e *MFARequiredError) Error() string {
+	return "mfa required"
+}
+
+type MFAService struct {
+	cfg *config.Config
+}
+
+func NewMFAService(cfg *config.Config) *MFAService {
+	return &MFAService{cfg: cfg}
+}
+
+// SetupTOTP starts a TOTP enrollment; it only takes effect once VerifyTOTP succeeds
+func (s *MFAService) SetupTOTP(userID uint) (*models.TOTPSetupResponse, error) {
+	var user models.User
+	if err := database.GetDB().First(&user, userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	if user.MFAEnabled {
+		return nil, errors.New("mfa is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.GetDB().Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    encrypted,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.cfg.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// VerifyTOTP confirms a pending enrollment, enables MFA and returns the initial recovery codes
+func (s *MFAService) VerifyTOTP(userID uint, req *models.TOTPCodeRequest) (*models.RecoveryCodesResponse, error) {
+	var codes []string
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+
+		if user.MFAEnabled {
+			return errors.New("mfa is already enabled")
+		}
+		if user.TOTPSecret == "" {
+			return errors.New("totp setup has not been started")
+		}
+
+		if err := s.checkTOTP(tx, &user, req.Code); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("mfa_enabled", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns MFA off after re-checking the password and a current code
+func (s *MFAService) DisableTOTP(userID uint, req *models.MFADisableRequest) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Preload("Organization.Settings").First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+
+		if !user.MFAEnabled {
+			return errors.New("mfa is not enabled")
+		}
+		if settings.For(s.cfg, user.Organization).RequireMFA {
+			return errors.New("your organization requires mfa")
+		}
+
+		if !password.Verify(req.Password, user.Password) {
+			return errors.New("password is incorrect")
+		}
+
+		if err := s.verifySecondFactor(tx, &user, req.Code); err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"mfa_enabled":    false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error
+	})
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and issues a new set
+func (s *MFAService) RegenerateRecoveryCodes(userID uint, req *models.TOTPCodeRequest) (*models.RecoveryCodesResponse, error) {
+	var codes []string
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+
+		if !user.MFAEnabled {
+			return errors.New("mfa is not enabled")
+		}
+
+		if err := s.checkTOTP(tx, &user, req.Code); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code
+func (s *MFAService) verifySecondFactor(tx *gorm.DB, user *models.User, code string) error {
+	if len(strings.TrimSpace(code)) == totp.Digits {
+		return s.checkTOTP(tx, user, code)
+	}
+	return useRecoveryCode(tx, user.ID, code)
+}
+
+// checkTOTP validates a code and records its time step so it cannot be replayed
+func (s *MFAService) checkTOTP(tx *gorm.DB, user *models.User, code string) error {
+	secret, err := s.decrypt(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return errors.New("invalid authentication code")
+	}
+
+	// Conditional update so two concurrent requests cannot both consume the same step
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid authentication code")
+	}
+
+	user.TOTPLastStep = step
+	return nil
+}
+
+func (s *MFAService) encrypt(value string) (string, error) {
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Encrypt(value)
+}
+
+func (s *MFAService) decrypt(value string) (string, error) {
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Decrypt(value)
+}
+
+// CompleteMFALogin exchanges an MFA challenge plus a second factor for a full login response
+func (s *AuthService) CompleteMFALogin(req *models.MFALoginRequest) (*models.LoginResponse, error) {
+	code := req.Code
+	if code == "" {
+		code = req.RecoveryCode
+	}
+
+	var response *models.LoginResponse
+	err := s.completeMFAChallenge(req.MFAToken, code, func(tx *gorm.DB, user *models.User, loginMethod string) error {
+		var err error
+		response, err = s.issueSession(tx, user, loginMethod)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// VerifyMFAChallenge completes an MFA challenge without issuing a session, for logins that hand
+// out something else such as an authorization code
+func (s *AuthService) VerifyMFAChallenge(token, code string) (*models.User, error) {
+	var verified *models.User
+	err := s.completeMFAChallenge(token, code, func(tx *gorm.DB, user *models.User, loginMethod string) error {
+		verified = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return verified, nil
+}
+
+// completeMFAChallenge checks the second factor against the challenge, counting failed attempts,
+// and runs complete for the user once it is accepted
+func (s *AuthService) completeMFAChallenge(token, code string, complete func(tx *gorm.DB, user *models.User, loginMethod string) error) error {
+	if code == "" {
+		return errors.New("code or recovery_code is required")
+	}
+
+	var verifyErr error
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var challenge models.MFAChallenge
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", tokens.Hash(token)).
+			First(&challenge).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("invalid or expired mfa token")
+			}
+			return err
+		}
+
+		if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+			return errors.New("invalid or expired mfa token")
+		}
+		if challenge.Attempts >= mfaChallengeMaxAttempts {
+			return errors.New("too many failed attempts, please log in again")
+		}
+
+		var user models.User
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, challenge.UserID).Error; err != nil {
+			return errors.New("invalid or expired mfa token")
+		}
+		if user.IsDeleted || !user.IsActive {
+			return errors.New("account is deactivated")
+		}
+
+		if verifyErr = s.mfaService.verifySecondFactor(tx, &user, code); verifyErr != nil {
+			// Commit the attempt counter instead of rolling it back with the error
+			return tx.Model(&challenge).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		if err := tx.Model(&challenge).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return complete(tx, &user, challenge.LoginMethod)
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// CreateMFAChallenge starts the second factor step of a login made with the given method
+func (s *AuthService) CreateMFAChallenge(user *models.User, loginMethod string) (*models.MFAChallengeResponse, error) {
+	raw, hash, err := tokens.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &models.MFAChallenge{
+		UserID:      user.ID,
+		TokenHash:   hash,
+		LoginMethod: loginMethod,
+		ExpiresAt:   time.Now().Add(mfaChallengeTTL),
+	}
+
+	if err := database.GetDB().Create(challenge).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    raw,
+		Methods:     []string{"totp", "recovery_code"},
+		ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = models.MFARecoveryCode{UserID: userID, CodeHash: tokens.Hash(normalizeRecoveryCode(code))}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func useRecoveryCode(tx *gorm.DB, userID uint, code string) error {
+	result := tx.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, tokens.Hash(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid authentication code")
+	}
+	return nil
+}
+
+// newRecoveryCode returns a code formatted as XXXXX-XXXXX
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, 0, 11)
+	for i, v := range b {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/totp"
+	"testing"
+	"time"
+)
+
+// enableTestTOTP turns on MFA for the user with a fresh secret and returns it
+func enableTestTOTP(t *testing.T, s *AuthService, user *models.User) string {
+	t.Helper()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("failed to generate totp secret: %v", err)
+	}
+	encrypted, err := s.mfaService.encrypt(secret)
+	if err != nil {
+		t.Fatalf("failed to encrypt totp secret: %v", err)
+	}
+	if err := database.GetDB().Model(user).Updates(map[string]interface{}{"mfa_enabled": true, "totp_secret": encrypted}).Error; err != nil {
+		t.Fatalf("failed to enable mfa: %v", err)
+	}
+	return secret
+}
+
+func TestMFAChallengeLimitsAttemptsWithoutIssuingASession(t *testing.T) {
+	setupTestDB(t)
+	s := NewAuthService(testConfig(t))
+	user := createTestUser(t, "alice@example.test")
+	secret := enableTestTOTP(t, s, user)
+
+	challenge, err := s.CreateMFAChallenge(user, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatalf("CreateMFAChallenge: %v", err)
+	}
+	for i := 0; i < mfaChallengeMaxAttempts; i++ {
+		if _, err := s.VerifyMFAChallenge(challenge.MFAToken, "not-a-recovery-code"); err == nil {
+			t.Fatalf("attempt %d: expected a wrong code to be refused", i+1)
+		}
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("failed to compute totp code: %v", err)
+	}
+	if _, err := s.VerifyMFAChallenge(challenge.MFAToken, code); err == nil || err.Error() != "too many failed attempts, please log in again" {
+		t.Fatalf("expected the challenge to be closed after too many attempts, got %v", err)
+	}
+
+	challenge, err = s.CreateMFAChallenge(user, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatalf("CreateMFAChallenge: %v", err)
+	}
+	verified, err := s.VerifyMFAChallenge(challenge.MFAToken, code)
+	if err != nil {
+		t.Fatalf("VerifyMFAChallenge: %v", err)
+	}
+	if verified.ID != user.ID {
+		t.Errorf("verified user %d, want %d", verified.ID, user.ID)
+	}
+
+	var sessions int64
+	database.GetDB().Model(&models.RefreshToken{}).Where("user_id = ?", user.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("verifying the challenge issued %d sessions", sessions)
+	}
+	if _, err := s.VerifyMFAChallenge(challenge.MFAToken, code); err == nil || err.Error() != "invalid or expired mfa token" {
+		t.Errorf("expected the used challenge to be refused, got %v", err)
+	}
+}
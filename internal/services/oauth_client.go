@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"math"
+	"slices"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var supportedGrantTypes = []string{
+	models.GrantAuthorizationCode,
+	models.GrantRefreshToken,
+	models.GrantClientCredentials,
+}
+
+type OAuthClientService struct{}
+
+func NewOAuthClientService() *OAuthClientService {
+	return &OAuthClientService{}
+}
+
+func (s *OAuthClientService) GetClients(query *models.PaginationQuery, organizationID *uint) (*models.PaginatedOAuthClientResponse, error) {
+	var clients []models.OAuthClient
+	var total int64
+
+	db := database.GetDB().Model(&models.OAuthClient{})
+
+	// Filter by organization
+	if organizationID != nil {
+		db = db.Where("organization_id = ?", *organizationID)
+	}
+
+	if query.Search != "" {
+		db = db.Where("name ILIKE ? OR client_id ILIKE ?", "%"+query.Search+"%", "%"+query.Search+"%")
+	}
+
+	if query.IsActive != nil {
+		db = db.Where("is_active = ?", *query.IsActive)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	offset := (query.Page - 1) * query.PageSize
+	if err := db.Offset(offset).Limit(query.PageSize).Find(&clients).Error; err != nil {
+		return nil, err
+	}
+
+	totalPages := int(math.Ceil(float64(total) / float64(query.PageSize)))
+
+	return &models.PaginatedOAuthClientResponse{
+		Data:       clients,
+		Total:      int(total),
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalPages: totalPages,
+	}, nil
+}
+
+func (s *OAuthClientService) GetClientByID(id uint, organizationID *uint) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	db := database.GetDB()
+
+	// Filter by organization if provided
+	if organizationID != nil {
+		db = db.Where("organization_id = ?", *organizationID)
+	}
+
+	if err := db.First(&client, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("oauth client not found")
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+// CreateClient registers a client; confidential clients get a secret that is only returned here
+func (s *OAuthClientService) CreateClient(req *models.OAuthClientRequest, organizationID *uint) (*models.OAuthClientSecretResponse, error) {
+	// Org scoped admins can only register clients for their own organization
+	if organizationID != nil {
+		req.OrganizationID = organizationID
+	}
+
+	if req.OrganizationID != nil {
+		var org models.Organization
+		if err := database.GetDB().First(&org, *req.OrganizationID).Error; err != nil {
+			return nil, errors.New("organization not found")
+		}
+	}
+
+	if err := validateClientGrants(req.GrantTypes, req.IsConfidential, req.RedirectURIs); err != nil {
+		return nil, err
+	}
+
+	clientID, err := tokens.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.OAuthClient{
+		ClientID:       clientID,
+		Name:           req.Name,
+		IsConfidential: req.IsConfidential,
+		RedirectURIs:   req.RedirectURIs,
+		GrantTypes:     req.GrantTypes,
+		AllowedScopes:  req.AllowedScopes,
+		OrganizationID: req.OrganizationID,
+		IsActive:       true,
+	}
+
+	var secret string
+	if client.IsConfidential {
+		if secret, client.ClientSecretHash, err = newClientSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := database.GetDB().Create(client).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthClientSecretResponse{Client: client, ClientSecret: secret}, nil
+}
+
+func (s *OAuthClientService) UpdateClient(id uint, req *models.OAuthClientUpdateRequest, organizationID *uint) (*models.OAuthClient, error) {
+	client, err := s.GetClientByID(id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		client.Name = *req.Name
+	}
+	if req.RedirectURIs != nil {
+		client.RedirectURIs = req.RedirectURIs
+	}
+	if req.GrantTypes != nil {
+		client.GrantTypes = req.GrantTypes
+	}
+	if req.AllowedScopes != nil {
+		client.AllowedScopes = req.AllowedScopes
+	}
+	if req.IsActive != nil {
+		client.IsActive = *req.IsActive
+	}
+
+	if err := validateClientGrants(client.GrantTypes, client.IsConfidential, client.RedirectURIs); err != nil {
+		return nil, err
+	}
+
+	if err := database.GetDB().Save(client).Error; err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// RotateSecret replaces a confidential client's secret; the old secret stops working immediately
+func (s *OAuthClientService) RotateSecret(id uint, organizationID *uint) (*models.OAuthClientSecretResponse, error) {
+	client, err := s.GetClientByID(id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsConfidential {
+		return nil, errors.New("public clients have no secret")
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.GetDB().Model(client).Update("client_secret_hash", hash).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthClientSecretResponse{Client: client, ClientSecret: secret}, nil
+}
+
+func (s *OAuthClientService) DeleteClient(id uint, organizationID *uint) error {
+	client, err := s.GetClientByID(id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	return database.GetDB().Delete(client).Error
+}
+
+// AuthenticateClient resolves an active client by its credentials.
+// Public clients authenticate with their client_id alone.
+func (s *OAuthClientService) AuthenticateClient(clientID, clientSecret string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := database.GetDB().Where("client_id = ? AND is_active = ?", clientID, true).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &models.OAuthError{Code: "invalid_client", Description: "client authentication failed"}
+		}
+		return nil, err
+	}
+
+	if client.IsConfidential {
+		if clientSecret == "" || bcrypt.CompareHashAndPassword([]byte(client.ClientSecretHash), []byte(clientSecret)) != nil {
+			return nil, &models.OAuthError{Code: "invalid_client", Description: "client authentication failed"}
+		}
+	}
+
+	return &client, nil
+}
+
+func validateClientGrants(grantTypes []string, confidential bool, redirectURIs []string) error {
+	for _, grantType := range grantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return fmt.Errorf("unsupported grant type: %s", grantType)
+		}
+	}
+
+	if slices.Contains(grantTypes, models.GrantClientCredentials) && !confidential {
+		return errors.New("client_credentials requires a confidential client")
+	}
+
+	interactive := len(grantTypes) == 0 || slices.Contains(grantTypes, models.GrantAuthorizationCode)
+	if interactive && len(redirectURIs) == 0 {
+		return errors.New("authorization_code clients need at least one redirect URI")
+	}
+
+	return nil
+}
+
+func newClientSecret() (string, string, error) {
+	secret, _, err := tokens.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, string(hash), nil
+}
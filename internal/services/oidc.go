@@ -0,0 +1,367 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/middleware"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const authorizationCodeTTL = 5 * time.Minute
+
+var supportedScopes = []string{"openid", "profile", "email", "phone"}
+
+// idTokenClaims are the OpenID Connect ID token claims derived from models.User
+type idTokenClaims struct {
+	Nonce          string `json:"nonce,omitempty"`
+	AuthTime       int64  `json:"auth_time,omitempty"`
+	OrganizationID *uint  `json:"organization_id,omitempty"`
+	userInfoClaims
+	jwt.RegisteredClaims
+}
+
+type userInfoClaims struct {
+	Name                string  `json:"name,omitempty"`
+	Picture             *string `json:"picture,omitempty"`
+	Email               string  `json:"email,omitempty"`
+	EmailVerified       *bool   `json:"email_verified,omitempty"`
+	PhoneNumber         *string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool   `json:"phone_number_verified,omitempty"`
+}
+
+type OIDCService struct {
+	cfg           *config.Config
+	authService   *AuthService
+	clientService *OAuthClientService
+}
+
+func NewOIDCService(cfg *config.Config) *OIDCService {
+	return &OIDCService{
+		cfg:           cfg,
+		authService:   NewAuthService(cfg),
+		clientService: NewOAuthClientService(),
+	}
+}
+
+// Discovery returns the OpenID provider metadata
+func (s *OIDCService) Discovery() *models.OpenIDConfiguration {
+	issuer := strings.TrimSuffix(s.cfg.OIDC.Issuer, "/")
+
+	return &models.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.cfg.JWT.Algorithm},
+		ScopesSupported:                   supportedScopes,
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "picture", "email", "email_verified", "phone_number", "phone_number_verified", "organization_id"},
+		GrantTypesSupported:               supportedGrantTypes,
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}
+}
+
+// GetClientForRedirect resolves the client and checks the redirect URI.
+// Failures here must be shown to the user instead of redirected, since the target is untrusted.
+func (s *OIDCService) GetClientForRedirect(clientID, redirectURI string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := database.GetDB().Where("client_id = ? AND is_active = ?", clientID, true).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &models.OAuthError{Code: "invalid_request", Description: "unknown client_id"}
+		}
+		return nil, err
+	}
+
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, &models.OAuthError{Code: "unauthorized_client", Description: "client may not use the authorization code flow"}
+	}
+
+	if !client.HasRedirectURI(redirectURI) {
+		return nil, &models.OAuthError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+
+	return &client, nil
+}
+
+// ValidateAuthorizeRequest checks the parameters whose errors can be returned to the client's redirect URI
+func (s *OIDCService) ValidateAuthorizeRequest(req *models.AuthorizeRequest) error {
+	if req.ResponseType != "code" {
+		return &models.OAuthError{Code: "unsupported_response_type", Description: "only response_type=code is supported"}
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if !slices.Contains(scopes, "openid") {
+		return &models.OAuthError{Code: "invalid_scope", Description: "the openid scope is required"}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(supportedScopes, scope) {
+			return &models.OAuthError{Code: "invalid_scope", Description: "unsupported scope: " + scope}
+		}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return &models.OAuthError{Code: "invalid_request", Description: "PKCE with code_challenge_method=S256 is required"}
+	}
+
+	return nil
+}
+
+// CreateAuthorizationCode issues a single-use code for an authenticated user
+func (s *OIDCService) CreateAuthorizationCode(client *models.OAuthClient, user *models.User, req *models.AuthorizeRequest) (string, error) {
+	raw, hash, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	code := &models.AuthorizationCode{
+		CodeHash:            hash,
+		ClientID:            client.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authorizationCodeTTL),
+	}
+
+	if err := database.GetDB().Create(code).Error; err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// ExchangeCode redeems an authorization code for ID, access and refresh tokens
+func (s *OIDCService) ExchangeCode(req *models.TokenRequest) (*models.TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" || req.ClientID == "" {
+		return nil, &models.OAuthError{Code: "invalid_request", Description: "code, client_id and code_verifier are required"}
+	}
+
+	if _, err := s.clientService.AuthenticateClient(req.ClientID, req.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	var response *models.TokenResponse
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var code models.AuthorizationCode
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ?", tokens.Hash(req.Code)).
+			First(&code).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &models.OAuthError{Code: "invalid_grant", Description: "invalid authorization code"}
+			}
+			return err
+		}
+
+		if code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+			return &models.OAuthError{Code: "invalid_grant", Description: "authorization code expired or already used"}
+		}
+		if code.ClientID != req.ClientID || code.RedirectURI != req.RedirectURI {
+			return &models.OAuthError{Code: "invalid_grant", Description: "client_id or redirect_uri mismatch"}
+		}
+		if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+			return &models.OAuthError{Code: "invalid_grant", Description: "code_verifier does not match code_challenge"}
+		}
+
+		if err := tx.Model(&code).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, code.UserID).Error; err != nil {
+			return &models.OAuthError{Code: "invalid_grant", Description: "user not found"}
+		}
+		if user.IsDeleted || !user.IsActive {
+			return &models.OAuthError{Code: "invalid_grant", Description: "account is deactivated"}
+		}
+
+		// The authorization endpoint signs users in with their password
+		session, err := s.authService.issueSession(tx, &user, models.LoginMethodPassword)
+		if err != nil {
+			return err
+		}
+
+		idToken, err := s.generateIDToken(&user, &code)
+		if err != nil {
+			return err
+		}
+
+		response = &models.TokenResponse{
+			AccessToken:  session.Token,
+			TokenType:    "Bearer",
+			ExpiresIn:    session.ExpiresIn,
+			RefreshToken: session.RefreshToken,
+			IDToken:      idToken,
+			Scope:        code.Scope,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// RefreshToken implements the refresh_token grant on top of AuthService.Refresh
+func (s *OIDCService) RefreshToken(req *models.TokenRequest) (*models.TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, &models.OAuthError{Code: "invalid_request", Description: "refresh_token is required"}
+	}
+
+	session, err := s.authService.Refresh(&models.RefreshTokenRequest{RefreshToken: req.RefreshToken})
+	if err != nil {
+		return nil, &models.OAuthError{Code: "invalid_grant", Description: err.Error()}
+	}
+
+	return &models.TokenResponse{
+		AccessToken:  session.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    session.ExpiresIn,
+		RefreshToken: session.RefreshToken,
+	}, nil
+}
+
+// ClientCredentials mints a machine access token for a confidential client
+func (s *OIDCService) ClientCredentials(req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := s.clientService.AuthenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsConfidential || !client.AllowsGrant(models.GrantClientCredentials) {
+		return nil, &models.OAuthError{Code: "unauthorized_client", Description: "client may not use the client_credentials grant"}
+	}
+
+	// Default to every allowed scope; a request may only narrow it
+	scopes := client.AllowedScopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !slices.Contains(client.AllowedScopes, scope) {
+				return nil, &models.OAuthError{Code: "invalid_scope", Description: "scope not allowed for this client: " + scope}
+			}
+		}
+	}
+	scope := strings.Join(scopes, " ")
+
+	jti, err := tokens.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := &middleware.Claims{
+		ClientID:       client.ClientID,
+		Scope:          scope,
+		OrganizationID: client.OrganizationID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   "client:" + client.ClientID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(s.cfg.JWT.Expiration) * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	accessToken, err := tokens.GetKeyManager().Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   s.cfg.JWT.Expiration,
+		Scope:       scope,
+	}, nil
+}
+
+// UserInfo returns the standard claims for the user, keyed by OIDC claim name
+func (s *OIDCService) UserInfo(user *models.User) map[string]interface{} {
+	info := map[string]interface{}{
+		"sub":            strconv.FormatUint(uint64(user.ID), 10),
+		"name":           user.Name,
+		"email":          user.Email,
+		"email_verified": user.IsVerified,
+	}
+	if user.ProfilePicture != nil {
+		info["picture"] = *user.ProfilePicture
+	}
+	if user.PhoneNumber != nil {
+		info["phone_number"] = *user.PhoneNumber
+		info["phone_number_verified"] = false
+	}
+	if user.OrganizationID != nil {
+		info["organization_id"] = *user.OrganizationID
+	}
+
+	return info
+}
+
+func (s *OIDCService) generateIDToken(user *models.User, code *models.AuthorizationCode) (string, error) {
+	now := time.Now()
+	claims := &idTokenClaims{
+		Nonce:          code.Nonce,
+		AuthTime:       code.AuthTime.Unix(),
+		OrganizationID: user.OrganizationID,
+		userInfoClaims: s.userInfoClaims(user, strings.Fields(code.Scope)),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    strings.TrimSuffix(s.cfg.OIDC.Issuer, "/"),
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{code.ClientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(s.cfg.JWT.Expiration) * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return tokens.GetKeyManager().Sign(claims)
+}
+
+func (s *OIDCService) userInfoClaims(user *models.User, scopes []string) userInfoClaims {
+	var claims userInfoClaims
+
+	if slices.Contains(scopes, "profile") {
+		claims.Name = user.Name
+		claims.Picture = user.ProfilePicture
+	}
+	if slices.Contains(scopes, "email") {
+		verified := user.IsVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	if slices.Contains(scopes, "phone") && user.PhoneNumber != nil {
+		verified := false
+		claims.PhoneNumber = user.PhoneNumber
+		claims.PhoneNumberVerified = &verified
+	}
+
+	return claims
+}
+
+// verifyPKCE checks an RFC 7636 S256 code_verifier against the stored challenge
+func verifyPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -16,12 +16,17 @@ func NewOrganizationService() *OrganizationService {
 	return &OrganizationService{}
 }
 
-func (s *OrganizationService) GetOrganizations(query *models.PaginationQuery) (*models.PaginatedResponse[models.OrganizationResponse], error) {
+// GetOrganizations lists organizations, limited to the caller's own when organizationID is set
+func (s *OrganizationService) GetOrganizations(query *models.PaginationQuery, organizationID *uint) (*models.PaginatedResponse[models.OrganizationResponse], error) {
 	var organizations []models.Organization
 	var total int64
 
 	db := database.GetDB().Model(&models.Organization{})
 
+	if organizationID != nil {
+		db = db.Where("id = ?", *organizationID)
+	}
+
 	if query.Search != "" {
 		db = db.Where("name ILIKE ? OR domain ILIKE ?",
 			"%"+query.Search+"%", "%"+query.Search+"%")
@@ -52,16 +57,13 @@ func (s *OrganizationService) GetOrganizations(query *models.PaginationQuery) (*
 	}, nil
 }
 
-func (s *OrganizationService) GetOrganizationByID(id uint) (*models.OrganizationResponse, error) {
-	var organization models.Organization
-	if err := database.GetDB().First(&organization, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("organization not found")
-		}
+func (s *OrganizationService) GetOrganizationByID(id uint, organizationID *uint) (*models.OrganizationResponse, error) {
+	organization, err := findScopedOrganization(database.GetDB(), id, organizationID)
+	if err != nil {
 		return nil, err
 	}
 
-	response := s.toOrganizationResponse(&organization)
+	response := s.toOrganizationResponse(organization)
 	return &response, nil
 }
 
@@ -72,6 +74,11 @@ func (s *OrganizationService) CreateOrganization(req *models.OrganizationCreateR
 		return nil, errors.New("organization with this name already exists")
 	}
 
+	if req.Domain != nil {
+		domain := normalizeDomain(*req.Domain)
+		req.Domain = &domain
+	}
+
 	organization := &models.Organization{
 		Name:   req.Name,
 		Domain: req.Domain,
@@ -85,12 +92,9 @@ func (s *OrganizationService) CreateOrganization(req *models.OrganizationCreateR
 	return &response, nil
 }
 
-func (s *OrganizationService) UpdateOrganization(id uint, req *models.OrganizationUpdateRequest) (*models.OrganizationResponse, error) {
-	var organization models.Organization
-	if err := database.GetDB().First(&organization, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("organization not found")
-		}
+func (s *OrganizationService) UpdateOrganization(id uint, req *models.OrganizationUpdateRequest, organizationID *uint) (*models.OrganizationResponse, error) {
+	organization, err := findScopedOrganization(database.GetDB(), id, organizationID)
+	if err != nil {
 		return nil, err
 	}
 
@@ -104,18 +108,24 @@ func (s *OrganizationService) UpdateOrganization(id uint, req *models.Organizati
 		updates["name"] = *req.Name
 	}
 	if req.Domain != nil {
-		updates["domain"] = *req.Domain
+		domain := normalizeDomain(*req.Domain)
+		if organization.Domain == nil || *organization.Domain != domain {
+			// A new domain has to be proven again with a new challenge
+			updates["domain"] = domain
+			updates["domain_verification_token"] = ""
+			updates["domain_verified_at"] = nil
+		}
 	}
 
-	if err := database.GetDB().Model(&organization).Updates(updates).Error; err != nil {
+	if err := database.GetDB().Model(organization).Updates(updates).Error; err != nil {
 		return nil, err
 	}
 
-	if err := database.GetDB().First(&organization, id).Error; err != nil {
+	if err := database.GetDB().First(organization, id).Error; err != nil {
 		return nil, err
 	}
 
-	response := s.toOrganizationResponse(&organization)
+	response := s.toOrganizationResponse(organization)
 	return &response, nil
 }
 
@@ -130,7 +140,7 @@ func (s *OrganizationService) DeleteOrganization(id uint) error {
 
 	// Check if organization has users
 	var userCount int64
-	if err := database.GetDB().Model(&models.User{}).Where("organization_id = ?", id).Count(&userCount).Error; err != nil {
+	if err := database.GetDB().Model(&models.OrganizationMember{}).Where("organization_id = ?", id).Count(&userCount).Error; err != nil {
 		return err
 	}
 
@@ -138,15 +148,55 @@ func (s *OrganizationService) DeleteOrganization(id uint) error {
 		return errors.New("cannot delete organization with existing users")
 	}
 
-	return database.GetDB().Delete(&organization).Error
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", id).Delete(&models.OrganizationSettings{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("connection_id IN (?)", tx.Model(&models.SAMLConnection{}).Select("id").Where("organization_id = ?", id)).
+			Delete(&models.SAMLSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.SAMLConnection{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("provider_id IN (?)", tx.Model(&models.IdentityProvider{}).Select("id").Where("organization_id = ?", id)).
+			Delete(&models.FederationLogin{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("provider_id IN (?)", tx.Model(&models.IdentityProvider{}).Select("id").Where("organization_id = ?", id)).
+			Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.IdentityProvider{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&organization).Error
+	})
+}
+
+// findScopedOrganization loads an organization, treating one outside the caller's organization as missing
+func findScopedOrganization(tx *gorm.DB, id uint, organizationID *uint) (*models.Organization, error) {
+	if organizationID != nil && *organizationID != id {
+		return nil, errors.New("organization not found")
+	}
+
+	var organization models.Organization
+	if err := tx.First(&organization, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("organization not found")
+		}
+		return nil, err
+	}
+	return &organization, nil
 }
 
 func (s *OrganizationService) toOrganizationResponse(org *models.Organization) models.OrganizationResponse {
 	return models.OrganizationResponse{
-		ID:        org.ID,
-		Name:      org.Name,
-		Domain:    org.Domain,
-		CreatedAt: org.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: org.UpdatedAt.Format(time.RFC3339),
+		ID:             org.ID,
+		Name:           org.Name,
+		Domain:         org.Domain,
+		DomainVerified: org.HasVerifiedDomain(),
+		CreatedAt:      org.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      org.UpdatedAt.Format(time.RFC3339),
 	}
 }
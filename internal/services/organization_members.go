@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetMembers lists the members of an organization with their roles
+func (s *OrganizationService) GetMembers(id uint, query *models.PaginationQuery, organizationID *uint) (*models.PaginatedResponse[models.OrganizationMemberResponse], error) {
+	if _, err := findScopedOrganization(database.GetDB(), id, organizationID); err != nil {
+		return nil, err
+	}
+
+	var members []models.OrganizationMember
+	var total int64
+
+	db := database.GetDB().Model(&models.OrganizationMember{}).
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", id)
+
+	if query.Search != "" {
+		db = db.Where("users.name ILIKE ? OR users.email ILIKE ?", "%"+query.Search+"%", "%"+query.Search+"%")
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	offset := (query.Page - 1) * query.PageSize
+	if err := db.Preload("User").Order("organization_members.id").
+		Offset(offset).Limit(query.PageSize).Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	memberResponses := make([]models.OrganizationMemberResponse, len(members))
+	for i, member := range members {
+		memberResponses[i] = toOrganizationMemberResponse(&member)
+	}
+
+	totalPages := int(math.Ceil(float64(total) / float64(query.PageSize)))
+
+	return &models.PaginatedResponse[models.OrganizationMemberResponse]{
+		Data:       memberResponses,
+		Total:      int(total),
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// SetMemberRole changes a member's role. Only owners and superusers may grant or revoke the
+// owner role, and an organization always keeps at least one owner once it has one.
+func (s *OrganizationService) SetMemberRole(id, userID uint, role string, actor *models.User, organizationID *uint) (*models.OrganizationMemberResponse, error) {
+	var member models.OrganizationMember
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if _, err := findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("User").
+			Where("organization_id = ? AND user_id = ?", id, userID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("member not found")
+			}
+			return err
+		}
+
+		if member.Role == role {
+			return nil
+		}
+
+		if member.Role == models.OrgRoleOwner || role == models.OrgRoleOwner {
+			if err := checkOwnerChange(tx, id, actor); err != nil {
+				return err
+			}
+		}
+
+		if member.Role == models.OrgRoleOwner {
+			var owners int64
+			if err := tx.Model(&models.OrganizationMember{}).
+				Where("organization_id = ? AND role = ?", id, models.OrgRoleOwner).Count(&owners).Error; err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errors.New("organization must keep at least one owner")
+			}
+		}
+
+		previous := member.Role
+		if err := tx.Model(&member).Update("role", role).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditOrgMemberRole, "user", userID, map[string]interface{}{
+			"from": previous,
+			"to":   role,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := toOrganizationMemberResponse(&member)
+	return &response, nil
+}
+
+// checkOwnerChange allows granting or revoking the owner role only to owners and superusers
+func checkOwnerChange(tx *gorm.DB, organizationID uint, actor *models.User) error {
+	if actor.IsSuperuser {
+		return nil
+	}
+
+	var actorMember models.OrganizationMember
+	if err := tx.Where("organization_id = ? AND user_id = ?", organizationID, actor.ID).First(&actorMember).Error; err != nil || actorMember.Role != models.OrgRoleOwner {
+		return errors.New("only owners can grant or revoke the owner role")
+	}
+	return nil
+}
+
+// addMembership makes the user a member of the organization, keeping an existing role
+func addMembership(tx *gorm.DB, organizationID, userID uint, role string) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.OrganizationMember{
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+	}).Error
+}
+
+// removeMembership drops the user's membership of the organization
+func removeMembership(tx *gorm.DB, organizationID, userID uint) error {
+	return tx.Where("organization_id = ? AND user_id = ?", organizationID, userID).
+		Delete(&models.OrganizationMember{}).Error
+}
+
+func toOrganizationMemberResponse(member *models.OrganizationMember) models.OrganizationMemberResponse {
+	response := models.OrganizationMemberResponse{
+		UserID:    member.UserID,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt.Format(time.RFC3339),
+	}
+	if member.User != nil {
+		response.Email = member.User.Email
+		response.Name = member.User.Name
+	}
+	return response
+}
+
+// scopeToOrganization makes the loaded user act within one of their organizations for the
+// session being issued. A nil organizationID means no organization.
+func scopeToOrganization(tx *gorm.DB, user *models.User, organizationID *uint) error {
+	if organizationID == nil {
+		user.SetActiveOrganization(nil)
+		return nil
+	}
+
+	if !user.IsSuperuser {
+		var member models.OrganizationMember
+		if err := tx.Where("organization_id = ? AND user_id = ?", *organizationID, user.ID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("not a member of this organization")
+			}
+			return err
+		}
+	}
+
+	var org models.Organization
+	if err := tx.Preload("Settings").First(&org, *organizationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("not a member of this organization")
+		}
+		return err
+	}
+
+	user.SetActiveOrganization(&org)
+	return nil
+}
+
+// selectOrganization signs the user into one of their organizations and remembers it as the
+// default for later logins. A nil organizationID keeps the current default.
+func selectOrganization(tx *gorm.DB, user *models.User, organizationID *uint) error {
+	if organizationID == nil || (user.OrganizationID != nil && *user.OrganizationID == *organizationID) {
+		return nil
+	}
+
+	if err := scopeToOrganization(tx, user, organizationID); err != nil {
+		return err
+	}
+
+	return tx.Model(&models.User{}).Where("id = ?", user.ID).Update("organization_id", *organizationID).Error
+}
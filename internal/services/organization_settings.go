@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/settings"
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OrganizationSettingsService struct {
+	cfg *config.Config
+}
+
+func NewOrganizationSettingsService(cfg *config.Config) *OrganizationSettingsService {
+	return &OrganizationSettingsService{cfg: cfg}
+}
+
+// GetSettings returns an organization's overrides and the settings in effect for its users
+func (s *OrganizationSettingsService) GetSettings(id uint, organizationID *uint) (*models.OrganizationSettingsResponse, error) {
+	organization, err := findScopedOrganization(database.GetDB().Preload("Settings"), id, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSettingsResponse(organization), nil
+}
+
+// UpdateSettings changes an organization's overrides. Fields left out of the request keep their
+// value, fields named in Reset are removed so the global value applies again.
+func (s *OrganizationSettingsService) UpdateSettings(id uint, req *models.OrganizationSettingsRequest, actor *models.User, organizationID *uint) (*models.OrganizationSettingsResponse, error) {
+	if req.IPAllowlist != nil {
+		for _, entry := range *req.IPAllowlist {
+			if !validIPAllowlistEntry(entry) {
+				return nil, fmt.Errorf("invalid ip_allowlist entry %q", entry)
+			}
+		}
+	}
+
+	if req.AccessTokenLifetime != nil && *req.AccessTokenLifetime > s.cfg.JWT.MaxAccessTokenLifetime() {
+		return nil, fmt.Errorf("access_token_lifetime exceeds the maximum of %d seconds", s.cfg.JWT.MaxAccessTokenLifetime())
+	}
+
+	var organization *models.Organization
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if organization, err = findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		overrides := models.OrganizationSettings{OrganizationID: id}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("organization_id = ?", id).FirstOrCreate(&overrides).Error; err != nil {
+			return err
+		}
+
+		if req.AccessTokenLifetime != nil {
+			overrides.AccessTokenLifetime = req.AccessTokenLifetime
+		}
+		if req.RefreshTokenLifetime != nil {
+			overrides.RefreshTokenLifetime = req.RefreshTokenLifetime
+		}
+		if req.SessionIdleTimeout != nil {
+			overrides.SessionIdleTimeout = req.SessionIdleTimeout
+		}
+		if req.RequireMFA != nil {
+			overrides.RequireMFA = req.RequireMFA
+		}
+		if req.RequireEmailVerification != nil {
+			overrides.RequireEmailVerification = req.RequireEmailVerification
+		}
+		if req.PasswordPolicy != nil {
+			overrides.PasswordPolicy = req.PasswordPolicy
+		}
+		if req.AllowedLoginMethods != nil {
+			overrides.AllowedLoginMethods = *req.AllowedLoginMethods
+		}
+		if req.IPAllowlist != nil {
+			overrides.IPAllowlist = append([]string{}, *req.IPAllowlist...)
+		}
+		if req.AllowSelfRegistration != nil {
+			overrides.AllowSelfRegistration = req.AllowSelfRegistration
+		}
+
+		for _, field := range req.Reset {
+			switch field {
+			case "access_token_lifetime":
+				overrides.AccessTokenLifetime = nil
+			case "refresh_token_lifetime":
+				overrides.RefreshTokenLifetime = nil
+			case "session_idle_timeout":
+				overrides.SessionIdleTimeout = nil
+			case "require_mfa":
+				overrides.RequireMFA = nil
+			case "require_email_verification":
+				overrides.RequireEmailVerification = nil
+			case "password_policy":
+				overrides.PasswordPolicy = nil
+			case "allowed_login_methods":
+				overrides.AllowedLoginMethods = nil
+			case "ip_allowlist":
+				overrides.IPAllowlist = nil
+			case "allow_self_registration":
+				overrides.AllowSelfRegistration = nil
+			}
+		}
+
+		// Save writes every column, so cleared overrides are stored as NULL
+		if err := tx.Save(&overrides).Error; err != nil {
+			return err
+		}
+		organization.Settings = &overrides
+
+		return recordAudit(tx, actor.ID, &id, models.AuditOrgSettingsUpdate, "organization", id,
+			map[string]interface{}{"settings": overrides})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toSettingsResponse(organization), nil
+}
+
+// settingsFor resolves the settings in effect within an organization, or globally for nil
+func (s *AuthService) settingsFor(org *models.Organization) models.EffectiveSettings {
+	return settings.For(s.cfg, org)
+}
+
+// checkLoginAllowed applies the login method and IP address restrictions in effect for the
+// organization being signed into. Superusers are exempt so they can always recover access.
+// An empty clientIP skips the address check, which AuthRequired repeats on every request.
+func (s *AuthService) checkLoginAllowed(user *models.User, method, clientIP string) error {
+	if user.IsSuperuser {
+		return nil
+	}
+
+	effective := s.settingsFor(user.Organization)
+	if method != "" && !effective.AllowsLoginMethod(method) {
+		return errors.New("login method is not allowed by your organization")
+	}
+	if clientIP != "" && !effective.AllowsIP(clientIP) {
+		return errors.New("access from this ip address is not allowed")
+	}
+	return nil
+}
+
+// validIPAllowlistEntry accepts a single address or a CIDR range
+func validIPAllowlistEntry(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	return net.ParseIP(entry) != nil
+}
+
+func (s *OrganizationSettingsService) toSettingsResponse(org *models.Organization) *models.OrganizationSettingsResponse {
+	response := &models.OrganizationSettingsResponse{
+		OrganizationID: org.ID,
+		Effective:      settings.For(s.cfg, org),
+	}
+	if org.Settings != nil {
+		response.Overrides = *org.Settings
+	}
+	return response
+}
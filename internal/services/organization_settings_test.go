@@ -0,0 +1,40 @@
+package services
+
+import (
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/settings"
+	"testing"
+)
+
+func TestAccessTokenLifetimeIsCappedAtTheGlobalMaximum(t *testing.T) {
+	setupTestDB(t)
+	cfg := testConfig(t)
+	cfg.JWT.Expiration = 15 * 60
+	cfg.JWT.MaxExpiration = 60 * 60
+	s := NewOrganizationSettingsService(cfg)
+
+	org := createTestOrganization(t, "Acme")
+	owner := createTestUser(t, "owner@example.test", org)
+
+	tooLong := 2 * 60 * 60
+	_, err := s.UpdateSettings(org.ID, &models.OrganizationSettingsRequest{AccessTokenLifetime: &tooLong}, owner, &org.ID)
+	if err == nil || err.Error() != "access_token_lifetime exceeds the maximum of 3600 seconds" {
+		t.Fatalf("expected a lifetime above the maximum to be refused, got %v", err)
+	}
+
+	allowed := 60 * 60
+	response, err := s.UpdateSettings(org.ID, &models.OrganizationSettingsRequest{AccessTokenLifetime: &allowed}, owner, &org.ID)
+	if err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	if response.Effective.AccessTokenLifetime != allowed {
+		t.Errorf("effective access token lifetime is %d, want %d", response.Effective.AccessTokenLifetime, allowed)
+	}
+
+	// Lowering the maximum afterwards caps the stored override too
+	cfg.JWT.MaxExpiration = 30 * 60
+	org.Settings = &models.OrganizationSettings{AccessTokenLifetime: &allowed}
+	if lifetime := settings.For(cfg, org).AccessTokenLifetime; lifetime != 30*60 {
+		t.Errorf("effective access token lifetime is %d after lowering the maximum, want %d", lifetime, 30*60)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// passwordPolicy resolves the policy for accounts in the given organization
+func (s *AuthService) passwordPolicy(org *models.Organization) password.Policy {
+	return s.settingsFor(org).PasswordPolicy
+}
+
+// checkNewPassword validates a new password against the policy and, for existing
+// users, against the passwords they used most recently
+func (s *AuthService) checkNewPassword(tx *gorm.DB, user *models.User, org *models.Organization, plain string) error {
+	policy := s.passwordPolicy(org)
+
+	ctx := password.Context{Email: user.Email, Name: user.Name}
+	if org != nil {
+		ctx.OrganizationName = org.Name
+	}
+	if err := password.Validate(plain, policy, ctx); err != nil {
+		return err
+	}
+
+	if policy.HistorySize <= 0 || user.ID == 0 {
+		return nil
+	}
+
+	reused := &password.ViolationError{
+		Violations: []string{fmt.Sprintf("must not match any of your last %d passwords", policy.HistorySize)},
+	}
+
+	// Accounts created before history was recorded still have their current password checked
+	if password.Verify(plain, user.Password) {
+		return reused
+	}
+
+	var history []models.PasswordHistory
+	if err := tx.Where("user_id = ?", user.ID).
+		Order("created_at DESC, id DESC").
+		Limit(policy.HistorySize).
+		Find(&history).Error; err != nil {
+		return err
+	}
+
+	for _, entry := range history {
+		if password.Verify(plain, entry.PasswordHash) {
+			return reused
+		}
+	}
+
+	return nil
+}
+
+// setPassword stores a new password hash, adds it to the user's history and
+// drops history entries the policy no longer looks at
+func (s *AuthService) setPassword(tx *gorm.DB, user *models.User, org *models.Organization, hash string) error {
+	now := time.Now()
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"password":            hash,
+		"password_changed_at": now,
+	}).Error; err != nil {
+		return err
+	}
+
+	return s.recordPassword(tx, user.ID, org, hash)
+}
+
+func (s *AuthService) recordPassword(tx *gorm.DB, userID uint, org *models.Organization, hash string) error {
+	historySize := s.passwordPolicy(org).HistorySize
+	if historySize <= 0 {
+		return tx.Where("user_id = ?", userID).Delete(&models.PasswordHistory{}).Error
+	}
+
+	if err := tx.Create(&models.PasswordHistory{UserID: userID, PasswordHash: hash}).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(historySize)
+	return tx.Where("user_id = ? AND id NOT IN (?)", userID, keep).Delete(&models.PasswordHistory{}).Error
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// RequestPasswordReset emails a reset link if the account exists. It behaves the same
+// whether or not it does, so callers cannot use it to discover registered emails.
+func (s *AuthService) RequestPasswordReset(req *models.ResetPasswordEmailRequest) error {
+	// Validate the redirect up front so the error does not depend on the account existing
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/reset-password", nil); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.GetDB().Where("email = ? AND is_active = ? AND is_deleted = ?", req.Email, true, false).
+		First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	raw, hash, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Only the most recently requested link stays valid
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(time.Duration(s.cfg.Security.PasswordResetExpiration) * time.Second),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := s.emailService.BuildLink(req.RedirectURL, "/reset-password", url.Values{
+		"uid":   {encodeUID(user.ID)},
+		"token": {raw},
+	})
+	if err != nil {
+		return err
+	}
+
+	email := &models.EmailRequest{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you did not request a password reset you can ignore this email.\n",
+			user.Name, s.cfg.Security.PasswordResetExpiration/60, link),
+	}
+
+	// Sent in the background so the response time does not reveal whether the account exists
+	go func() {
+		if err := s.emailService.SendEmail(email); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// ConfirmPasswordReset sets a new password using a reset token and signs the user out everywhere
+func (s *AuthService) ConfirmPasswordReset(req *models.SetNewPasswordRequest) error {
+	userID, err := decodeUID(req.UID)
+	if err != nil {
+		return errInvalidResetToken
+	}
+
+	// The token is checked before hashing, so a made-up token does not cost a password hash. The
+	// hash is made outside the transaction and the token checked again under the lock.
+	if _, err := findResetToken(database.GetDB(), req.Token, userID); err != nil {
+		return err
+	}
+
+	hashedPassword, err := password.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		resetToken, err := findResetToken(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Token, userID)
+		if err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.Preload("Organization.Settings").First(&user, userID).Error; err != nil {
+			return errInvalidResetToken
+		}
+		if user.IsDeleted || !user.IsActive {
+			return errInvalidResetToken
+		}
+
+		// A rejected password leaves the token unused so the user can try again
+		if err := s.checkNewPassword(tx, &user, user.Organization, req.Password); err != nil {
+			return err
+		}
+
+		if err := tx.Model(resetToken).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return s.setPassword(tx, &user, user.Organization, hashedPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tokens.Revocations.RevokeUser(userID)
+}
+
+// findResetToken loads the user's reset token, treating a used or expired one as invalid
+func findResetToken(tx *gorm.DB, token string, userID uint) (*models.PasswordResetToken, error) {
+	var resetToken models.PasswordResetToken
+	if err := tx.Where("token_hash = ? AND user_id = ?", tokens.Hash(token), userID).First(&resetToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidResetToken
+		}
+		return nil, err
+	}
+
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		return nil, errInvalidResetToken
+	}
+	return &resetToken, nil
+}
+
+// encodeUID encodes a user ID for emailed links
+func encodeUID(userID uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(userID), 10)))
+}
+
+func decodeUID(uid string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(uid)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(string(raw), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
@@ -1,9 +1,13 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"kepler-auth-go/internal/database"
 	"kepler-auth-go/internal/models"
 	"math"
+
+	"gorm.io/gorm"
 )
 
 type PermissionService struct{}
@@ -43,11 +47,38 @@ func (s *PermissionService) GetPermissions(query *models.PaginationQuery) (*mode
 	}, nil
 }
 
-func (s *PermissionService) GetAuthGroups(query *models.PaginationQuery) (*models.PaginatedAuthGroupResponse, error) {
-	var authGroups []models.AuthGroup
+// findPermissions loads permissions by ID, failing if any of them does not exist
+func findPermissions(tx *gorm.DB, ids []uint) ([]models.Permission, error) {
+	permissions := make([]models.Permission, 0)
+	if len(ids) == 0 {
+		return permissions, nil
+	}
+
+	if err := tx.Where("id IN ?", ids).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint]bool, len(permissions))
+	for _, permission := range permissions {
+		found[permission.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, fmt.Errorf("permission %d does not exist", id)
+		}
+	}
+
+	return permissions, nil
+}
+
+// Auth groups are served from the groups of the caller's organization (global groups for
+// callers outside one) so the legacy /api/auth-groups API keeps working
+
+func (s *PermissionService) GetAuthGroups(query *models.PaginationQuery, organizationID *uint) (*models.PaginatedAuthGroupResponse, error) {
+	var groups []models.Group
 	var total int64
 
-	db := database.GetDB().Model(&models.AuthGroup{}).Preload("Permissions")
+	db := scopeAuthGroups(database.GetDB().Model(&models.Group{}).Preload("Permissions"), organizationID)
 
 	if query.Search != "" {
 		db = db.Where("name ILIKE ?", "%"+query.Search+"%")
@@ -58,10 +89,15 @@ func (s *PermissionService) GetAuthGroups(query *models.PaginationQuery) (*model
 	}
 
 	offset := (query.Page - 1) * query.PageSize
-	if err := db.Offset(offset).Limit(query.PageSize).Find(&authGroups).Error; err != nil {
+	if err := db.Offset(offset).Limit(query.PageSize).Find(&groups).Error; err != nil {
 		return nil, err
 	}
 
+	authGroups := make([]models.AuthGroup, len(groups))
+	for i := range groups {
+		authGroups[i] = toAuthGroup(&groups[i])
+	}
+
 	totalPages := int(math.Ceil(float64(total) / float64(query.PageSize)))
 
 	return &models.PaginatedAuthGroupResponse{
@@ -73,61 +109,103 @@ func (s *PermissionService) GetAuthGroups(query *models.PaginationQuery) (*model
 	}, nil
 }
 
-func (s *PermissionService) CreateAuthGroup(req *models.AuthGroupRequest) (*models.AuthGroup, error) {
-	authGroup := &models.AuthGroup{
-		Name: req.Name,
+func (s *PermissionService) GetAuthGroup(id uint, organizationID *uint) (*models.AuthGroup, error) {
+	group, err := s.findAuthGroup(id, organizationID)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := database.GetDB().Create(authGroup).Error; err != nil {
+	authGroup := toAuthGroup(group)
+	return &authGroup, nil
+}
+
+func (s *PermissionService) CreateAuthGroup(req *models.AuthGroupRequest, organizationID *uint) (*models.AuthGroup, error) {
+	if groupNameTaken(req.Name, organizationID, 0) {
+		return nil, errors.New("auth group with this name already exists")
+	}
+
+	permissions, err := findPermissions(database.GetDB(), req.Permissions)
+	if err != nil {
 		return nil, err
 	}
 
-	if len(req.Permissions) > 0 {
-		var permissions []models.Permission
-		if err := database.GetDB().Where("id IN ?", req.Permissions).Find(&permissions).Error; err != nil {
-			return nil, err
-		}
-		if err := database.GetDB().Model(authGroup).Association("Permissions").Replace(&permissions); err != nil {
-			return nil, err
-		}
+	group := &models.Group{
+		Name:           req.Name,
+		Permissions:    permissions,
+		IsActive:       true,
+		OrganizationID: organizationID,
 	}
 
-	if err := database.GetDB().Preload("Permissions").First(authGroup, authGroup.ID).Error; err != nil {
+	if err := database.GetDB().Create(group).Error; err != nil {
 		return nil, err
 	}
 
-	return authGroup, nil
+	return s.GetAuthGroup(group.ID, organizationID)
 }
 
-func (s *PermissionService) UpdateAuthGroup(id uint, req *models.AuthGroupRequest) (*models.AuthGroup, error) {
-	var authGroup models.AuthGroup
-	if err := database.GetDB().First(&authGroup, id).Error; err != nil {
+func (s *PermissionService) UpdateAuthGroup(id uint, req *models.AuthGroupRequest, organizationID *uint) (*models.AuthGroup, error) {
+	group, err := s.findAuthGroup(id, organizationID)
+	if err != nil {
 		return nil, err
 	}
 
-	authGroup.Name = req.Name
-	if err := database.GetDB().Save(&authGroup).Error; err != nil {
+	if req.Name != group.Name && groupNameTaken(req.Name, organizationID, id) {
+		return nil, errors.New("auth group with this name already exists")
+	}
+
+	permissions, err := findPermissions(database.GetDB(), req.Permissions)
+	if err != nil {
 		return nil, err
 	}
 
-	var permissions []models.Permission
-	if len(req.Permissions) > 0 {
-		if err := database.GetDB().Where("id IN ?", req.Permissions).Find(&permissions).Error; err != nil {
-			return nil, err
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(group).Update("name", req.Name).Error; err != nil {
+			return err
 		}
+		return tx.Model(group).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := database.GetDB().Model(&authGroup).Association("Permissions").Replace(&permissions); err != nil {
-		return nil, err
+	return s.GetAuthGroup(id, organizationID)
+}
+
+func (s *PermissionService) DeleteAuthGroup(id uint, organizationID *uint) error {
+	group, err := s.findAuthGroup(id, organizationID)
+	if err != nil {
+		return err
 	}
 
-	if err := database.GetDB().Preload("Permissions").First(&authGroup, id).Error; err != nil {
+	return database.GetDB().Delete(group).Error
+}
+
+func (s *PermissionService) findAuthGroup(id uint, organizationID *uint) (*models.Group, error) {
+	var group models.Group
+	if err := scopeAuthGroups(database.GetDB().Preload("Permissions"), organizationID).First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("auth group not found")
+		}
 		return nil, err
 	}
+	return &group, nil
+}
 
-	return &authGroup, nil
+// scopeAuthGroups matches exactly one organization's groups, unlike scopeGroups where
+// callers outside any organization see everything
+func scopeAuthGroups(db *gorm.DB, organizationID *uint) *gorm.DB {
+	if organizationID != nil {
+		return db.Where("organization_id = ?", *organizationID)
+	}
+	return db.Where("organization_id IS NULL")
 }
 
-func (s *PermissionService) DeleteAuthGroup(id uint) error {
-	return database.GetDB().Delete(&models.AuthGroup{}, id).Error
+func toAuthGroup(group *models.Group) models.AuthGroup {
+	return models.AuthGroup{
+		ID:          group.ID,
+		Name:        group.Name,
+		Permissions: group.Permissions,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}
 }
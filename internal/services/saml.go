@@ -0,0 +1,518 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/encryption"
+	"kepler-auth-go/internal/models"
+	"math/big"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	samlSPKeyBits         = 2048
+	samlSPCertificateLife = 10 * 365 * 24 * time.Hour
+)
+
+type SAMLService struct {
+	cfg          *config.Config
+	authService  *AuthService
+	emailService *EmailService
+}
+
+func NewSAMLService(cfg *config.Config) *SAMLService {
+	return &SAMLService{
+		cfg:          cfg,
+		authService:  NewAuthService(cfg),
+		emailService: NewEmailService(cfg),
+	}
+}
+
+// GetConnection returns the organization's SAML connection and the SP endpoints to give the IdP
+func (s *SAMLService) GetConnection(id uint, organizationID *uint) (*models.SAMLConnectionResponse, error) {
+	if _, err := findScopedOrganization(database.GetDB(), id, organizationID); err != nil {
+		return nil, err
+	}
+
+	connection, err := findSAMLConnection(database.GetDB(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toConnectionResponse(connection)
+}
+
+// SaveConnection creates or replaces the organization's SAML connection. The SP signing key is
+// generated when the connection is created and kept across updates, so the IdP does not need
+// the SP metadata again.
+func (s *SAMLService) SaveConnection(id uint, req *models.SAMLConnectionRequest, actor *models.User, organizationID *uint) (*models.SAMLConnectionResponse, error) {
+	pins := make([]string, 0, len(req.PinnedFingerprints))
+	for _, pin := range req.PinnedFingerprints {
+		normalized := normalizeFingerprint(pin)
+		if decoded, err := hex.DecodeString(normalized); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid pinned fingerprint %q", pin)
+		}
+		pins = append(pins, normalized)
+	}
+
+	var connection models.SAMLConnection
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if _, err := findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("organization_id = ?", id).First(&connection).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if req.IdPMetadata == nil {
+				return errors.New("idp_metadata is required")
+			}
+			certificate, key, err := s.generateSPKeyPair(id)
+			if err != nil {
+				return err
+			}
+			connection = models.SAMLConnection{
+				OrganizationID: id,
+				SPCertificate:  certificate,
+				SPPrivateKey:   key,
+				IsActive:       true,
+			}
+		case err != nil:
+			return err
+		}
+
+		if req.IdPMetadata != nil {
+			connection.IdPMetadata = *req.IdPMetadata
+		}
+		connection.PinnedFingerprints = pins
+		connection.EmailAttribute = strings.TrimSpace(req.EmailAttribute)
+		connection.NameAttribute = strings.TrimSpace(req.NameAttribute)
+		connection.GroupsAttribute = strings.TrimSpace(req.GroupsAttribute)
+		connection.GroupMapping = req.GroupMapping
+		if req.IsActive != nil {
+			connection.IsActive = *req.IsActive
+		}
+
+		metadata, err := parseIdPMetadata(connection.IdPMetadata, connection.PinnedFingerprints)
+		if err != nil {
+			return err
+		}
+		// NameIDs only identify users at the IdP that issued them, so another IdP starts without links
+		if connection.ID != 0 && connection.IdPEntityID != metadata.EntityID {
+			if err := tx.Where("connection_id = ?", connection.ID).Delete(&models.SAMLIdentity{}).Error; err != nil {
+				return err
+			}
+		}
+		connection.IdPEntityID = metadata.EntityID
+
+		if err := checkGroupMapping(tx, id, connection.GroupMapping); err != nil {
+			return err
+		}
+
+		if err := tx.Save(&connection).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditSAMLUpdate, "saml_connection", connection.ID,
+			map[string]interface{}{
+				"idp_entity_id":       connection.IdPEntityID,
+				"pinned_fingerprints": connection.PinnedFingerprints,
+				"group_mapping":       connection.GroupMapping,
+				"is_active":           connection.IsActive,
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toConnectionResponse(&connection)
+}
+
+// DeleteConnection removes the organization's SAML connection. Users it provisioned keep their
+// accounts and memberships.
+func (s *SAMLService) DeleteConnection(id uint, actor *models.User, organizationID *uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if _, err := findScopedOrganization(tx, id, organizationID); err != nil {
+			return err
+		}
+
+		connection, err := findSAMLConnection(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("connection_id = ?", connection.ID).Delete(&models.SAMLSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("connection_id = ?", connection.ID).Delete(&models.SAMLIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(connection).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor.ID, &id, models.AuditSAMLDelete, "saml_connection", connection.ID,
+			map[string]interface{}{"idp_entity_id": connection.IdPEntityID})
+	})
+}
+
+// Metadata returns the SP metadata XML for the organization's connection
+func (s *SAMLService) Metadata(id uint) ([]byte, error) {
+	connection, err := findSAMLConnection(database.GetDB(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := s.serviceProvider(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+func findSAMLConnection(tx *gorm.DB, organizationID uint) (*models.SAMLConnection, error) {
+	var connection models.SAMLConnection
+	if err := tx.Where("organization_id = ?", organizationID).First(&connection).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("saml connection not found")
+		}
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// checkGroupMapping makes sure every mapped group belongs to the organization
+func checkGroupMapping(tx *gorm.DB, organizationID uint, mapping map[string][]uint) error {
+	for value, groupIDs := range mapping {
+		for _, groupID := range groupIDs {
+			var count int64
+			if err := tx.Model(&models.Group{}).Where("id = ? AND organization_id = ?", groupID, organizationID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("group %d mapped from %q not found", groupID, value)
+			}
+		}
+	}
+	return nil
+}
+
+// samlBaseURL is the base of an organization's SP endpoints
+func samlBaseURL(cfg *config.Config, organizationID uint) string {
+	return fmt.Sprintf("%s/api/saml/%d", strings.TrimSuffix(cfg.OIDC.Issuer, "/"), organizationID)
+}
+
+// serviceProvider builds the SP for a connection. Only IdP certificates matching the pins are
+// trusted to sign responses.
+func (s *SAMLService) serviceProvider(connection *models.SAMLConnection) (*saml.ServiceProvider, error) {
+	metadata, err := parseIdPMetadata(connection.IdPMetadata, connection.PinnedFingerprints)
+	if err != nil {
+		return nil, err
+	}
+
+	key, certificate, err := s.loadSPKeyPair(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	base := samlBaseURL(s.cfg, connection.OrganizationID)
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/acs")
+	if err != nil {
+		return nil, err
+	}
+	sloURL, err := url.Parse(base + "/slo")
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:        metadataURL.String(),
+		Key:             key,
+		Certificate:     certificate,
+		MetadataURL:     *metadataURL,
+		AcsURL:          *acsURL,
+		SloURL:          *sloURL,
+		IDPMetadata:     metadata,
+		SignatureMethod: dsig.RSASHA256SignatureMethod,
+		LogoutBindings:  []string{saml.HTTPRedirectBinding, saml.HTTPPostBinding},
+	}, nil
+}
+
+// parseIdPMetadata reads uploaded IdP metadata, keeping only the signing certificates that
+// match the pins. Metadata wrapped in an EntitiesDescriptor uses its first IdP.
+func parseIdPMetadata(raw string, pins []string) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(strings.NewReader(raw)); err != nil {
+		return nil, errors.New("invalid idp metadata")
+	}
+
+	metadata := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal([]byte(raw), metadata); err != nil {
+		var entities saml.EntitiesDescriptor
+		if xml.Unmarshal([]byte(raw), &entities) != nil {
+			return nil, errors.New("invalid idp metadata")
+		}
+		metadata = nil
+		for i := range entities.EntityDescriptors {
+			if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+				metadata = &entities.EntityDescriptors[i]
+				break
+			}
+		}
+	}
+	if metadata == nil || metadata.EntityID == "" || len(metadata.IDPSSODescriptors) == 0 {
+		return nil, errors.New("idp metadata has no identity provider")
+	}
+
+	trusted := 0
+	for i := range metadata.IDPSSODescriptors {
+		descriptor := &metadata.IDPSSODescriptors[i]
+		keys := make([]saml.KeyDescriptor, 0, len(descriptor.KeyDescriptors))
+		for _, key := range descriptor.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				keys = append(keys, key)
+				continue
+			}
+
+			kept := key
+			kept.KeyInfo.X509Data.X509Certificates = nil
+			for _, data := range key.KeyInfo.X509Data.X509Certificates {
+				certificate, err := parseMetadataCertificate(data.Data)
+				if err != nil {
+					return nil, errors.New("idp metadata contains an invalid certificate")
+				}
+				if len(pins) == 0 || slices.Contains(pins, certificateFingerprint(certificate)) {
+					kept.KeyInfo.X509Data.X509Certificates = append(kept.KeyInfo.X509Data.X509Certificates, data)
+				}
+			}
+			if len(kept.KeyInfo.X509Data.X509Certificates) > 0 {
+				keys = append(keys, kept)
+				trusted += len(kept.KeyInfo.X509Data.X509Certificates)
+			}
+		}
+		descriptor.KeyDescriptors = keys
+	}
+
+	if trusted == 0 {
+		if len(pins) > 0 {
+			return nil, errors.New("no idp signing certificate matches the pinned fingerprints")
+		}
+		return nil, errors.New("idp metadata has no signing certificate")
+	}
+
+	if idpSSOLocation(metadata) == "" {
+		return nil, errors.New("idp metadata has no HTTP-Redirect single sign-on service")
+	}
+
+	return metadata, nil
+}
+
+// idpSigningCertificates returns the certificates trusted to sign IdP messages
+func idpSigningCertificates(metadata *saml.EntityDescriptor) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, key := range descriptor.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, data := range key.KeyInfo.X509Data.X509Certificates {
+				certificate, err := parseMetadataCertificate(data.Data)
+				if err != nil {
+					return nil, err
+				}
+				certificates = append(certificates, certificate)
+			}
+		}
+	}
+	return certificates, nil
+}
+
+func idpSSOLocation(metadata *saml.EntityDescriptor) string {
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, service := range descriptor.SingleSignOnServices {
+			if service.Binding == saml.HTTPRedirectBinding {
+				return service.Location
+			}
+		}
+	}
+	return ""
+}
+
+func idpSLOLocation(metadata *saml.EntityDescriptor) string {
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, service := range descriptor.SingleLogoutServices {
+			if service.Binding == saml.HTTPRedirectBinding {
+				return service.Location
+			}
+		}
+	}
+	return ""
+}
+
+func parseMetadataCertificate(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// certificateFingerprint is the lowercase hex SHA-256 of the DER certificate
+func certificateFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts fingerprints with or without colons, in either case
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(fingerprint)))
+}
+
+// generateSPKeyPair creates the self-signed certificate and key the SP signs requests with.
+// The key is stored encrypted like TOTP secrets.
+func (s *SAMLService) generateSPKeyPair(organizationID uint) (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, samlSPKeyBits)
+	if err != nil {
+		return "", "", err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: samlBaseURL(s.cfg, organizationID) + "/metadata"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(samlSPCertificateLife),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return "", "", err
+	}
+	encryptedKey, err := cipher.Encrypt(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), encryptedKey, nil
+}
+
+func (s *SAMLService) loadSPKeyPair(connection *models.SAMLConnection) (*rsa.PrivateKey, *x509.Certificate, error) {
+	certificateBlock, _ := pem.Decode([]byte(connection.SPCertificate))
+	if certificateBlock == nil {
+		return nil, nil, errors.New("invalid saml sp certificate")
+	}
+	certificate, err := x509.ParseCertificate(certificateBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cipher, err := encryption.NewCipher(s.cfg.Security.EncryptionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := cipher.Decrypt(connection.SPPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode([]byte(keyPEM))
+	if keyBlock == nil {
+		return nil, nil, errors.New("invalid saml sp key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("invalid saml sp key")
+	}
+
+	return key, certificate, nil
+}
+
+func (s *SAMLService) toConnectionResponse(connection *models.SAMLConnection) (*models.SAMLConnectionResponse, error) {
+	// Certificates are listed from the unpinned metadata so admins can see what to pin
+	metadata, err := parseIdPMetadata(connection.IdPMetadata, nil)
+	if err != nil {
+		return nil, err
+	}
+	certificates, err := idpSigningCertificates(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	base := samlBaseURL(s.cfg, connection.OrganizationID)
+	response := &models.SAMLConnectionResponse{
+		OrganizationID:     connection.OrganizationID,
+		IdPEntityID:        connection.IdPEntityID,
+		IdPSSOURL:          idpSSOLocation(metadata),
+		IdPSLOURL:          idpSLOLocation(metadata),
+		IdPCertificates:    make([]models.SAMLCertificate, 0, len(certificates)),
+		PinnedFingerprints: connection.PinnedFingerprints,
+		EmailAttribute:     connection.EmailAttribute,
+		NameAttribute:      connection.NameAttribute,
+		GroupsAttribute:    connection.GroupsAttribute,
+		GroupMapping:       connection.GroupMapping,
+		IsActive:           connection.IsActive,
+		SPEntityID:         base + "/metadata",
+		SPMetadataURL:      base + "/metadata",
+		ACSURL:             base + "/acs",
+		SLOURL:             base + "/slo",
+		LoginURL:           base + "/login",
+		CreatedAt:          connection.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:          connection.UpdatedAt.Format(time.RFC3339),
+	}
+	for _, certificate := range certificates {
+		fingerprint := certificateFingerprint(certificate)
+		response.IdPCertificates = append(response.IdPCertificates, models.SAMLCertificate{
+			Subject:     certificate.Subject.String(),
+			Fingerprint: fingerprint,
+			NotAfter:    certificate.NotAfter.Format(time.RFC3339),
+			Pinned:      slices.Contains(connection.PinnedFingerprints, fingerprint),
+		})
+	}
+
+	return response, nil
+}
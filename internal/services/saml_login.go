@@ -0,0 +1,858 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/middleware"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	samlRequestTTL = 10 * time.Minute
+	samlCodeTTL    = time.Minute
+	// samlMaxMessageSize caps inflated HTTP-Redirect messages
+	samlMaxMessageSize = 1 << 20
+)
+
+var errInvalidSAMLLogin = errors.New("invalid or expired saml login")
+
+// redirectSignatureHashes are the HTTP-Redirect binding signature algorithms accepted from IdPs
+var redirectSignatureHashes = map[string]crypto.Hash{
+	dsig.RSASHA256SignatureMethod: crypto.SHA256,
+	dsig.RSASHA512SignatureMethod: crypto.SHA512,
+}
+
+// samlIdentity is what an assertion says about the user, after attribute mapping
+type samlIdentity struct {
+	Email        string
+	Name         string
+	Groups       []string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+}
+
+// BeginLogin starts an SP-initiated login and returns the IdP URL to send the browser to. The
+// relay state ties the IdP's response back to this request.
+func (s *SAMLService) BeginLogin(id uint, redirectURL string) (string, error) {
+	if _, err := s.emailService.BuildLink(redirectURL, "/sso/callback", nil); err != nil {
+		return "", err
+	}
+
+	connection, err := findActiveSAMLConnection(database.GetDB(), id)
+	if err != nil {
+		return "", err
+	}
+
+	return s.beginSAML(connection, &models.SAMLSession{
+		Purpose:     models.SAMLPurposeLogin,
+		RedirectURL: redirectURL,
+	})
+}
+
+// BeginLink starts linking the user's account at an organization's IdP to the current user. The
+// browser returns to the redirect URL once the IdP confirmed the account.
+func (s *SAMLService) BeginLink(userID uint, req *models.SAMLLinkRequest) (*models.IdentityLinkResponse, error) {
+	if _, err := s.emailService.BuildLink(req.RedirectURL, "/account/identities", nil); err != nil {
+		return nil, err
+	}
+
+	connection, err := findActiveSAMLConnection(database.GetDB(), req.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := s.beginSAML(connection, &models.SAMLSession{
+		UserID:      &userID,
+		Purpose:     models.SAMLPurposeLink,
+		RedirectURL: req.RedirectURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.IdentityLinkResponse{AuthorizationURL: location}, nil
+}
+
+// beginSAML stores the authentication request and returns the IdP URL carrying it
+func (s *SAMLService) beginSAML(connection *models.SAMLConnection, session *models.SAMLSession) (string, error) {
+	sp, err := s.serviceProvider(connection)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+
+	relayState, hash, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	session.ConnectionID = connection.ID
+	session.TokenHash = &hash
+	session.Stage = models.SAMLStageRequest
+	session.RequestID = request.ID
+	session.ExpiresAt = time.Now().Add(samlRequestTTL)
+	if err := database.GetDB().Create(session).Error; err != nil {
+		return "", err
+	}
+
+	location, err := request.Redirect(relayState, sp)
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
+
+// ConsumeAssertion validates the IdP's response to a login started with BeginLogin, provisions
+// the user into the organization and returns the frontend URL carrying a single-use login code.
+// Responses to BeginLink link the account and return the frontend URL directly. IdP-initiated
+// logins are refused, since nothing ties them to a browser that asked to sign in.
+func (s *SAMLService) ConsumeAssertion(id uint, samlResponse, relayState string) (string, error) {
+	if relayState == "" {
+		return "", errors.New("idp-initiated saml login is not supported")
+	}
+
+	connection, err := findActiveSAMLConnection(database.GetDB(), id)
+	if err != nil {
+		return "", err
+	}
+
+	sp, err := s.serviceProvider(connection)
+	if err != nil {
+		return "", err
+	}
+
+	rawResponse, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return "", errors.New("invalid saml response")
+	}
+
+	var redirect string
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var session models.SAMLSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND connection_id = ? AND stage = ?", tokens.Hash(relayState), connection.ID, models.SAMLStageRequest).
+			First(&session).Error; err != nil {
+			return errInvalidSAMLLogin
+		}
+		if time.Now().After(session.ExpiresAt) {
+			return errInvalidSAMLLogin
+		}
+
+		assertion, err := sp.ParseXMLResponse(rawResponse, []string{session.RequestID}, sp.AcsURL)
+		if err != nil {
+			var invalid *saml.InvalidResponseError
+			if errors.As(err, &invalid) {
+				log.Printf("Rejected SAML response for organization %d: %v", id, invalid.PrivateErr)
+			}
+			return errors.New("invalid saml response")
+		}
+
+		identity, err := readSAMLIdentity(connection, assertion)
+		if err != nil {
+			return err
+		}
+
+		if session.Purpose == models.SAMLPurposeLink {
+			if err := s.linkIdentity(tx, connection, &session, identity); err != nil {
+				return err
+			}
+			if err := tx.Delete(&session).Error; err != nil {
+				return err
+			}
+			redirect, err = s.emailService.BuildLink(session.RedirectURL, "/account/identities",
+				url.Values{"linked_saml": {strconv.FormatUint(uint64(connection.OrganizationID), 10)}})
+			return err
+		}
+
+		user, err := s.provisionUser(tx, connection, identity)
+		if err != nil {
+			return err
+		}
+
+		code, hash, err := tokens.Generate()
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"user_id":       user.ID,
+			"token_hash":    hash,
+			"stage":         models.SAMLStageCode,
+			"name_id":       identity.NameID,
+			"session_index": identity.SessionIndex,
+			"expires_at":    time.Now().Add(samlCodeTTL),
+		}).Error; err != nil {
+			return err
+		}
+
+		redirect, err = s.emailService.BuildLink(session.RedirectURL, "/sso/callback", url.Values{"code": {code}})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return redirect, nil
+}
+
+// ExchangeCode trades the login code from ConsumeAssertion for the same tokens as Login. The IdP
+// authenticated the user under its own MFA policy, so no TOTP challenge follows.
+func (s *SAMLService) ExchangeCode(req *models.SAMLExchangeRequest) (*models.LoginResponse, error) {
+	db := database.GetDB()
+
+	var session models.SAMLSession
+	if err := db.Preload("Connection").
+		Where("token_hash = ? AND stage = ?", tokens.Hash(req.Code), models.SAMLStageCode).
+		First(&session).Error; err != nil {
+		return nil, errInvalidSAMLLogin
+	}
+	// The code is consumed on its own before the checks below, so it stays used when one of them
+	// fails. Only the request whose update cleared it may go on.
+	result := db.Model(&models.SAMLSession{}).Where("id = ? AND token_hash = ?", session.ID, *session.TokenHash).
+		Updates(map[string]interface{}{"token_hash": nil})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInvalidSAMLLogin
+	}
+	if time.Now().After(session.ExpiresAt) || session.UserID == nil ||
+		session.Connection == nil || !session.Connection.IsActive {
+		return nil, errInvalidSAMLLogin
+	}
+
+	var user models.User
+	if err := db.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, *session.UserID).Error; err != nil {
+		return nil, errInvalidSAMLLogin
+	}
+	if user.IsDeleted || !user.IsActive {
+		return nil, errors.New("account is deactivated")
+	}
+
+	organizationID := session.Connection.OrganizationID
+	if err := selectOrganization(db, &user, &organizationID); err != nil {
+		return nil, err
+	}
+	if err := s.authService.checkLoginAllowed(&user, models.LoginMethodSAML, req.ClientIP); err != nil {
+		return nil, err
+	}
+	if !user.IsVerified && s.authService.requiresVerifiedEmail(&user) {
+		return nil, errors.New("email address is not verified")
+	}
+
+	var response *models.LoginResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		familyID, err := tokens.NewID()
+		if err != nil {
+			return err
+		}
+		refreshToken, raw, err := s.authService.createRefreshToken(tx, &user, familyID, models.LoginMethodSAML)
+		if err != nil {
+			return err
+		}
+
+		// The session is kept so single logout can find the token family to revoke
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"stage":      models.SAMLStageActive,
+			"family_id":  familyID,
+			"expires_at": refreshToken.ExpiresAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		response, err = s.authService.buildLoginResponse(&user, raw, models.LoginMethodSAML)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Logout ends the session like AuthService.Logout. For sessions that came from a SAML login it
+// also returns the IdP single logout URL, so the browser can end the IdP session too.
+func (s *SAMLService) Logout(claims *middleware.Claims, req *models.SAMLLogoutRequest) (*models.SAMLLogoutResponse, error) {
+	session, err := findSAMLSessionByRefreshToken(database.GetDB(), claims.UserID, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.Logout(claims, &models.LogoutRequest{RefreshToken: req.RefreshToken}); err != nil {
+		return nil, err
+	}
+
+	response := &models.SAMLLogoutResponse{}
+	if session == nil {
+		return response, nil
+	}
+
+	if err := database.GetDB().Delete(session).Error; err != nil {
+		return nil, err
+	}
+
+	if !session.Connection.IsActive {
+		return response, nil
+	}
+
+	sp, err := s.serviceProvider(session.Connection)
+	if err != nil {
+		return nil, err
+	}
+	if sp.GetSLOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return response, nil
+	}
+
+	location, err := sp.MakeRedirectLogoutRequest(session.NameID, "")
+	if err != nil {
+		return nil, err
+	}
+	response.RedirectURL = location.String()
+
+	return response, nil
+}
+
+// HandleLogout processes a message sent by the IdP to the SLO endpoint and returns the URL to
+// send the browser to. A LogoutResponse completes a logout started with Logout; a LogoutRequest
+// revokes the refresh tokens of the named user's SAML sessions. Access tokens already issued
+// stay valid until they expire.
+func (s *SAMLService) HandleLogout(id uint, r *http.Request) (string, error) {
+	connection, err := findActiveSAMLConnection(database.GetDB(), id)
+	if err != nil {
+		return "", err
+	}
+
+	sp, err := s.serviceProvider(connection)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return "", errors.New("invalid saml message")
+	}
+
+	if r.Form.Get("SAMLResponse") != "" {
+		if err := sp.ValidateLogoutResponseRequest(r); err != nil {
+			return "", errors.New("invalid saml logout response")
+		}
+		return s.emailService.BuildLink("", "/login", nil)
+	}
+
+	logoutRequest, err := parseLogoutRequest(sp, r)
+	if err != nil {
+		return "", err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("connection_id = ? AND name_id = ? AND stage = ?", connection.ID, logoutRequest.NameID.Value, models.SAMLStageActive)
+		if logoutRequest.SessionIndex != nil && logoutRequest.SessionIndex.Value != "" {
+			query = query.Where("session_index = ?", logoutRequest.SessionIndex.Value)
+		}
+
+		var sessions []models.SAMLSession
+		if err := query.Find(&sessions).Error; err != nil {
+			return err
+		}
+
+		for _, session := range sessions {
+			if err := s.authService.revokeRefreshFamily(tx, session.FamilyID); err != nil {
+				return err
+			}
+			if err := tx.Delete(&session).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	location, err := sp.MakeRedirectLogoutResponse(logoutRequest.ID, r.Form.Get("RelayState"))
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
+
+// findActiveSAMLConnection loads a connection users may sign in through
+func findActiveSAMLConnection(tx *gorm.DB, organizationID uint) (*models.SAMLConnection, error) {
+	connection, err := findSAMLConnection(tx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if !connection.IsActive {
+		return nil, errors.New("saml connection not found")
+	}
+	return connection, nil
+}
+
+// findSAMLSessionByRefreshToken returns the active SAML session a refresh token belongs to, or nil
+func findSAMLSessionByRefreshToken(tx *gorm.DB, userID uint, refreshToken string) (*models.SAMLSession, error) {
+	var token models.RefreshToken
+	if err := tx.Where("token_hash = ? AND user_id = ?", tokens.Hash(refreshToken), userID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var session models.SAMLSession
+	if err := tx.Preload("Connection").Where("family_id = ? AND stage = ?", token.FamilyID, models.SAMLStageActive).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// readSAMLIdentity applies the connection's attribute mapping to a verified assertion
+func readSAMLIdentity(connection *models.SAMLConnection, assertion *saml.Assertion) (*samlIdentity, error) {
+	identity := &samlIdentity{}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		identity.NameID = assertion.Subject.NameID.Value
+		identity.NameIDFormat = assertion.Subject.NameID.Format
+	}
+	if identity.NameID == "" {
+		return nil, errors.New("saml assertion has no name id")
+	}
+	for _, statement := range assertion.AuthnStatements {
+		if statement.SessionIndex != "" {
+			identity.SessionIndex = statement.SessionIndex
+			break
+		}
+	}
+
+	// Attributes are matched by name or friendly name, IdPs differ in which one they set
+	values := func(name string) []string {
+		var found []string
+		if name == "" {
+			return found
+		}
+		for _, statement := range assertion.AttributeStatements {
+			for _, attribute := range statement.Attributes {
+				if attribute.Name != name && attribute.FriendlyName != name {
+					continue
+				}
+				for _, value := range attribute.Values {
+					if value := strings.TrimSpace(value.Value); value != "" {
+						found = append(found, value)
+					}
+				}
+			}
+		}
+		return found
+	}
+
+	identity.Email = identity.NameID
+	if emails := values(connection.EmailAttribute); len(emails) > 0 {
+		identity.Email = emails[0]
+	}
+	if address, err := mail.ParseAddress(identity.Email); err != nil || address.Address != identity.Email {
+		return nil, errors.New("saml assertion has no valid email address")
+	}
+
+	if names := values(connection.NameAttribute); len(names) > 0 {
+		identity.Name = names[0]
+	}
+	identity.Groups = values(connection.GroupsAttribute)
+
+	return identity, nil
+}
+
+// provisionUser finds or creates the user an assertion is about and makes them a member of the
+// connection's organization. The IdP is only trusted with addresses at the organization's verified
+// domain; any other account has to link its NameID from the user's profile first, so an IdP can
+// neither claim existing accounts nor create new ones by email alone.
+func (s *SAMLService) provisionUser(tx *gorm.DB, connection *models.SAMLConnection, identity *samlIdentity) (*models.User, error) {
+	var org models.Organization
+	if err := tx.First(&org, connection.OrganizationID).Error; err != nil {
+		return nil, err
+	}
+	// The IdP vouches for addresses at the organization's own verified domain
+	domainEmail := org.HasVerifiedDomain() && emailHasDomain(identity.Email, *org.Domain)
+
+	var user models.User
+	var link models.SAMLIdentity
+	err := tx.Where("connection_id = ? AND name_id = ?", connection.ID, identity.NameID).First(&link).Error
+	switch {
+	case err == nil:
+		if err := tx.First(&user, link.UserID).Error; err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	case !domainEmail:
+		return nil, errors.New("no account is linked to this saml identity, sign in and link it from your profile")
+	default:
+		err := tx.Where("LOWER(email) = ?", strings.ToLower(identity.Email)).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := s.createSAMLUser(tx, connection, &org, identity, &user); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		}
+
+		// IdPs sending transient NameIDs relink the account on every login
+		err = tx.Where("connection_id = ? AND user_id = ?", connection.ID, user.ID).First(&link).Error
+		switch {
+		case err == nil:
+			if err := tx.Model(&link).Update("name_id", identity.NameID).Error; err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, err
+		default:
+			link = models.SAMLIdentity{ConnectionID: connection.ID, UserID: user.ID, NameID: identity.NameID}
+			if err := tx.Create(&link).Error; err != nil {
+				return nil, err
+			}
+			if err := recordAudit(tx, user.ID, &org.ID, models.AuditSAMLLink, "user", user.ID,
+				map[string]interface{}{"idp_entity_id": connection.IdPEntityID, "name_id": identity.NameID, "domain": *org.Domain}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if user.IsSuperuser {
+		return nil, errors.New("superusers cannot sign in with saml")
+	}
+	if user.IsDeleted || !user.IsActive {
+		return nil, errors.New("account is deactivated")
+	}
+
+	var member models.OrganizationMember
+	if err := tx.Where("organization_id = ? AND user_id = ?", org.ID, user.ID).First(&member).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if !domainEmail {
+			return nil, errors.New("not a member of this organization")
+		}
+		if err := addMembership(tx, org.ID, user.ID, models.OrgRoleMember); err != nil {
+			return nil, err
+		}
+		if err := recordAudit(tx, user.ID, &org.ID, models.AuditSAMLProvision, "user", user.ID,
+			map[string]interface{}{"idp_entity_id": connection.IdPEntityID, "name_id": identity.NameID, "existing_user": true}); err != nil {
+			return nil, err
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if identity.Name != "" && identity.Name != user.Name {
+		updates["name"] = identity.Name
+	}
+	if domainEmail && !user.IsVerified && strings.EqualFold(identity.Email, user.Email) {
+		updates["is_verified"] = true
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(&link).Updates(map[string]interface{}{
+		"email":         identity.Email,
+		"last_login_at": time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	if connection.GroupsAttribute != "" {
+		if err := syncMappedGroups(tx, &user, connection, identity.Groups); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// createSAMLUser creates the account for an address at the organization's verified domain that
+// has none yet (JIT provisioning)
+func (s *SAMLService) createSAMLUser(tx *gorm.DB, connection *models.SAMLConnection, org *models.Organization, identity *samlIdentity, user *models.User) error {
+	name := identity.Name
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	// No password is set, the account signs in through the IdP until one is reset
+	*user = models.User{
+		Email:          identity.Email,
+		Name:           name,
+		OrganizationID: &org.ID,
+		IsActive:       true,
+		IsVerified:     true,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := s.authService.assignDefaultGroups(tx, user); err != nil {
+		return err
+	}
+	if err := addMembership(tx, org.ID, user.ID, models.OrgRoleMember); err != nil {
+		return err
+	}
+	return recordAudit(tx, user.ID, &org.ID, models.AuditSAMLProvision, "user", user.ID,
+		map[string]interface{}{"idp_entity_id": connection.IdPEntityID, "name_id": identity.NameID})
+}
+
+// linkIdentity attaches the asserted NameID to the member who started the link
+func (s *SAMLService) linkIdentity(tx *gorm.DB, connection *models.SAMLConnection, session *models.SAMLSession, identity *samlIdentity) error {
+	if session.UserID == nil {
+		return errInvalidSAMLLogin
+	}
+	// A transient NameID changes on every login, so it would never match the link again
+	if identity.NameIDFormat == string(saml.TransientNameIDFormat) {
+		return errors.New("the idp sends transient name ids, which cannot be linked")
+	}
+
+	var user models.User
+	if err := tx.First(&user, *session.UserID).Error; err != nil {
+		return errInvalidSAMLLogin
+	}
+	if user.IsSuperuser {
+		return errors.New("superusers cannot sign in with saml")
+	}
+	if user.IsDeleted || !user.IsActive {
+		return errors.New("account is deactivated")
+	}
+
+	var member models.OrganizationMember
+	if err := tx.Where("organization_id = ? AND user_id = ?", connection.OrganizationID, user.ID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("not a member of this organization")
+		}
+		return err
+	}
+
+	var link models.SAMLIdentity
+	err := tx.Where("connection_id = ? AND (name_id = ? OR user_id = ?)", connection.ID, identity.NameID, user.ID).First(&link).Error
+	switch {
+	case err == nil:
+		if link.UserID != user.ID {
+			return errors.New("this saml identity is already linked to another account")
+		}
+		if link.NameID != identity.NameID {
+			return errors.New("another account at this idp is already linked")
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	default:
+		link = models.SAMLIdentity{ConnectionID: connection.ID, UserID: user.ID, NameID: identity.NameID}
+		if err := tx.Create(&link).Error; err != nil {
+			return err
+		}
+		if err := recordAudit(tx, user.ID, &connection.OrganizationID, models.AuditSAMLLink, "user", user.ID,
+			map[string]interface{}{"idp_entity_id": connection.IdPEntityID, "name_id": identity.NameID}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&link).Update("email", identity.Email).Error
+}
+
+// syncMappedGroups gives the user the groups mapped from their IdP groups and takes away mapped
+// groups they no longer have. Groups that are not in the mapping are left alone.
+func syncMappedGroups(tx *gorm.DB, user *models.User, connection *models.SAMLConnection, idpGroups []string) error {
+	wanted := make(map[uint]bool)
+	var managed []uint
+	for value, groupIDs := range connection.GroupMapping {
+		managed = append(managed, groupIDs...)
+		if slices.Contains(idpGroups, value) {
+			for _, id := range groupIDs {
+				wanted[id] = true
+			}
+		}
+	}
+	if len(managed) == 0 {
+		return nil
+	}
+
+	// Groups deleted since the mapping was saved are skipped
+	var groups []models.Group
+	if err := tx.Where("id IN ? AND organization_id = ?", managed, connection.OrganizationID).Find(&groups).Error; err != nil {
+		return err
+	}
+
+	var add, remove []models.Group
+	for _, group := range groups {
+		if wanted[group.ID] {
+			add = append(add, group)
+		} else {
+			remove = append(remove, group)
+		}
+	}
+
+	if len(add) > 0 {
+		if err := tx.Model(user).Association("Groups").Append(add); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if err := tx.Model(user).Association("Groups").Delete(remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLogoutRequest reads and verifies an IdP LogoutRequest. HTTP-Redirect messages may be
+// signed in the query string; otherwise the message must carry an XML signature.
+func parseLogoutRequest(sp *saml.ServiceProvider, r *http.Request) (*saml.LogoutRequest, error) {
+	encoded := r.Form.Get("SAMLRequest")
+	if encoded == "" {
+		return nil, errors.New("saml message is required")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid saml message")
+	}
+
+	redirectBinding := r.Method == http.MethodGet
+	if redirectBinding {
+		inflated, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(raw)), samlMaxMessageSize))
+		if err != nil {
+			return nil, errors.New("invalid saml message")
+		}
+		raw = inflated
+	}
+
+	if redirectBinding && r.URL.Query().Get("Signature") != "" {
+		if err := verifyRedirectSignature(sp.IDPMetadata, r.URL.RawQuery); err != nil {
+			return nil, err
+		}
+		if err := xrv.Validate(bytes.NewReader(raw)); err != nil {
+			return nil, errors.New("invalid saml message")
+		}
+	} else if raw, err = verifyIdPSignature(sp.IDPMetadata, raw); err != nil {
+		return nil, err
+	}
+
+	var logoutRequest saml.LogoutRequest
+	if err := xml.Unmarshal(raw, &logoutRequest); err != nil {
+		return nil, errors.New("invalid saml message")
+	}
+
+	if logoutRequest.Issuer == nil || logoutRequest.Issuer.Value != sp.IDPMetadata.EntityID {
+		return nil, errors.New("invalid saml logout request")
+	}
+	if logoutRequest.Destination != "" && logoutRequest.Destination != sp.SloURL.String() {
+		return nil, errors.New("invalid saml logout request")
+	}
+	if logoutRequest.IssueInstant.Add(saml.MaxIssueDelay).Before(time.Now()) {
+		return nil, errors.New("invalid saml logout request")
+	}
+	if logoutRequest.NameID == nil || logoutRequest.NameID.Value == "" {
+		return nil, errors.New("invalid saml logout request")
+	}
+
+	return &logoutRequest, nil
+}
+
+// verifyIdPSignature checks the enveloped XML signature of an IdP message against the trusted
+// certificates and returns the signed element
+func verifyIdPSignature(metadata *saml.EntityDescriptor, raw []byte) ([]byte, error) {
+	if err := xrv.Validate(bytes.NewReader(raw)); err != nil {
+		return nil, errors.New("invalid saml message")
+	}
+
+	certificates, err := idpSigningCertificates(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		return nil, errors.New("invalid saml message")
+	}
+
+	validated, err := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certificates}).
+		Validate(doc.Root())
+	if err != nil {
+		return nil, errors.New("invalid saml message signature")
+	}
+
+	signed := etree.NewDocument()
+	signed.SetRoot(validated)
+	return signed.WriteToBytes()
+}
+
+// verifyRedirectSignature checks an HTTP-Redirect binding signature, which covers the
+// SAMLRequest, RelayState and SigAlg parameters exactly as they were encoded in the query
+func verifyRedirectSignature(metadata *saml.EntityDescriptor, rawQuery string) error {
+	encoded := make(map[string]string)
+	for _, part := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(part, "=")
+		encoded[name] = part
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return errors.New("invalid saml message")
+	}
+
+	hash, ok := redirectSignatureHashes[query.Get("SigAlg")]
+	if !ok || !hash.Available() {
+		return errors.New("unsupported saml signature algorithm")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(query.Get("Signature"))
+	if err != nil {
+		return errors.New("invalid saml message signature")
+	}
+
+	signed := encoded["SAMLRequest"]
+	if relayState, ok := encoded["RelayState"]; ok {
+		signed += "&" + relayState
+	}
+	signed += "&" + encoded["SigAlg"]
+
+	digest := hash.New()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	certificates, err := idpSigningCertificates(metadata)
+	if err != nil {
+		return err
+	}
+	for _, certificate := range certificates {
+		if key, ok := certificate.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(key, hash, sum, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("invalid saml message signature")
+}
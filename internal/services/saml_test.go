@@ -0,0 +1,285 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// testIdP is an in-process identity provider that answers the SP of one organization with
+// signed responses, standing in for the organization's real IdP
+type testIdP struct {
+	idp *saml.IdentityProvider
+	sp  *saml.EntityDescriptor
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate idp key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create idp certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse idp certificate: %v", err)
+	}
+
+	p := &testIdP{}
+	p.idp = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             certificate,
+		MetadataURL:             url.URL{Scheme: "https", Host: "idp.example.test", Path: "/metadata"},
+		SSOURL:                  url.URL{Scheme: "https", Host: "idp.example.test", Path: "/sso"},
+		ServiceProviderProvider: p,
+	}
+	return p
+}
+
+func (p *testIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if p.sp == nil || p.sp.EntityID != serviceProviderID {
+		return nil, os.ErrNotExist
+	}
+	return p.sp, nil
+}
+
+// connect saves the IdP as the organization's SAML connection and trusts the resulting SP
+func (p *testIdP) connect(t *testing.T, s *SAMLService, org *models.Organization, owner *models.User) {
+	t.Helper()
+
+	metadata, err := xml.Marshal(p.idp.Metadata())
+	if err != nil {
+		t.Fatalf("failed to marshal idp metadata: %v", err)
+	}
+	idpMetadata := string(metadata)
+	if _, err := s.SaveConnection(org.ID, &models.SAMLConnectionRequest{
+		IdPMetadata:    &idpMetadata,
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+	}, owner, &org.ID); err != nil {
+		t.Fatalf("SaveConnection: %v", err)
+	}
+
+	spMetadata, err := s.Metadata(org.ID)
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	p.sp = &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(spMetadata, p.sp); err != nil {
+		t.Fatalf("failed to parse sp metadata: %v", err)
+	}
+}
+
+// respond signs the user in at the IdP for the request in the SP's redirect and returns the
+// form the browser posts back to the ACS
+func (p *testIdP) respond(t *testing.T, location string, session *saml.Session) (string, string) {
+	t.Helper()
+
+	req, err := saml.NewIdpAuthnRequest(p.idp, httptest.NewRequest(http.MethodGet, location, nil))
+	if err != nil {
+		t.Fatalf("failed to read authn request: %v", err)
+	}
+	if err := req.Validate(); err != nil {
+		t.Fatalf("invalid authn request: %v", err)
+	}
+	if session.Index == "" {
+		session.Index = "session-1"
+	}
+	session.CreateTime = time.Now()
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatalf("failed to make assertion: %v", err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatalf("failed to make response: %v", err)
+	}
+	return form.SAMLResponse, form.RelayState
+}
+
+// login runs an SP-initiated login for the session at the IdP up to the ACS
+func (p *testIdP) login(t *testing.T, s *SAMLService, org *models.Organization, session *saml.Session) (string, error) {
+	t.Helper()
+
+	location, err := s.BeginLogin(org.ID, "")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	response, relayState := p.respond(t, location, session)
+	return s.ConsumeAssertion(org.ID, response, relayState)
+}
+
+func setupSAMLTenant(t *testing.T) (*SAMLService, *testIdP, *models.Organization) {
+	t.Helper()
+
+	setupTestDB(t)
+	resolver := useStubResolver(t)
+	s := NewSAMLService(testConfig(t))
+
+	org := createDomainOrganization(t, "Acme", "acme.test")
+	owner := createTestUser(t, "owner@acme.test", org)
+	verifyTestDomain(t, resolver, org, owner)
+
+	idp := newTestIdP(t)
+	idp.connect(t, s, org, owner)
+	return s, idp, org
+}
+
+func TestSAMLLoginProvisionsAddressesAtTheVerifiedDomain(t *testing.T) {
+	s, idp, org := setupSAMLTenant(t)
+
+	redirect, err := idp.login(t, s, org, &saml.Session{
+		NameID:         "alice-persistent-id",
+		NameIDFormat:   string(saml.PersistentNameIDFormat),
+		UserEmail:      "alice@acme.test",
+		UserCommonName: "Alice",
+	})
+	if err != nil {
+		t.Fatalf("ConsumeAssertion: %v", err)
+	}
+	location, err := url.Parse(redirect)
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("expected a redirect carrying a login code, got %q", redirect)
+	}
+
+	response, err := s.ExchangeCode(&models.SAMLExchangeRequest{Code: location.Query().Get("code")})
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	if response.User.Email != "alice@acme.test" || response.User.Name != "Alice" || !response.User.IsVerified {
+		t.Errorf("provisioned %+v", response.User)
+	}
+	if response.User.OrganizationID == nil || *response.User.OrganizationID != org.ID {
+		t.Errorf("signed into organization %v, want %d", response.User.OrganizationID, org.ID)
+	}
+
+	var link models.SAMLIdentity
+	if err := database.GetDB().Where("user_id = ?", response.User.ID).First(&link).Error; err != nil {
+		t.Fatalf("no saml identity linked: %v", err)
+	}
+	if link.NameID != "alice-persistent-id" {
+		t.Errorf("linked name id %q", link.NameID)
+	}
+
+	// The code is single use
+	if _, err := s.ExchangeCode(&models.SAMLExchangeRequest{Code: location.Query().Get("code")}); err == nil {
+		t.Error("expected the login code to be refused the second time")
+	}
+}
+
+func TestSAMLLoginRefusesAddressesOutsideTheVerifiedDomain(t *testing.T) {
+	s, idp, org := setupSAMLTenant(t)
+	createTestUser(t, "bob@other.test", org)
+
+	// No account is created for an address the organization does not own
+	_, err := idp.login(t, s, org, &saml.Session{NameID: "mallory", UserEmail: "mallory@other.test"})
+	if err == nil || !strings.HasPrefix(err.Error(), "no account is linked to this saml identity") {
+		t.Fatalf("expected an unknown outside address to be refused, got %v", err)
+	}
+	var count int64
+	database.GetDB().Model(&models.User{}).Where("email = ?", "mallory@other.test").Count(&count)
+	if count != 0 {
+		t.Error("an account was created for an address outside the verified domain")
+	}
+
+	// Nor is an existing member's account claimed by email alone
+	_, err = idp.login(t, s, org, &saml.Session{NameID: "bob", UserEmail: "bob@other.test"})
+	if err == nil || !strings.HasPrefix(err.Error(), "no account is linked to this saml identity") {
+		t.Fatalf("expected a member outside the verified domain to be refused, got %v", err)
+	}
+}
+
+func TestSAMLLinkLetsMembersOutsideTheVerifiedDomainSignIn(t *testing.T) {
+	s, idp, org := setupSAMLTenant(t)
+	member := createTestUser(t, "bob@other.test", org)
+	outsider := createTestUser(t, "eve@other.test", createTestOrganization(t, "Other"))
+
+	link := func(user *models.User, session *saml.Session) (string, error) {
+		t.Helper()
+		started, err := s.BeginLink(user.ID, &models.SAMLLinkRequest{OrganizationID: org.ID})
+		if err != nil {
+			t.Fatalf("BeginLink: %v", err)
+		}
+		response, relayState := idp.respond(t, started.AuthorizationURL, session)
+		return s.ConsumeAssertion(org.ID, response, relayState)
+	}
+
+	if _, err := link(outsider, &saml.Session{NameID: "eve", NameIDFormat: string(saml.PersistentNameIDFormat), UserEmail: "eve@other.test"}); err == nil || err.Error() != "not a member of this organization" {
+		t.Fatalf("expected a non-member to be refused, got %v", err)
+	}
+	if _, err := link(member, &saml.Session{NameID: "bob", UserEmail: "bob@other.test"}); err == nil || err.Error() != "the idp sends transient name ids, which cannot be linked" {
+		t.Fatalf("expected a transient name id to be refused, got %v", err)
+	}
+
+	redirect, err := link(member, &saml.Session{NameID: "bob", NameIDFormat: string(saml.PersistentNameIDFormat), UserEmail: "bob@other.test"})
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if !strings.Contains(redirect, "/account/identities") || strings.Contains(redirect, "code=") {
+		t.Errorf("expected the link to return to the identities page without a login code, got %q", redirect)
+	}
+
+	redirect, err = idp.login(t, s, org, &saml.Session{NameID: "bob", NameIDFormat: string(saml.PersistentNameIDFormat), UserEmail: "bob@other.test"})
+	if err != nil {
+		t.Fatalf("ConsumeAssertion after linking: %v", err)
+	}
+	location, _ := url.Parse(redirect)
+	response, err := s.ExchangeCode(&models.SAMLExchangeRequest{Code: location.Query().Get("code")})
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	if response.User.ID != member.ID {
+		t.Errorf("signed in user %d, want %d", response.User.ID, member.ID)
+	}
+}
+
+func TestSAMLLoginCodeIsConsumedWhenACheckFails(t *testing.T) {
+	s, idp, org := setupSAMLTenant(t)
+
+	redirect, err := idp.login(t, s, org, &saml.Session{NameID: "carol", UserEmail: "carol@acme.test"})
+	if err != nil {
+		t.Fatalf("ConsumeAssertion: %v", err)
+	}
+	location, _ := url.Parse(redirect)
+	code := location.Query().Get("code")
+
+	settings := models.OrganizationSettings{OrganizationID: org.ID, AllowedLoginMethods: []string{models.LoginMethodPassword}}
+	if err := database.GetDB().Create(&settings).Error; err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	if _, err := s.ExchangeCode(&models.SAMLExchangeRequest{Code: code}); err == nil || err.Error() != "login method is not allowed by your organization" {
+		t.Fatalf("expected the organization to refuse the login method, got %v", err)
+	}
+
+	// Allowing the method again does not bring the refused code back
+	if err := database.GetDB().Model(&settings).Update("allowed_login_methods", nil).Error; err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+	if _, err := s.ExchangeCode(&models.SAMLExchangeRequest{Code: code}); err != errInvalidSAMLLogin {
+		t.Errorf("expected the used login code to be refused, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"kepler-auth-go/internal/database"
 	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/ratelimit"
+	"kepler-auth-go/internal/tokens"
 	"math"
 
 	"gorm.io/gorm"
@@ -19,12 +21,8 @@ func (s *UserService) GetUsers(query *models.PaginationQuery, organizationID *ui
 	var users []models.User
 	var total int64
 
-	db := database.GetDB().Model(&models.User{}).Preload("Groups").Preload("Organization")
-
 	// Filter by organization
-	if organizationID != nil {
-		db = db.Where("organization_id = ?", *organizationID)
-	}
+	db := scopeUsers(database.GetDB().Model(&models.User{}), organizationID)
 
 	if query.Search != "" {
 		db = db.Where("name ILIKE ? OR email ILIKE ? OR phone_number ILIKE ?",
@@ -57,6 +55,7 @@ func (s *UserService) GetUsers(query *models.PaginationQuery, organizationID *ui
 
 	userResponses := make([]models.UserResponse, len(users))
 	for i, user := range users {
+		viewFromOrganization(&user, organizationID)
 		userResponses[i] = s.toUserResponse(&user)
 	}
 
@@ -73,34 +72,23 @@ func (s *UserService) GetUsers(query *models.PaginationQuery, organizationID *ui
 
 func (s *UserService) GetUserByID(id uint, organizationID *uint) (*models.UserResponse, error) {
 	var user models.User
-	db := database.GetDB().Preload("Groups").Preload("Organization")
-
 	// Filter by organization if provided
-	if organizationID != nil {
-		db = db.Where("organization_id = ?", *organizationID)
-	}
-
-	if err := db.First(&user, id).Error; err != nil {
+	if err := scopeUsers(database.GetDB(), organizationID).First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("user not found")
 		}
 		return nil, err
 	}
 
+	viewFromOrganization(&user, organizationID)
 	response := s.toUserResponse(&user)
 	return &response, nil
 }
 
 func (s *UserService) UpdateUser(id uint, req *models.UserUpdateRequest, organizationID *uint) (*models.UserResponse, error) {
 	var user models.User
-	db := database.GetDB()
-
 	// Filter by organization if provided
-	if organizationID != nil {
-		db = db.Where("organization_id = ?", *organizationID)
-	}
-
-	if err := db.First(&user, id).Error; err != nil {
+	if err := scopeUsers(database.GetDB(), organizationID).First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("user not found")
 		}
@@ -132,7 +120,13 @@ func (s *UserService) UpdateUser(id uint, req *models.UserUpdateRequest, organiz
 	if req.SendEmail != nil {
 		updates["send_email"] = *req.SendEmail
 	}
-	if req.OrganizationID != nil {
+	movedOrganization := req.OrganizationID != nil && (user.OrganizationID == nil || *user.OrganizationID != *req.OrganizationID) &&
+		(organizationID == nil || *organizationID != *req.OrganizationID)
+	if movedOrganization {
+		// Organization admins cannot hand their users to another tenant
+		if organizationID != nil {
+			return nil, errors.New("cannot move user to another organization")
+		}
 		// Validate organization exists
 		var org models.Organization
 		if err := database.GetDB().First(&org, *req.OrganizationID).Error; err != nil {
@@ -141,47 +135,132 @@ func (s *UserService) UpdateUser(id uint, req *models.UserUpdateRequest, organiz
 		updates["organization_id"] = *req.OrganizationID
 	}
 
-	if err := database.GetDB().Model(&user).Updates(updates).Error; err != nil {
-		return nil, err
+	var previousOrganizationID *uint
+	if user.OrganizationID != nil {
+		previous := *user.OrganizationID
+		previousOrganizationID = &previous
 	}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if !movedOrganization {
+			return nil
+		}
 
-	if err := database.GetDB().Preload("Groups").Preload("Organization").First(&user, id).Error; err != nil {
+		// The role belonged to the old organization, the user starts over as a member
+		if previousOrganizationID != nil {
+			if err := removeMembership(tx, *previousOrganizationID, user.ID); err != nil {
+				return err
+			}
+		}
+		return addMembership(tx, *req.OrganizationID, user.ID, models.OrgRoleMember)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	response := s.toUserResponse(&user)
-	return &response, nil
+	return s.GetUserByID(id, organizationID)
 }
 
+// DeleteUser deactivates the account. Within an organization, a user who also belongs to
+// other organizations only loses their membership of this one.
 func (s *UserService) DeleteUser(id uint, organizationID *uint) error {
 	var user models.User
-	db := database.GetDB()
-
 	// Filter by organization if provided
-	if organizationID != nil {
-		db = db.Where("organization_id = ?", *organizationID)
+	if err := scopeUsers(database.GetDB(), organizationID).Preload("Memberships").First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	if organizationID != nil && len(user.Memberships) > 1 {
+		return database.GetDB().Transaction(func(tx *gorm.DB) error {
+			return leaveOrganization(tx, &user, *organizationID)
+		})
 	}
 
-	if err := db.First(&user, id).Error; err != nil {
+	if err := database.GetDB().Model(&user).Update("is_deleted", true).Error; err != nil {
+		return err
+	}
+
+	return tokens.Revocations.RevokeUser(user.ID)
+}
+
+// UnlockUser clears the user's failed login counter and any lockout
+func (s *UserService) UnlockUser(id uint, organizationID *uint) error {
+	var user models.User
+	// Filter by organization if provided
+	if err := scopeUsers(database.GetDB(), organizationID).First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("user not found")
 		}
 		return err
 	}
 
-	return database.GetDB().Model(&user).Update("is_deleted", true).Error
+	return ratelimit.Logins.Reset(ratelimit.AccountKey(user.Email))
+}
+
+// scopeUsers limits a users query to members of the caller's organization and preloads what a
+// response shows: the user's groups in that organization plus global ones
+func scopeUsers(db *gorm.DB, organizationID *uint) *gorm.DB {
+	if organizationID == nil {
+		return db.Preload("Groups.Permissions").Preload("Organization")
+	}
+	return db.Where("users.id IN (SELECT user_id FROM organization_members WHERE organization_id = ?)", *organizationID).
+		Preload("Groups", "organization_id IS NULL OR organization_id = ?", *organizationID).
+		Preload("Groups.Permissions").
+		Preload("Organization", "id = ?", *organizationID)
+}
+
+// viewFromOrganization presents a user loaded through scopeUsers as a member of the caller's
+// organization, so responses do not reveal which other organizations they belong to
+func viewFromOrganization(user *models.User, organizationID *uint) {
+	if organizationID == nil {
+		return
+	}
+	id := *organizationID
+	user.OrganizationID = &id
+}
+
+// leaveOrganization removes the user from an organization and its groups, moving their default
+// to another membership when it pointed at this one
+func leaveOrganization(tx *gorm.DB, user *models.User, organizationID uint) error {
+	if err := removeMembership(tx, organizationID, user.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Exec(`DELETE FROM user_groups WHERE user_id = ? AND group_id IN
+		(SELECT id FROM groups WHERE organization_id = ?)`, user.ID, organizationID).Error; err != nil {
+		return err
+	}
+
+	if user.OrganizationID == nil || *user.OrganizationID != organizationID {
+		return nil
+	}
+
+	var next *uint
+	for _, member := range user.Memberships {
+		if member.OrganizationID != organizationID {
+			id := member.OrganizationID
+			next = &id
+			break
+		}
+	}
+	return tx.Model(&models.User{}).Where("id = ?", user.ID).Update("organization_id", next).Error
 }
 
 func (s *UserService) toUserResponse(user *models.User) models.UserResponse {
 	// Collect all permissions from user's groups
-	permissions := make([]int, 0)
+	permissions := make([]uint, 0)
 	for _, group := range user.Groups {
-		permissions = append(permissions, group.Permissions...)
+		permissions = append(permissions, group.PermissionIDs...)
 	}
 
 	// Remove duplicates
-	permissionSet := make(map[int]bool)
-	uniquePermissions := make([]int, 0)
+	permissionSet := make(map[uint]bool)
+	uniquePermissions := make([]uint, 0)
 	for _, perm := range permissions {
 		if !permissionSet[perm] {
 			permissionSet[perm] = true
@@ -204,7 +283,9 @@ func (s *UserService) toUserResponse(user *models.User) models.UserResponse {
 		IsDeleted:      user.IsDeleted,
 		IsStaff:        user.IsStaff,
 		IsAdmin:        user.IsAdmin,
+		IsSuperuser:    user.IsSuperuser,
 		IsActive:       user.IsActive,
+		MFAEnabled:     user.MFAEnabled,
 		OrganizationID: user.OrganizationID,
 		Organization:   user.Organization,
 		Status:         user.GetStatus(),
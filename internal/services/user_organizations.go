@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// GetUserOrganizations lists the organizations the user is a member of, marking the one
+// the current token is scoped to
+func (s *AuthService) GetUserOrganizations(userID uint, currentOrganizationID *uint) ([]models.UserOrganizationResponse, error) {
+	var members []models.OrganizationMember
+	if err := database.GetDB().Preload("Organization").Where("user_id = ?", userID).
+		Order("organization_id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	organizations := make([]models.UserOrganizationResponse, 0, len(members))
+	for _, member := range members {
+		if member.Organization == nil {
+			continue
+		}
+		organizations = append(organizations, models.UserOrganizationResponse{
+			ID:        member.OrganizationID,
+			Name:      member.Organization.Name,
+			Domain:    member.Organization.Domain,
+			Role:      member.Role,
+			IsCurrent: currentOrganizationID != nil && *currentOrganizationID == member.OrganizationID,
+		})
+	}
+
+	return organizations, nil
+}
+
+// SwitchOrganization issues a new session scoped to another of the user's organizations and
+// makes it their default. The current session's login method must be one the target
+// organization allows. Tokens already issued for the previous organization stay valid.
+func (s *AuthService) SwitchOrganization(userID uint, req *models.SwitchOrganizationRequest) (*models.LoginResponse, error) {
+	var response *models.LoginResponse
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("user not found")
+			}
+			return err
+		}
+
+		if user.IsDeleted || !user.IsActive {
+			return errors.New("account is deactivated")
+		}
+
+		organizationID := req.OrganizationID
+		if err := scopeToOrganization(tx, &user, &organizationID); err != nil {
+			return err
+		}
+
+		// Sessions from before login methods were recorded have to sign in again
+		if req.LoginMethod == "" && !user.IsSuperuser {
+			return errors.New("sign in again to switch organization")
+		}
+		if err := s.checkLoginAllowed(&user, req.LoginMethod, req.ClientIP); err != nil {
+			return err
+		}
+
+		if !user.IsVerified && s.requiresVerifiedEmail(&user) {
+			return errors.New("email address is not verified")
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("organization_id", organizationID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		response, err = s.issueSession(tx, &user, req.LoginMethod)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
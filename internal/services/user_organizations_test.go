@@ -0,0 +1,49 @@
+package services
+
+import (
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"testing"
+)
+
+func TestSwitchOrganizationChecksTheTargetsLoginMethods(t *testing.T) {
+	setupTestDB(t)
+	s := NewAuthService(testConfig(t))
+
+	home := createTestOrganization(t, "Home")
+	ssoOnly := createTestOrganization(t, "SSO Only")
+	if err := database.GetDB().Create(&models.OrganizationSettings{
+		OrganizationID:      ssoOnly.ID,
+		AllowedLoginMethods: []string{models.LoginMethodSAML},
+	}).Error; err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	user := createTestUser(t, "member@example.test", home, ssoOnly)
+
+	_, err := s.SwitchOrganization(user.ID, &models.SwitchOrganizationRequest{OrganizationID: ssoOnly.ID, LoginMethod: models.LoginMethodPassword})
+	if err == nil || err.Error() != "login method is not allowed by your organization" {
+		t.Fatalf("expected a password session to be refused, got %v", err)
+	}
+
+	_, err = s.SwitchOrganization(user.ID, &models.SwitchOrganizationRequest{OrganizationID: ssoOnly.ID})
+	if err == nil || err.Error() != "sign in again to switch organization" {
+		t.Fatalf("expected a session without a login method to be refused, got %v", err)
+	}
+
+	response, err := s.SwitchOrganization(user.ID, &models.SwitchOrganizationRequest{OrganizationID: ssoOnly.ID, LoginMethod: models.LoginMethodSAML})
+	if err != nil {
+		t.Fatalf("SwitchOrganization: %v", err)
+	}
+	if response.User.OrganizationID == nil || *response.User.OrganizationID != ssoOnly.ID {
+		t.Errorf("switched into organization %v, want %d", response.User.OrganizationID, ssoOnly.ID)
+	}
+
+	// The new session remembers the method, so refreshing it keeps it
+	var refresh models.RefreshToken
+	if err := database.GetDB().Where("user_id = ?", user.ID).Last(&refresh).Error; err != nil {
+		t.Fatalf("no refresh token issued: %v", err)
+	}
+	if refresh.LoginMethod != models.LoginMethodSAML {
+		t.Errorf("session recorded login method %q", refresh.LoginMethod)
+	}
+}
@@ -0,0 +1,450 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/tokens"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+// webAuthnUser adapts a user and their stored credentials to the webauthn.User interface
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+	handle      []byte // handle of the credential being used, when registered under a merged account
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	if u.handle != nil {
+		return u.handle
+	}
+	return webAuthnUserHandle(u.user.ID)
+}
+
+// useCredential presents the user under the handle the credential was registered with
+func (u *webAuthnUser) useCredential(rawID []byte) {
+	for _, c := range u.credentials {
+		if bytes.Equal(c.CredentialID, rawID) {
+			u.handle = credentialUserHandle(&c)
+			return
+		}
+	}
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// webAuthnUserHandle is the opaque user.id given to authenticators; it maps back to the user on discoverable login
+func webAuthnUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// credentialUserHandle is the handle the authenticator holds for a credential, which names the
+// account it was registered on even if that account has since been merged into another
+func credentialUserHandle(credential *models.WebAuthnCredential) []byte {
+	if len(credential.UserHandle) > 0 {
+		return credential.UserHandle
+	}
+	return webAuthnUserHandle(credential.UserID)
+}
+
+type WebAuthnService struct {
+	cfg         *config.Config
+	authService *AuthService
+}
+
+func NewWebAuthnService(cfg *config.Config) *WebAuthnService {
+	return &WebAuthnService{
+		cfg:         cfg,
+		authService: NewAuthService(cfg),
+	}
+}
+
+func (s *WebAuthnService) relyingParty() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          s.cfg.WebAuthn.RPID,
+		RPDisplayName: s.cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     s.cfg.WebAuthn.RPOrigins,
+	})
+}
+
+// BeginRegistration starts registering a new authenticator for the user
+func (s *WebAuthnService) BeginRegistration(userID uint) (*models.WebAuthnBeginResponse, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadUser(database.GetDB(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := rp.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.saveSession(&userID, models.WebAuthnCeremonyRegistration, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnBeginResponse{SessionToken: token, Options: creation}, nil
+}
+
+// FinishRegistration verifies the attestation response and stores the new credential
+func (s *WebAuthnService) FinishRegistration(userID uint, req *models.WebAuthnRegisterFinishRequest) (*models.WebAuthnCredential, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return nil, errors.New("invalid credential response")
+	}
+
+	var record *models.WebAuthnCredential
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		sessionRow, session, err := s.consumeSession(tx, req.SessionToken, models.WebAuthnCeremonyRegistration)
+		if err != nil {
+			return err
+		}
+		if sessionRow.UserID == nil || *sessionRow.UserID != userID {
+			return errors.New("invalid or expired webauthn session")
+		}
+
+		user, err := s.loadUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		credential, err := rp.CreateCredential(user, *session, parsed)
+		if err != nil {
+			return errors.New("passkey registration failed")
+		}
+
+		name := req.Name
+		if name == "" {
+			name = "Passkey"
+		}
+
+		transports := make([]string, len(credential.Transport))
+		for i, t := range credential.Transport {
+			transports[i] = string(t)
+		}
+
+		record = &models.WebAuthnCredential{
+			UserID:          userID,
+			Name:            name,
+			CredentialID:    credential.ID,
+			UserHandle:      user.WebAuthnID(),
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			AAGUID:          credential.Authenticator.AAGUID,
+			SignCount:       credential.Authenticator.SignCount,
+			Transports:      transports,
+			Flags:           uint8(credential.Flags.ProtocolValue()),
+			BackupEligible:  credential.Flags.BackupEligible,
+			BackupState:     credential.Flags.BackupState,
+		}
+
+		if err := tx.Create(record).Error; err != nil {
+			return errors.New("credential is already registered")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// BeginLogin starts a passkey login. When the email matches a user with registered
+// credentials they are offered as allowCredentials, otherwise any discoverable passkey
+// is accepted so the response does not reveal whether the account exists.
+func (s *WebAuthnService) BeginLogin(req *models.WebAuthnLoginBeginRequest) (*models.WebAuthnBeginResponse, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	var user *webAuthnUser
+	if req.Email != "" {
+		var found models.User
+		if err := database.GetDB().Where("email = ?", req.Email).First(&found).Error; err == nil {
+			if candidate, err := s.loadUser(database.GetDB(), found.ID); err == nil && len(candidate.credentials) > 0 {
+				user = candidate
+			}
+		}
+	}
+
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var userID *uint
+	if user != nil {
+		assertion, session, err = rp.BeginLogin(user)
+		userID = &user.user.ID
+	} else {
+		assertion, session, err = rp.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.saveSession(userID, models.WebAuthnCeremonyLogin, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnBeginResponse{SessionToken: token, Options: assertion}, nil
+}
+
+// FinishLogin verifies the assertion and issues the same tokens as a password login.
+// A user-verifying passkey already combines two factors, so no TOTP challenge follows.
+func (s *WebAuthnService) FinishLogin(req *models.WebAuthnLoginFinishRequest) (*models.LoginResponse, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, errors.New("invalid credential response")
+	}
+
+	var response *models.LoginResponse
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		sessionRow, session, err := s.consumeSession(tx, req.SessionToken, models.WebAuthnCeremonyLogin)
+		if err != nil {
+			return err
+		}
+
+		var user *webAuthnUser
+		var credential *webauthn.Credential
+		if sessionRow.UserID != nil {
+			if user, err = s.loadUser(tx, *sessionRow.UserID); err != nil {
+				return errors.New("passkey verification failed")
+			}
+			// The session is bound to this user, so it may follow a credential registered under
+			// an account that was merged into theirs
+			user.useCredential(parsed.RawID)
+			session.UserID = user.WebAuthnID()
+			credential, err = rp.ValidateLogin(user, *session, parsed)
+		} else {
+			credential, err = rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+				user, err = s.findUserByCredential(tx, rawID, userHandle)
+				return user, err
+			}, *session, parsed)
+		}
+		if err != nil {
+			return errors.New("passkey verification failed")
+		}
+
+		if credential.Authenticator.CloneWarning {
+			return errors.New("passkey verification failed: authenticator may be cloned")
+		}
+
+		if user.user.IsDeleted || !user.user.IsActive {
+			return errors.New("account is deactivated")
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.WebAuthnCredential{}).
+			Where("credential_id = ?", credential.ID).
+			Updates(map[string]interface{}{
+				"sign_count":   credential.Authenticator.SignCount,
+				"backup_state": credential.Flags.BackupState,
+				"last_used_at": now,
+			}).Error; err != nil {
+			return err
+		}
+
+		var full models.User
+		if err := tx.Preload("Groups.Permissions").Preload("Organization.Settings").First(&full, user.user.ID).Error; err != nil {
+			return err
+		}
+
+		if err := s.authService.checkLoginAllowed(&full, models.LoginMethodWebAuthn, req.ClientIP); err != nil {
+			return err
+		}
+
+		if !full.IsVerified && s.authService.requiresVerifiedEmail(&full) {
+			return errors.New("email address is not verified")
+		}
+
+		response, err = s.authService.issueSession(tx, &full, models.LoginMethodWebAuthn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// GetCredentials lists the user's registered authenticators
+func (s *WebAuthnService) GetCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	if err := database.GetDB().Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpdateCredential renames one of the user's authenticators
+func (s *WebAuthnService) UpdateCredential(userID, id uint, req *models.WebAuthnCredentialUpdateRequest) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := database.GetDB().Where("id = ? AND user_id = ?", id, userID).First(&credential).Error; err != nil {
+		return nil, errors.New("credential not found")
+	}
+
+	if err := database.GetDB().Model(&credential).Update("name", req.Name).Error; err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// DeleteCredential removes one of the user's authenticators
+func (s *WebAuthnService) DeleteCredential(userID, id uint) error {
+	result := database.GetDB().Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("credential not found")
+	}
+	return nil
+}
+
+func (s *WebAuthnService) loadUser(tx *gorm.DB, userID uint) (*webAuthnUser, error) {
+	var user models.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := tx.Where("user_id = ?", userID).Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{user: &user, credentials: credentials}, nil
+}
+
+func (s *WebAuthnService) findUserByCredential(tx *gorm.DB, rawID, userHandle []byte) (*webAuthnUser, error) {
+	var credential models.WebAuthnCredential
+	if err := tx.Where("credential_id = ?", rawID).First(&credential).Error; err != nil {
+		return nil, errors.New("credential not found")
+	}
+
+	handle := credentialUserHandle(&credential)
+	if !bytes.Equal(handle, userHandle) {
+		return nil, errors.New("credential does not belong to user")
+	}
+
+	user, err := s.loadUser(tx, credential.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.handle = handle
+	return user, nil
+}
+
+func (s *WebAuthnService) saveSession(userID *uint, ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	raw, hash, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	row := &models.WebAuthnSession{
+		UserID:    userID,
+		TokenHash: hash,
+		Ceremony:  ceremony,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(webAuthnSessionTTL),
+	}
+
+	if err := database.GetDB().Create(row).Error; err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// consumeSession loads a ceremony session and deletes it so the challenge cannot be answered twice
+func (s *WebAuthnService) consumeSession(tx *gorm.DB, token, ceremony string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	var row models.WebAuthnSession
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND ceremony = ?", tokens.Hash(token), ceremony).
+		First(&row).Error; err != nil {
+		return nil, nil, errors.New("invalid or expired webauthn session")
+	}
+
+	if err := tx.Delete(&row).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(row.ExpiresAt) {
+		return nil, nil, errors.New("invalid or expired webauthn session")
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(row.Data), &session); err != nil {
+		return nil, nil, err
+	}
+
+	return &row, &session, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// testAuthenticator is a software passkey registered directly in the database
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	handle    []byte
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T, user *models.User) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate authenticator key: %v", err)
+	}
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode authenticator key: %v", err)
+	}
+
+	a := &testAuthenticator{key: key, id: []byte("test-credential"), handle: webAuthnUserHandle(user.ID)}
+	if err := database.GetDB().Create(&models.WebAuthnCredential{
+		UserID:       user.ID,
+		Name:         "Test key",
+		CredentialID: a.id,
+		UserHandle:   a.handle,
+		PublicKey:    publicKey,
+	}).Error; err != nil {
+		t.Fatalf("failed to register authenticator: %v", err)
+	}
+	return a
+}
+
+// assert signs the challenge of a login ceremony the way a browser and authenticator would
+func (a *testAuthenticator) assert(t *testing.T, begin *models.WebAuthnBeginResponse, rpID, origin string) json.RawMessage {
+	t.Helper()
+
+	options, ok := begin.Options.(*protocol.CredentialAssertion)
+	if !ok {
+		t.Fatalf("unexpected login options %T", begin.Options)
+	}
+	clientData, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": options.Response.Challenge.String(),
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode client data: %v", err)
+	}
+
+	a.signCount++
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], byte(protocol.FlagUserPresent|protocol.FlagUserVerified))
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	credential, err := json.Marshal(map[string]interface{}{
+		"id":    encode(a.id),
+		"rawId": encode(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(a.handle),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode credential: %v", err)
+	}
+	return credential
+}
+
+func TestPasskeyLoginAppliesTheIPAllowlist(t *testing.T) {
+	setupTestDB(t)
+	cfg := testConfig(t)
+	s := NewWebAuthnService(cfg)
+
+	org := createTestOrganization(t, "Acme")
+	user := createTestUser(t, "alice@example.test", org)
+	if err := database.GetDB().Create(&models.OrganizationSettings{OrganizationID: org.ID, IPAllowlist: []string{"10.0.0.0/8"}}).Error; err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	authenticator := newTestAuthenticator(t, user)
+
+	login := func(clientIP string) (*models.LoginResponse, error) {
+		t.Helper()
+		begin, err := s.BeginLogin(&models.WebAuthnLoginBeginRequest{Email: user.Email})
+		if err != nil {
+			t.Fatalf("BeginLogin: %v", err)
+		}
+		return s.FinishLogin(&models.WebAuthnLoginFinishRequest{
+			SessionToken: begin.SessionToken,
+			Credential:   authenticator.assert(t, begin, cfg.WebAuthn.RPID, cfg.WebAuthn.RPOrigins[0]),
+			ClientIP:     clientIP,
+		})
+	}
+
+	if _, err := login("192.0.2.1"); err == nil || err.Error() != "access from this ip address is not allowed" {
+		t.Fatalf("expected a login from outside the allowlist to be refused, got %v", err)
+	}
+
+	response, err := login("10.1.2.3")
+	if err != nil {
+		t.Fatalf("FinishLogin from inside the allowlist: %v", err)
+	}
+	if response.User.ID != user.ID {
+		t.Errorf("signed in user %d, want %d", response.User.ID, user.ID)
+	}
+}
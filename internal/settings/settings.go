@@ -0,0 +1,66 @@
+package settings
+
+import (
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/models"
+	"kepler-auth-go/internal/password"
+)
+
+// FromConfig builds the global settings from the environment configuration
+func FromConfig(cfg *config.Config) models.EffectiveSettings {
+	return models.EffectiveSettings{
+		AccessTokenLifetime:      cfg.JWT.Expiration,
+		RefreshTokenLifetime:     cfg.JWT.RefreshExpiration,
+		SessionIdleTimeout:       cfg.Security.SessionIdleTimeout,
+		RequireMFA:               cfg.MFA.Required,
+		RequireEmailVerification: cfg.Security.RequireEmailVerification,
+		PasswordPolicy:           password.FromConfig(cfg),
+		AllowedLoginMethods:      cfg.Security.LoginMethods,
+		IPAllowlist:              cfg.Security.IPAllowlist,
+		AllowSelfRegistration:    cfg.Security.AllowSelfRegistration,
+	}
+}
+
+// With returns the settings with an organization's overrides applied
+func With(s models.EffectiveSettings, o *models.OrganizationSettings) models.EffectiveSettings {
+	if o == nil {
+		return s
+	}
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setInt(&s.AccessTokenLifetime, o.AccessTokenLifetime)
+	setInt(&s.RefreshTokenLifetime, o.RefreshTokenLifetime)
+	setInt(&s.SessionIdleTimeout, o.SessionIdleTimeout)
+	setBool(&s.RequireMFA, o.RequireMFA)
+	setBool(&s.RequireEmailVerification, o.RequireEmailVerification)
+	setBool(&s.AllowSelfRegistration, o.AllowSelfRegistration)
+	s.PasswordPolicy = s.PasswordPolicy.With(o.PasswordPolicy)
+	if o.AllowedLoginMethods != nil {
+		s.AllowedLoginMethods = o.AllowedLoginMethods
+	}
+	if o.IPAllowlist != nil {
+		s.IPAllowlist = o.IPAllowlist
+	}
+	return s
+}
+
+// For resolves the settings that apply within an organization, or the global settings for nil.
+// The organization's Settings must be preloaded. Overrides saved before JWT_MAX_EXPIRATION was
+// lowered are capped, since signing keys are only kept for that long.
+func For(cfg *config.Config, org *models.Organization) models.EffectiveSettings {
+	effective := FromConfig(cfg)
+	if org == nil {
+		return effective
+	}
+	effective = With(effective, org.Settings)
+	effective.AccessTokenLifetime = min(effective.AccessTokenLifetime, cfg.JWT.MaxAccessTokenLifetime())
+	return effective
+}
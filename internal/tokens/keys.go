@@ -0,0 +1,375 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"kepler-auth-go/internal/config"
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// keyRefreshInterval controls how often keys rotated by other instances are picked up
+	keyRefreshInterval = time.Minute
+	// signingKeyLockID serializes key rotation across instances sharing a database
+	signingKeyLockID = 7_351_202
+)
+
+// signingKey is a SigningKey record with its private key parsed
+type signingKey struct {
+	record models.SigningKey
+	signer crypto.Signer
+	method jwt.SigningMethod
+}
+
+// KeyManager signs tokens with the active key and verifies them by kid.
+// With the HS256 algorithm it falls back to the shared JWT secret and publishes no keys.
+type KeyManager struct {
+	cfg      *config.Config
+	mu       sync.RWMutex
+	keys     map[string]*signingKey
+	active   *signingKey
+	loadedAt time.Time
+}
+
+var keyManager *KeyManager
+
+// LoadKeys initializes the process wide key manager, generating a first key if needed
+func LoadKeys(cfg *config.Config) error {
+	m := NewKeyManager(cfg)
+	if err := m.Load(); err != nil {
+		return err
+	}
+	keyManager = m
+	return nil
+}
+
+func GetKeyManager() *KeyManager {
+	return keyManager
+}
+
+func NewKeyManager(cfg *config.Config) *KeyManager {
+	return &KeyManager{
+		cfg:  cfg,
+		keys: make(map[string]*signingKey),
+	}
+}
+
+func (m *KeyManager) isSymmetric() bool {
+	return m.cfg.JWT.Algorithm == jwt.SigningMethodHS256.Alg()
+}
+
+// Load reads the published keys from the database, rotating first if the active key is due
+func (m *KeyManager) Load() error {
+	if m.isSymmetric() {
+		return nil
+	}
+
+	if _, err := signingMethod(m.cfg.JWT.Algorithm); err != nil {
+		return err
+	}
+
+	records, err := m.fetch(database.GetDB())
+	if err != nil {
+		return err
+	}
+
+	if m.needsRotation(records) {
+		if err := m.rotate(false); err != nil {
+			return err
+		}
+		if records, err = m.fetch(database.GetDB()); err != nil {
+			return err
+		}
+	}
+
+	return m.install(records)
+}
+
+// Rotate retires the active key immediately and starts signing with a new one
+func (m *KeyManager) Rotate() error {
+	if m.isSymmetric() {
+		return errors.New("key rotation is not available for HS256")
+	}
+	if err := m.rotate(true); err != nil {
+		return err
+	}
+	return m.Load()
+}
+
+// StartRotation periodically reloads keys and rotates the active key once it is older
+// than the configured rotation interval
+func (m *KeyManager) StartRotation() {
+	if m.isSymmetric() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(keyRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := m.Load(); err != nil {
+				log.Printf("Failed to refresh signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// Sign serializes the claims into a JWT signed with the active key
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	if m.isSymmetric() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(m.cfg.JWT.Secret))
+	}
+
+	m.mu.RLock()
+	active := m.active
+	m.mu.RUnlock()
+
+	if active == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.record.KID
+	return token.SignedString(active.signer)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if m.isSymmetric() {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(m.cfg.JWT.Secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	key := m.lookup(kid)
+	if key == nil && m.staleFor(5*time.Second) {
+		// The key may have been created by another instance since the last refresh
+		if err := m.Load(); err != nil {
+			return nil, err
+		}
+		key = m.lookup(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.signer.Public(), nil
+}
+
+// ValidMethods lists the algorithms accepted when parsing tokens
+func (m *KeyManager) ValidMethods() []string {
+	return []string{m.cfg.JWT.Algorithm}
+}
+
+// JWKS returns the public half of every key that may still verify outstanding tokens
+func (m *KeyManager) JWKS() JWKS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(m.keys))}
+	for _, key := range m.keys {
+		jwk, err := NewJWK(key.record.KID, key.method.Alg(), key.signer.Public())
+		if err != nil {
+			log.Printf("Skipping signing key %s: %v", key.record.KID, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (m *KeyManager) lookup(kid string) *signingKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keys[kid]
+}
+
+func (m *KeyManager) staleFor(d time.Duration) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return time.Since(m.loadedAt) > d
+}
+
+func (m *KeyManager) fetch(db *gorm.DB) ([]models.SigningKey, error) {
+	var records []models.SigningKey
+	err := db.Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Find(&records).Error
+	return records, err
+}
+
+func (m *KeyManager) needsRotation(records []models.SigningKey) bool {
+	for _, record := range records {
+		if record.RetiredAt == nil && record.Algorithm == m.cfg.JWT.Algorithm {
+			return time.Since(record.CreatedAt) >= time.Duration(m.cfg.JWT.KeyRotation)*time.Second
+		}
+	}
+	return true
+}
+
+func (m *KeyManager) rotate(force bool) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyLockID).Error; err != nil {
+			return err
+		}
+
+		// Another instance may have rotated while we waited for the lock
+		records, err := m.fetch(tx)
+		if err != nil {
+			return err
+		}
+		if !force && !m.needsRotation(records) {
+			return nil
+		}
+
+		// Retired keys stay published until every token they signed has expired, including tokens
+		// issued under the longest lifetime an organization may set
+		now := time.Now()
+		if err := tx.Model(&models.SigningKey{}).Where("retired_at IS NULL").Updates(map[string]interface{}{
+			"retired_at": now,
+			"expires_at": now.Add(time.Duration(m.cfg.JWT.MaxAccessTokenLifetime()) * time.Second),
+		}).Error; err != nil {
+			return err
+		}
+
+		record, err := generateSigningKey(m.cfg.JWT.Algorithm)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+
+		log.Printf("Rotated signing key, new kid: %s", record.KID)
+		return nil
+	})
+}
+
+func (m *KeyManager) install(records []models.SigningKey) error {
+	keys := make(map[string]*signingKey, len(records))
+	var active *signingKey
+
+	for _, record := range records {
+		key, err := parseSigningKey(record)
+		if err != nil {
+			log.Printf("Ignoring signing key %s: %v", record.KID, err)
+			continue
+		}
+		keys[record.KID] = key
+
+		// records are ordered newest first
+		if active == nil && record.RetiredAt == nil && record.Algorithm == m.cfg.JWT.Algorithm {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return errors.New("no active signing key")
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.active = active
+	m.loadedAt = time.Now()
+	m.mu.Unlock()
+
+	return nil
+}
+
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		return jwt.SigningMethodRS256, nil
+	case jwt.SigningMethodES256.Alg():
+		return jwt.SigningMethodES256, nil
+	case jwt.SigningMethodEdDSA.Alg():
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+}
+
+func generateSigningKey(alg string) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:        kid,
+		Algorithm:  alg,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func parseSigningKey(record models.SigningKey) (*signingKey, error) {
+	method, err := signingMethod(record.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+
+	return &signingKey{record: record, signer: signer, method: method}, nil
+}
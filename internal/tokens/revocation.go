@@ -0,0 +1,74 @@
+package tokens
+
+import (
+	"kepler-auth-go/internal/database"
+	"kepler-auth-go/internal/models"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore keeps track of access tokens that must be rejected before they expire
+type RevocationStore interface {
+	// Revoke invalidates a single access token by its jti
+	Revoke(jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the access token with the given jti was revoked
+	IsRevoked(jti string) (bool, error)
+	// RevokeUser invalidates every access and refresh token issued to the user so far
+	RevokeUser(userID uint) error
+}
+
+// Revocations is the store consulted by the auth middleware
+var Revocations RevocationStore = NewDBRevocationStore()
+
+// DBRevocationStore persists revocations in Postgres
+type DBRevocationStore struct{}
+
+func NewDBRevocationStore() *DBRevocationStore {
+	return &DBRevocationStore{}
+}
+
+func (s *DBRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	db := database.GetDB()
+
+	// Opportunistically drop entries whose tokens would be rejected as expired anyway
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+func (s *DBRevocationStore) IsRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	var count int64
+	if err := database.GetDB().Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *DBRevocationStore) RevokeUser(userID uint) error {
+	db := database.GetDB()
+	now := time.Now()
+
+	// iat has second precision, so truncate to keep tokens issued right after this call valid
+	if err := db.Model(&models.User{}).Where("id = ?", userID).
+		Update("tokens_valid_after", now.Truncate(time.Second)).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}